@@ -1,14 +1,52 @@
 package adapters
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var DB *sql.DB
 
+// openMySQL traces the queries run inside a trace, like the ones of a
+// request. The statements are recorded with their placeholders, the
+// arguments are not.
+func openMySQL(url string) (*sql.DB, error) {
+	return otelsql.Open("mysql", url,
+		otelsql.WithAttributes(semconv.DBSystemMySQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+}
+
+// pingTimeout bounds the ping on load and the readiness check.
+const pingTimeout = 5 * time.Second
+
+// PingDatabase checks the database answers, opening a connection if the pool
+// has none.
+func PingDatabase(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not loaded")
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return DB.PingContext(ctx)
+}
+
 func LoadDatabase() {
 	var err error
 
@@ -21,12 +59,18 @@ func LoadDatabase() {
 		os.Getenv("DB_NAME"),
 	)
 
-	DB, err = sql.Open("mysql", DBURL)
+	DB, err = openMySQL(DBURL)
+	if err != nil {
+		slog.Error("failed to open the mysql database", "error", err)
+		return
+	}
+	err = PingDatabase(context.Background())
 	if err != nil {
-		log.Println("Error occcured:", err)
+		slog.Error("cannot reach the mysql database", "host", os.Getenv("DB_HOST"), "error", err)
+		return
 	}
 
-	log.Println("We are connected to the mysql database")
+	slog.Info("connected to the mysql database")
 }
 
 func LoadTestDatabase() {
@@ -41,11 +85,16 @@ func LoadTestDatabase() {
 		os.Getenv("TEST_DB_NAME"),
 	)
 
-	log.Println("Error occcured:", DBURL)
-	DB, err = sql.Open("mysql", DBURL)
+	DB, err = openMySQL(DBURL)
+	if err != nil {
+		slog.Error("failed to open the test mysql database", "error", err)
+		return
+	}
+	err = PingDatabase(context.Background())
 	if err != nil {
-		log.Println("Error occcured:", err)
+		slog.Error("cannot reach the test mysql database", "host", os.Getenv("TEST_DB_HOST"), "error", err)
+		return
 	}
 
-	log.Println("We are connected to the test mysql database")
+	slog.Info("connected to the test mysql database")
 }
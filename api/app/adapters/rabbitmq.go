@@ -5,65 +5,373 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/metrics"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var PublishMessages = func(messages []map[string]interface{}, controller string) error {
-	conn, err := amqp.Dial(fmt.Sprintf(
+// taskQueueArgs must match the arguments the worker declares task_queue with,
+// RabbitMQ refuses to redeclare a queue with different arguments.
+var taskQueueArgs = amqp.Table{
+	"x-dead-letter-exchange": "task_queue.dlx",
+}
+
+const taskQueue = "task_queue"
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// amqpConnection and amqpChannel are the parts of the amqp091 connection and
+// channel the publisher uses, tests replace the broker behind them.
+type amqpConnection interface {
+	Channel() (amqpChannel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	IsClosed() bool
+	Close() error
+}
+
+type amqpChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Confirm(noWait bool) error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+// confirmation is the broker's answer to one publishing.
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+type brokerConnection struct {
+	*amqp.Connection
+}
+
+func (c brokerConnection) Channel() (amqpChannel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return brokerChannel{ch}, nil
+}
+
+type brokerChannel struct {
+	*amqp.Channel
+}
+
+func (c brokerChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error) {
+	deferred, err := c.Channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		return nil, err
+	}
+	return deferred, nil
+}
+
+// Publisher keeps one long-lived connection and a pool of confirm-mode
+// channels. The pool holds poolSize slots, a nil slot is a channel that has to
+// be (re)opened, which happens lazily after a reconnect or a channel error.
+type Publisher struct {
+	dial  func() (amqpConnection, error)
+	sleep func(time.Duration)
+	pool  chan amqpChannel
+
+	mu        sync.RWMutex
+	conn      amqpConnection
+	lastError string
+	closed    bool
+}
+
+type PublisherHealth struct {
+	Connected bool   `json:"connected"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+var publisher *Publisher
+
+// PublishMessages sends the messages to the worker with the request id of
+// ctx in their headers.
+var PublishMessages = func(ctx context.Context, messages []map[string]interface{}, controller string) error {
+	if publisher == nil {
+		return errors.New("failed to connect to RabbitMQ")
+	}
+	return publisher.Publish(ctx, messages, controller)
+}
+
+func LoadPublisher() {
+	poolSize, err := strconv.Atoi(os.Getenv("RABBITMQ_CHANNEL_POOL_SIZE"))
+	if err != nil || poolSize <= 0 {
+		poolSize = 8
+	}
+	publisher = NewPublisher(fmt.Sprintf(
 		"amqp://%s:%s@%s:5672/",
 		os.Getenv("RABBITMQ_USER"),
 		os.Getenv("RABBITMQ_PASSWORD"),
 		os.Getenv("RABBITMQ_HOST"),
-	))
+	), poolSize)
+}
+
+func ClosePublisher() {
+	if publisher != nil {
+		publisher.Close()
+	}
+}
+
+func GetPublisherHealth() PublisherHealth {
+	if publisher == nil {
+		return PublisherHealth{LastError: "publisher not loaded"}
+	}
+	return publisher.Health()
+}
+
+// NewPublisher returns right away and connects in the background, so the API
+// can start while the broker is unreachable.
+func NewPublisher(url string, poolSize int) *Publisher {
+	dial := func() (amqpConnection, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		return brokerConnection{conn}, nil
+	}
+	return newPublisher(dial, time.Sleep, poolSize)
+}
+
+func newPublisher(dial func() (amqpConnection, error), sleep func(time.Duration), poolSize int) *Publisher {
+	p := &Publisher{
+		dial:  dial,
+		sleep: sleep,
+		pool:  make(chan amqpChannel, poolSize),
+	}
+	for i := 0; i < poolSize; i++ {
+		p.pool <- nil
+	}
+	go p.reconnect()
+	return p
+}
+
+func (p *Publisher) connect() (chan *amqp.Error, error) {
+	conn, err := p.dial()
 	if err != nil {
-		return errors.New("failed to connect to RabbitMQ")
+		return nil, err
 	}
-	defer conn.Close()
 	ch, err := conn.Channel()
 	if err != nil {
-		return errors.New("failed to open a channel")
-	}
-	defer ch.Close()
-	q, err := ch.QueueDeclare(
-		"task_queue", // name
-		true,         // durable
-		false,        // delete when unused
-		false,        // exclusive
-		false,        // no-wait
-		nil,          // arguments
+		conn.Close()
+		return nil, err
+	}
+	_, err = ch.QueueDeclare(
+		taskQueue,     // name
+		true,          // durable
+		false,         // delete when unused
+		false,         // exclusive
+		false,         // no-wait
+		taskQueueArgs, // arguments
 	)
+	ch.Close()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	closeNotifier := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return nil, errors.New("publisher closed")
+	}
+	p.conn = conn
+	p.lastError = ""
+	return closeNotifier, nil
+}
+
+// reconnect dials with exponential backoff and starts over whenever the
+// connection is closed by the broker.
+func (p *Publisher) reconnect() {
+	backoff := minReconnectDelay
+	for {
+		closeNotifier, err := p.connect()
+		if err != nil {
+			if p.isClosed() {
+				return
+			}
+			p.setError(err)
+			slog.Warn("failed to connect to rabbitmq", "error", err, "retry_in", backoff.String())
+			p.sleep(backoff)
+			backoff = min(backoff*2, maxReconnectDelay)
+			continue
+		}
+		backoff = minReconnectDelay
+		slog.Info("connected to rabbitmq")
+
+		closeErr := <-closeNotifier
+		if p.isClosed() {
+			return
+		}
+		if closeErr != nil {
+			p.setError(closeErr)
+		}
+		slog.Warn("rabbitmq connection closed", "error", closeErr)
+	}
+}
+
+func (p *Publisher) isClosed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
+}
+
+func (p *Publisher) setError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastError = err.Error()
+}
+
+func (p *Publisher) Health() PublisherHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return PublisherHealth{
+		Connected: p.conn != nil && !p.conn.IsClosed(),
+		LastError: p.lastError,
+	}
+}
+
+func (p *Publisher) openChannel() (amqpChannel, error) {
+	p.mu.RLock()
+	conn := p.conn
+	p.mu.RUnlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, errors.New("failed to connect to RabbitMQ")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, errors.New("failed to open a channel")
+	}
+	err = ch.Confirm(false)
 	if err != nil {
-		return errors.New("failed to declare a queue")
+		ch.Close()
+		return nil, errors.New("failed to put the channel in confirm mode")
+	}
+	return ch, nil
+}
+
+func (p *Publisher) acquire(ctx context.Context) (amqpChannel, error) {
+	var ch amqpChannel
+	select {
+	case ch = <-p.pool:
+	case <-ctx.Done():
+		return nil, errors.New("no channel available")
+	}
+	if ch != nil && !ch.IsClosed() {
+		return ch, nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ch, err := p.openChannel()
+	if err != nil {
+		p.pool <- nil
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (p *Publisher) release(ch amqpChannel, broken bool) {
+	if broken {
+		ch.Close()
+		ch = nil
+	}
+	p.pool <- ch
+}
+
+// Publish sends the messages to task_queue and returns once the broker has
+// confirmed every one of them. The request id of ctx goes in the request_id
+// header, the worker logs with it, and the publish span in the traceparent
+// header, the worker continues the trace from it.
+func (p *Publisher) Publish(ctx context.Context, messages []map[string]interface{}, controller string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, taskQueue+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(taskQueue),
+			semconv.MessagingBatchMessageCount(len(messages)),
+			attribute.String("messaging.controller", controller),
+		),
+	)
+	confirmed := 0
+	defer func() {
+		metrics.PublishedMessages.WithLabelValues(controller, "success").Add(float64(confirmed))
+		if err != nil {
+			metrics.PublishedMessages.WithLabelValues(controller, "failure").Add(float64(len(messages) - confirmed))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	requestID := logging.RequestID(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	ch, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	broken := false
+	defer func() { p.release(ch, broken) }()
+
 	for _, message := range messages {
 		jsonMessage, err := json.Marshal(message)
 		if err != nil {
 			return errors.New("failed to encode a message")
 		}
-		err = ch.PublishWithContext(ctx,
-			"",     // exchange
-			q.Name, // routing key
-			false,  // mandatory
-			false,  // immediate
+		headers := amqp.Table{
+			"controller": controller,
+			"request_id": requestID,
+		}
+		tracing.Inject(ctx, headers)
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+			"",        // exchange
+			taskQueue, // routing key
+			false,     // mandatory
+			false,     // immediate
 			amqp.Publishing{
 				DeliveryMode: 2,
 				ContentType:  "text/plain",
 				Body:         []byte(jsonMessage),
-				Headers: map[string]interface{}{
-					"controller": controller,
-				},
+				Headers:      headers,
 			})
 		if err != nil {
+			broken = true
 			return errors.New("failed to publish a message")
 		}
-		log.Printf(" [x] Sent %s\n", jsonMessage)
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil || !acked {
+			broken = err != nil
+			return errors.New("message was not confirmed by the broker")
+		}
+		confirmed++
+		logging.FromContext(ctx).Debug("published message", "controller", controller)
 	}
 	return nil
 }
+
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	p.closed = true
+	conn := p.conn
+	p.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,341 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// fakeBroker stands in for RabbitMQ, it refuses the first failDials dials and
+// answers every publishing with confirm.
+type fakeBroker struct {
+	mu         sync.Mutex
+	failDials  int
+	dials      int
+	delays     []time.Duration
+	conn       *fakeConnection
+	channels   []*fakeChannel
+	published  []amqp.Publishing
+	publishErr error
+	// confirm answers a publishing, nil confirmations never arrive
+	confirm *bool
+}
+
+func newFakeBroker() *fakeBroker {
+	acked := true
+	return &fakeBroker{confirm: &acked}
+}
+
+func (b *fakeBroker) dial() (amqpConnection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dials++
+	if b.dials <= b.failDials {
+		return nil, errors.New("connection refused")
+	}
+	b.conn = &fakeConnection{broker: b}
+	return b.conn, nil
+}
+
+func (b *fakeBroker) sleep(delay time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delays = append(b.delays, delay)
+}
+
+func (b *fakeBroker) setConfirm(acked *bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.confirm = acked
+}
+
+func (b *fakeBroker) setPublishErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publishErr = err
+}
+
+func (b *fakeBroker) dialCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dials
+}
+
+// confirmChannels returns the channels opened to publish, the first one of
+// each connection only declares the queue.
+func (b *fakeBroker) confirmChannels() []*fakeChannel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	channels := []*fakeChannel{}
+	for _, ch := range b.channels {
+		if ch.confirm {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+// drop closes the connection like a broker restart does.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restarted"})
+}
+
+type fakeConnection struct {
+	broker   *fakeBroker
+	closed   bool
+	notifies []chan *amqp.Error
+}
+
+func (c *fakeConnection) Channel() (amqpChannel, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeChannel{broker: c.broker, conn: c}
+	c.broker.channels = append(c.broker.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.notifies = append(c.notifies, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) IsClosed() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+func (c *fakeConnection) shutdown(reason *amqp.Error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	// the channels of a connection close with it
+	for _, ch := range c.broker.channels {
+		if ch.conn == c {
+			ch.closed = true
+		}
+	}
+	for _, receiver := range c.notifies {
+		if reason != nil {
+			receiver <- reason
+		}
+		close(receiver)
+	}
+}
+
+type fakeChannel struct {
+	broker  *fakeBroker
+	conn    *fakeConnection
+	confirm bool
+	closed  bool
+}
+
+func (c *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeChannel) Confirm(noWait bool) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.confirm = true
+	return nil
+}
+
+func (c *fakeChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.broker.publishErr != nil {
+		return nil, c.broker.publishErr
+	}
+	c.broker.published = append(c.broker.published, msg)
+	return fakeConfirmation{acked: c.broker.confirm}, nil
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeChannel) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+type fakeConfirmation struct {
+	acked *bool
+}
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	if c.acked == nil {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return *c.acked, nil
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func connectedPublisher(t *testing.T, broker *fakeBroker, poolSize int) *Publisher {
+	p := newPublisher(broker.dial, broker.sleep, poolSize)
+	t.Cleanup(p.Close)
+	waitFor(t, "the connection", func() bool { return p.Health().Connected })
+	return p
+}
+
+var oneMessage = []map[string]interface{}{{"id": 1}}
+
+func TestPublisherConfirms(t *testing.T) {
+	broker := newFakeBroker()
+	p := connectedPublisher(t, broker, 1)
+
+	err := p.Publish(context.Background(), []map[string]interface{}{{"id": 1}, {"id": 2}}, "create")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(broker.published), 2)
+	assert.Equal(t, broker.published[0].Headers["controller"], "create")
+	assert.Equal(t, string(broker.published[1].Body), `{"id":2}`)
+
+	// the released channel is reused
+	err = p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(broker.confirmChannels()), 1)
+}
+
+func TestPublisherNack(t *testing.T) {
+	broker := newFakeBroker()
+	p := connectedPublisher(t, broker, 1)
+	nacked := false
+	broker.setConfirm(&nacked)
+
+	err := p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err.Error(), "message was not confirmed by the broker")
+
+	// a nack leaves the channel usable, it goes back to the pool open
+	acked := true
+	broker.setConfirm(&acked)
+	err = p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+	channels := broker.confirmChannels()
+	assert.Equal(t, len(channels), 1)
+	assert.Equal(t, channels[0].IsClosed(), false)
+}
+
+func TestPublisherBrokenChannel(t *testing.T) {
+	broker := newFakeBroker()
+	p := connectedPublisher(t, broker, 1)
+	broker.setPublishErr(amqp.ErrClosed)
+
+	err := p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err.Error(), "failed to publish a message")
+	channels := broker.confirmChannels()
+	assert.Equal(t, len(channels), 1)
+	assert.Equal(t, channels[0].IsClosed(), true)
+
+	// the broken channel is released as an empty slot and reopened
+	broker.setPublishErr(nil)
+	err = p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(broker.confirmChannels()), 2)
+}
+
+func TestPublisherConfirmTimeout(t *testing.T) {
+	broker := newFakeBroker()
+	p := connectedPublisher(t, broker, 1)
+	broker.setConfirm(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := p.Publish(ctx, oneMessage, "create")
+	assert.Equal(t, err.Error(), "message was not confirmed by the broker")
+	// the confirmation may still arrive on the channel, it is not reused
+	assert.Equal(t, broker.confirmChannels()[0].IsClosed(), true)
+}
+
+func TestPublisherPool(t *testing.T) {
+	broker := newFakeBroker()
+	p := connectedPublisher(t, broker, 1)
+
+	ch, err := p.acquire(context.Background())
+	assert.Equal(t, err, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = p.Publish(ctx, oneMessage, "create")
+	assert.Equal(t, err.Error(), "no channel available")
+
+	p.release(ch, false)
+	err = p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(broker.confirmChannels()), 1)
+}
+
+func TestPublisherReconnect(t *testing.T) {
+	broker := newFakeBroker()
+	broker.failDials = 7
+	p := connectedPublisher(t, broker, 1)
+	assert.Equal(t, broker.delays, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 30 * time.Second, 30 * time.Second,
+	})
+	assert.Equal(t, p.Health().LastError, "")
+
+	err := p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+
+	// a dropped connection is dialed again and its channels reopened
+	broker.drop()
+	waitFor(t, "the second connection", func() bool { return broker.dialCount() == 9 && p.Health().Connected })
+	err = p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(broker.confirmChannels()), 2)
+	assert.Equal(t, len(broker.delays), 7)
+}
+
+func TestPublisherDisconnected(t *testing.T) {
+	broker := newFakeBroker()
+	broker.failDials = 1
+	block := make(chan struct{})
+	p := newPublisher(broker.dial, func(time.Duration) { <-block }, 1)
+	t.Cleanup(func() {
+		p.Close()
+		close(block)
+	})
+	waitFor(t, "the failed dial", func() bool { return p.Health().LastError != "" })
+	assert.Equal(t, p.Health(), PublisherHealth{LastError: "connection refused"})
+
+	err := p.Publish(context.Background(), oneMessage, "create")
+	assert.Equal(t, err.Error(), "failed to connect to RabbitMQ")
+	// the slot went back to the pool
+	assert.Equal(t, len(p.pool), 1)
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a private key with the id and algorithm tokens signed by it
+// carry in their header.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// RetiresAt is when tokens signed by this key stop being accepted, zero
+	// for keys that do not retire.
+	RetiresAt time.Time
+
+	private crypto.Signer
+}
+
+// NewSigningKey wraps an RSA (RS256) or Ed25519 (EdDSA) private key.
+func NewSigningKey(id string, private crypto.Signer) (*SigningKey, error) {
+	if id == "" {
+		return nil, errors.New("signing key has no id")
+	}
+	key := &SigningKey{ID: id, private: private}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("signing key %s: RSA keys must have at least 2048 bits", id)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported key type %T", id, private)
+	}
+	return key, nil
+}
+
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.RetiresAt.IsZero() && !now.Before(k.RetiresAt)
+}
+
+// JWK is the public half of a signing key as published in the JWKS.
+type JWK struct {
+	KeyType string `json:"kty"`
+	ID      string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{ID: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// KeySet signs with its active key and verifies with any key that has not
+// retired, so tokens signed before a rotation keep working during the
+// overlap window.
+type KeySet struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewKeySet(active *SigningKey, previous ...*SigningKey) *KeySet {
+	set := &KeySet{active: active, keys: map[string]*SigningKey{active.ID: active}}
+	for _, key := range previous {
+		set.keys[key.ID] = key
+	}
+	return set
+}
+
+// ParseKeySet reads keys in the JWT_SIGNING_KEYS format, a comma separated
+// list of id:base64 pairs of PKCS#8 DER private keys. The first key signs,
+// the others only verify and may end with :RFC3339 to set when they retire.
+func ParseKeySet(config string) (*KeySet, error) {
+	var keys []*SigningKey
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("signing key %q has no id", entry)
+		}
+		id := parts[0]
+		if seen[id] {
+			return nil, fmt.Errorf("signing key %s is listed twice", id)
+		}
+		seen[id] = true
+		der, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s is not base64: %w", id, err)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", id, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: unsupported key type %T", id, private)
+		}
+		key, err := NewSigningKey(id, signer)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 3 {
+			if len(keys) == 0 {
+				return nil, fmt.Errorf("signing key %s is active and cannot retire", id)
+			}
+			key.RetiresAt, err = time.Parse(time.RFC3339, parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("signing key %s: %w", id, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	return NewKeySet(keys[0], keys[1:]...), nil
+}
+
+// GenerateKeySet returns a set with a fresh Ed25519 key for tests, tokens
+// signed with it stop working when the process exits.
+func GenerateKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewSigningKey(id, private)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(key), nil
+}
+
+// Rotate makes next the signing key, the previous one keeps verifying tokens
+// for overlap.
+func (s *KeySet) Rotate(next *SigningKey, overlap time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active.RetiresAt = time.Now().Add(overlap)
+	s.active = next
+	s.keys[next.ID] = next
+}
+
+func (s *KeySet) Active() *SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// Lookup returns the key with id unless it retired.
+func (s *KeySet) Lookup(id string) (*SigningKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok || key.retired(time.Now()) {
+		return nil, false
+	}
+	return key, true
+}
+
+// JWKS returns the public keys tokens can currently be verified with.
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	jwks := JWKS{Keys: []JWK{s.active.JWK()}}
+	for _, key := range s.keys {
+		if key == s.active || key.retired(now) {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	sort.Slice(jwks.Keys[1:], func(i, j int) bool { return jwks.Keys[i+1].ID < jwks.Keys[j+1].ID })
+	return jwks
+}
+
+var (
+	keySetMu sync.Mutex
+	keySet   *KeySet
+)
+
+// CurrentKeySet returns the keys tokens are signed and verified with, loaded
+// from JWT_SIGNING_KEYS the first time.
+func CurrentKeySet() (*KeySet, error) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	if keySet == nil {
+		config := os.Getenv("JWT_SIGNING_KEYS")
+		if config == "" {
+			return nil, errors.New("JWT_SIGNING_KEYS is not set")
+		}
+		loaded, err := ParseKeySet(config)
+		if err != nil {
+			return nil, err
+		}
+		keySet = loaded
+	}
+	return keySet, nil
+}
+
+// SetKeySet replaces the keys tokens are signed and verified with, nil
+// reloads them from the environment on next use.
+func SetKeySet(s *KeySet) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	keySet = s
+}
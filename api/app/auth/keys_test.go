@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func newRSAKey(t *testing.T, id string) *SigningKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate rsa key: %v", err)
+	}
+	key, err := NewSigningKey(id, private)
+	if err != nil {
+		t.Fatalf("cannot wrap rsa key: %v", err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T, id string) *SigningKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate ed25519 key: %v", err)
+	}
+	key, err := NewSigningKey(id, private)
+	if err != nil {
+		t.Fatalf("cannot wrap ed25519 key: %v", err)
+	}
+	return key
+}
+
+func encodeKey(t *testing.T, key *SigningKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func withKeySet(t *testing.T, set *KeySet) {
+	SetKeySet(set)
+	t.Cleanup(func() { SetKeySet(nil) })
+}
+
+func sign(t *testing.T, key *SigningKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+	return signed
+}
+
+func TestCreateAndVerifyToken(t *testing.T) {
+	for _, key := range []*SigningKey{newRSAKey(t, "rsa"), newEd25519Key(t, "ed25519")} {
+		withKeySet(t, NewKeySet(key))
+		token, err := CreateToken(7)
+		assert.Equal(t, err, nil)
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, parsed.Header["kid"], key.ID)
+		assert.Equal(t, parsed.Header["alg"], key.Method.Alg())
+
+		claims, err := VerifyToken(token)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claims["user_id"], float64(7))
+		assert.Equal(t, claims["iss"], "maintenance-api")
+		assert.Equal(t, claims["aud"], "maintenance-api")
+	}
+}
+
+func TestVerifyTokenClaims(t *testing.T) {
+	key := newEd25519Key(t, "current")
+	withKeySet(t, NewKeySet(key))
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"user_id": 1,
+			"jti":     "jti",
+			"iss":     "maintenance-api",
+			"aud":     "maintenance-api",
+			"nbf":     now.Unix(),
+			"exp":     now.Add(time.Minute).Unix(),
+		}
+	}
+	samples := []struct {
+		name         string
+		edit         func(claims jwt.MapClaims)
+		errorMessage string
+	}{
+		{name: "valid", edit: func(claims jwt.MapClaims) {}},
+		{name: "issuer", edit: func(claims jwt.MapClaims) { claims["iss"] = "someone-else" }, errorMessage: "invalid token issuer"},
+		{name: "missing issuer", edit: func(claims jwt.MapClaims) { delete(claims, "iss") }, errorMessage: "invalid token issuer"},
+		{name: "audience", edit: func(claims jwt.MapClaims) { claims["aud"] = "worker" }, errorMessage: "invalid token audience"},
+		{name: "missing audience", edit: func(claims jwt.MapClaims) { delete(claims, "aud") }, errorMessage: "invalid token audience"},
+		{name: "not before", edit: func(claims jwt.MapClaims) { claims["nbf"] = now.Add(time.Minute).Unix() }, errorMessage: "Token is not valid yet"},
+		{name: "missing not before", edit: func(claims jwt.MapClaims) { delete(claims, "nbf") }, errorMessage: "token is not valid yet"},
+		{name: "expired", edit: func(claims jwt.MapClaims) { claims["exp"] = now.Add(-time.Minute).Unix() }, errorMessage: "Token is expired"},
+	}
+	for _, v := range samples {
+		claims := valid()
+		v.edit(claims)
+		_, err := VerifyToken(sign(t, key, claims))
+		if v.errorMessage == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", v.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != v.errorMessage {
+			t.Errorf("%s: got %v, want %q", v.name, err, v.errorMessage)
+		}
+	}
+}
+
+func TestVerifyTokenRejectsForeignKeys(t *testing.T) {
+	key := newEd25519Key(t, "current")
+	withKeySet(t, NewKeySet(key))
+	claims := jwt.MapClaims{"iss": "maintenance-api", "aud": "maintenance-api", "nbf": time.Now().Unix()}
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = key.ID
+	signed, err := hmac.SignedString([]byte("secret"))
+	assert.Equal(t, err, nil)
+	_, err = VerifyToken(signed)
+	assert.NotEqual(t, err, nil)
+
+	_, err = VerifyToken(sign(t, newEd25519Key(t, "other"), claims))
+	assert.Equal(t, err.Error(), `unknown signing key "other"`)
+
+	impostor := newEd25519Key(t, "current")
+	_, err = VerifyToken(sign(t, impostor, claims))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newRSAKey(t, "old")
+	set := NewKeySet(old)
+	withKeySet(t, set)
+	before, err := CreateToken(1)
+	assert.Equal(t, err, nil)
+
+	set.Rotate(newEd25519Key(t, "new"), time.Hour)
+	after, err := CreateToken(1)
+	assert.Equal(t, err, nil)
+	_, err = VerifyToken(before)
+	assert.Equal(t, err, nil)
+	_, err = VerifyToken(after)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(set.JWKS().Keys), 2)
+	assert.Equal(t, set.JWKS().Keys[0].ID, "new")
+
+	old.RetiresAt = time.Now().Add(-time.Second)
+	_, err = VerifyToken(before)
+	assert.Equal(t, err.Error(), `unknown signing key "old"`)
+	_, err = VerifyToken(after)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(set.JWKS().Keys), 1)
+}
+
+func TestParseKeySet(t *testing.T) {
+	current := newEd25519Key(t, "current")
+	previous := newRSAKey(t, "previous")
+	config := "current:" + encodeKey(t, current) + ",previous:" + encodeKey(t, previous) + ":2030-01-02T15:04:05Z"
+	set, err := ParseKeySet(config)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, set.Active().ID, "current")
+	assert.Equal(t, set.Active().Method.Alg(), "EdDSA")
+	key, ok := set.Lookup("previous")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, key.Method.Alg(), "RS256")
+	assert.Equal(t, key.RetiresAt, time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC))
+
+	jwks := set.JWKS()
+	assert.Equal(t, len(jwks.Keys), 2)
+	assert.Equal(t, jwks.Keys[0].KeyType, "OKP")
+	assert.Equal(t, jwks.Keys[0].Curve, "Ed25519")
+	assert.Equal(t, jwks.Keys[1].KeyType, "RSA")
+	assert.Equal(t, jwks.Keys[1].E, "AQAB")
+
+	samples := []struct {
+		config       string
+		errorMessage string
+	}{
+		{config: "", errorMessage: "no signing keys configured"},
+		{config: "current", errorMessage: "has no id"},
+		{config: "current:???", errorMessage: "signing key current is not base64"},
+		{config: "current:" + encodeKey(t, current) + ":2030-01-02T15:04:05Z", errorMessage: "signing key current is active and cannot retire"},
+		{config: "current:" + encodeKey(t, current) + ",current:" + encodeKey(t, current), errorMessage: "signing key current is listed twice"},
+		{config: "current:" + encodeKey(t, current) + ",previous:" + encodeKey(t, previous) + ":tomorrow", errorMessage: "signing key previous"},
+	}
+	for _, v := range samples {
+		_, err := ParseKeySet(v.config)
+		if err == nil || !strings.Contains(err.Error(), v.errorMessage) {
+			t.Errorf("ParseKeySet(%q) = %v, want %q", v.config, err, v.errorMessage)
+		}
+	}
+}
+
+func TestCurrentKeySetRequiresKeys(t *testing.T) {
+	withKeySet(t, nil)
+	t.Setenv("JWT_SIGNING_KEYS", "")
+	_, err := CurrentKeySet()
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, err.Error(), "JWT_SIGNING_KEYS is not set")
+
+	key := newEd25519Key(t, "current")
+	t.Setenv("JWT_SIGNING_KEYS", "current:"+encodeKey(t, key))
+	set, err := CurrentKeySet()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, set.Active().ID, "current")
+}
+
+func TestExtractTokenMetadataRejectsRevoked(t *testing.T) {
+	withKeySet(t, NewKeySet(newEd25519Key(t, "current")))
+	store := repository.NewMemoryStore()
+	token, err := CreateToken(7)
+	assert.Equal(t, err, nil)
+	request := httptest.NewRequest("GET", "/users", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	metadata, err := ExtractTokenMetadata(store, request)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, metadata.UserID, uint64(7))
+
+	err = store.Tokens().Revoke(metadata.JTI, metadata.ExpiresAt)
+	assert.Equal(t, err, nil)
+	_, err = ExtractTokenMetadata(store, request)
+	assert.Equal(t, err.Error(), "token revoked")
+}
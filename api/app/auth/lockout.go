@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+)
+
+// LoginPolicy slows down and locks out repeated failed logins. Each failure of
+// an account doubles the wait before its next attempt, starting at BaseDelay,
+// and an account or a client address is locked for Lockout once it failed
+// its maximum within Window.
+type LoginPolicy struct {
+	AccountMaxFailures int
+	AddressMaxFailures int
+	Window             time.Duration
+	Lockout            time.Duration
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// CurrentLoginPolicy reads LOGIN_MAX_FAILURES, LOGIN_MAX_FAILURES_PER_IP,
+// LOGIN_FAILURE_WINDOW_MINUTES, LOGIN_LOCKOUT_MINUTES and
+// LOGIN_BASE_DELAY_MS.
+func CurrentLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		AccountMaxFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		AddressMaxFailures: envInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		Window:             time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		Lockout:            time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		BaseDelay:          time.Duration(envInt("LOGIN_BASE_DELAY_MS", 1000)) * time.Millisecond,
+		MaxDelay:           30 * time.Second,
+	}
+}
+
+// TrustedProxies reads TRUSTED_PROXIES, the comma separated addresses or CIDRs
+// whose X-Forwarded-For header is believed. It is nil when unset so client
+// addresses, and the failed logins counted per address, cannot be spoofed.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// Delay is how long an account waits after its nth failure.
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Wait is how long the account of attempt has to wait before its next login,
+// while it is locked or until the delay of its last failure is over.
+func (p LoginPolicy) Wait(attempt *models.LoginAttempt, now time.Time) time.Duration {
+	wait := attempt.LockedFor(now)
+	if attempt.Failures == 0 || now.Sub(attempt.LastFailureAt) >= p.Window {
+		return wait
+	}
+	delayed := attempt.LastFailureAt.Add(p.Delay(attempt.Failures)).Sub(now)
+	if delayed > wait {
+		return delayed
+	}
+	return wait
+}
+
+// AccountSubject and AddressSubject key the failed logins, emails are compared
+// case-insensitively like MySQL does.
+func AccountSubject(email string) string {
+	return HashToken("account:" + strings.ToLower(strings.TrimSpace(email)))
+}
+
+func AddressSubject(ip string) string {
+	return HashToken("address:" + ip)
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	policy := LoginPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	samples := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{failures: 0, delay: 0},
+		{failures: 1, delay: time.Second},
+		{failures: 2, delay: 2 * time.Second},
+		{failures: 4, delay: 8 * time.Second},
+		{failures: 6, delay: 30 * time.Second},
+		{failures: 100, delay: 30 * time.Second},
+	}
+	for _, v := range samples {
+		assert.Equal(t, policy.Delay(v.failures), v.delay)
+	}
+}
+
+func TestLoginPolicyWait(t *testing.T) {
+	policy := LoginPolicy{Window: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	now := time.Now()
+	lockedUntil := now.Add(10 * time.Minute)
+	expired := now.Add(-time.Minute)
+	samples := []struct {
+		attempt models.LoginAttempt
+		wait    time.Duration
+	}{
+		{attempt: models.LoginAttempt{}, wait: 0},
+		{attempt: models.LoginAttempt{Failures: 3, LastFailureAt: now.Add(-time.Second)}, wait: 3 * time.Second},
+		{attempt: models.LoginAttempt{Failures: 3, LastFailureAt: now.Add(-5 * time.Second)}, wait: 0},
+		// failures older than the window are forgotten
+		{attempt: models.LoginAttempt{Failures: 30, LastFailureAt: now.Add(-time.Hour)}, wait: 0},
+		{attempt: models.LoginAttempt{Failures: 5, LastFailureAt: now, LockedUntil: &lockedUntil}, wait: 10 * time.Minute},
+		{attempt: models.LoginAttempt{Failures: 1, LastFailureAt: now.Add(-20 * time.Minute), LockedUntil: &expired}, wait: 0},
+	}
+	for _, v := range samples {
+		assert.Equal(t, policy.Wait(&v.attempt, now), v.wait)
+	}
+}
+
+func TestCurrentLoginPolicy(t *testing.T) {
+	t.Setenv("LOGIN_MAX_FAILURES", "3")
+	t.Setenv("LOGIN_LOCKOUT_MINUTES", "not a number")
+	policy := CurrentLoginPolicy()
+	assert.Equal(t, policy.AccountMaxFailures, 3)
+	assert.Equal(t, policy.AddressMaxFailures, 20)
+	assert.Equal(t, policy.Lockout, 15*time.Minute)
+}
+
+func TestLoginSubjects(t *testing.T) {
+	assert.Equal(t, AccountSubject("Pet@Gmail.com "), AccountSubject("pet@gmail.com"))
+	assert.NotEqual(t, AccountSubject("127.0.0.1"), AddressSubject("127.0.0.1"))
+	assert.Equal(t, len(AccountSubject("pet@gmail.com")), 64)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	assert.Equal(t, TrustedProxies() == nil, true)
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.1, ,10.1.0.0/16")
+	assert.Equal(t, TrustedProxies(), []string{"10.0.0.1", "10.1.0.0/16"})
+}
@@ -1,27 +1,189 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 )
 
+type TokenMetadata struct {
+	UserID    uint64
+	JTI       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func tokenExpiration() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("TOKEN_EXP_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func RefreshTokenExpiration() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_EXP_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 720
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func PasswordResetExpiration() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("PASSWORD_RESET_EXP_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 30
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// PasswordResetURL is the page the reset links point to, the token is added
+// as the token query parameter.
+func PasswordResetURL(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://127.0.0.1:8000/reset-password"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+func EmailVerificationExpiration() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_EXP_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 48
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// EmailVerificationURL is where the verification links point to, the API's
+// own GET /verify by default, the token is added as the token query
+// parameter.
+func EmailVerificationURL(token string) string {
+	base := os.Getenv("EMAIL_VERIFICATION_URL")
+	if base == "" {
+		base = "http://127.0.0.1:8080/verify"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func issuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "maintenance-api"
+}
+
+func audience() string {
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return "maintenance-api"
+}
+
 func CreateToken(user_id uint64) (string, error) {
-	tokenExpirationMinutes, err := time.ParseDuration(os.Getenv("TOKEN_EXP_MINUTES"))
+	keys, err := CurrentKeySet()
 	if err != nil {
-		tokenExpirationMinutes = 60
+		return "", err
 	}
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = user_id
-	claims["exp"] = time.Now().Add(time.Minute * tokenExpirationMinutes).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	claims["jti"] = jti
+	claims["iss"] = issuer()
+	claims["aud"] = audience()
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(tokenExpiration()).Unix()
+	key := keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// VerifyToken checks the signature against the key named by the kid header
+// and the iss, aud, nbf and exp claims. Revocation is not checked.
+func VerifyToken(tokenString string) (jwt.MapClaims, error) {
+	keys, err := CurrentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}}
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if !claims.VerifyIssuer(issuer(), true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if !claims.VerifyAudience(audience(), true) {
+		return nil, errors.New("invalid token audience")
+	}
+	if !claims.VerifyNotBefore(time.Now().Unix(), true) {
+		return nil, errors.New("token is not valid yet")
+	}
+	return claims, nil
+}
+
+// CreateOpaqueToken returns a random token for refresh tokens and the single
+// use tokens that are emailed, with the hash that is stored server side. The
+// token itself is never persisted.
+func CreateOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func CreateTokenFamily() (string, error) {
+	return randomHex(16)
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func ExtractToken(request *http.Request) string {
@@ -37,38 +199,34 @@ func ExtractToken(request *http.Request) string {
 	return ""
 }
 
-func ExtractTokenID(request *http.Request) (uint64, error) {
-	tokenString := ExtractToken(request)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+// ExtractTokenMetadata verifies the token of the request, a token whose jti
+// was revoked by a logout is rejected.
+func ExtractTokenMetadata(store repository.Store, request *http.Request) (*TokenMetadata, error) {
+	claims, err := VerifyToken(ExtractToken(request))
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 64)
-		if err != nil {
-			return 0, err
-		}
-		return uid, nil
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, errors.New("invalid token")
 	}
-	return 0, nil
-}
-
-func TokenValid(request *http.Request) error {
-	tokenString := ExtractToken(request)
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	revoked, err := store.Tokens().IsRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token revoked")
+	}
+	uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 64)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	exp, _ := claims["exp"].(float64)
+	iat, _ := claims["iat"].(float64)
+	return &TokenMetadata{
+		UserID:    uid,
+		JTI:       jti,
+		IssuedAt:  time.Unix(int64(iat), 0),
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
 }
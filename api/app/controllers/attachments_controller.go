@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/storage"
+	"github.com/vitorbiten/maintenance/api/app/utils"
+)
+
+// multipartOverhead is how much a request may exceed the file size limit to
+// fit the multipart boundaries and part headers.
+const multipartOverhead = 64 << 10
+
+// CreateAttachment uploads a file to a task
+//
+//	@Summary		Attaches a file to a task
+//	@Description	Managers and technicians can: attach photos (jpeg, png, gif, webp) and pdfs to the tasks they can read
+//	@Description	The type is detected from the content, the file is encrypted before it is stored
+//	@Tags			attachments
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			id		path		string	true	"task id"
+//	@Param			file	formData	file	true	"file (max size: ATTACHMENT_MAX_BYTES, 10 MiB by default)"
+//	@Success		201	{object}	models.Attachment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		413	{object}	nil
+//	@Failure		415	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/attachments [post]
+func CreateAttachment(context *gin.Context) {
+	tokenUser := middlewares.CurrentUser(context)
+	task := currentTask(context)
+	maxSize := models.MaxAttachmentSize()
+	tooLarge := fmt.Sprintf("file max size is %d bytes", maxSize)
+	if context.Request.ContentLength > maxSize+multipartOverhead {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+		return
+	}
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, maxSize+multipartOverhead)
+	header, err := context.FormFile("file")
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+			return
+		}
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "required file"})
+		return
+	}
+	if header.Size > maxSize {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+		return
+	}
+	if header.Size == 0 {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "empty file"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// the client's Content-Type is not trusted, the first bytes decide
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if _, ok := models.AttachmentTypes[contentType]; !ok {
+		context.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("unsupported content type %s", contentType)})
+		return
+	}
+
+	attachment := models.Attachment{
+		TaskID:      task.ID,
+		AuthorID:    tokenUser.ID,
+		Filename:    models.AttachmentFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+	err = attachment.Validate()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	fileKey, err := utils.NewStreamKey()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	attachment.StorageKey, err = newStorageKey(task.ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sealed, err := utils.NewSealingReader(io.MultiReader(bytes.NewReader(head), file), fileKey)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = storage.Default.Put(context.Request.Context(), attachment.StorageKey, sealed, utils.SealedSize(attachment.Size))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = attachment.Prepare(fileKey)
+	if err == nil {
+		_, err = middlewares.Store(context).Attachments().Save(&attachment)
+	}
+	if err != nil {
+		removeStoredFiles(context, []models.Attachment{attachment})
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusCreated, attachment)
+}
+
+// newStorageKey names a file by its task and a random suffix, the filename
+// stays encrypted in the database.
+func newStorageKey(tid uint64) (string, error) {
+	suffix := make([]byte, 16)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tasks/%d/%s", tid, hex.EncodeToString(suffix)), nil
+}
+
+// removeStoredFiles deletes the files of attachments whose rows are gone. A
+// file left behind cannot be read anymore since its key went with the row,
+// so failures are only logged.
+func removeStoredFiles(context *gin.Context, attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		err := storage.Default.Delete(context.Request.Context(), attachment.StorageKey)
+		if err != nil {
+			logging.FromContext(context.Request.Context()).Warn("failed to delete attachment file", "storage_key", attachment.StorageKey, "error", err)
+		}
+	}
+}
+
+// GetAttachments returns the attachments of a task
+//
+//	@Summary		Get the attachments of a task
+//	@Description	Managers and technicians can: get the attachments of the tasks they can read, oldest first
+//	@Tags			attachments
+//	@Produce		json
+//	@Param			id	path		string	true	"task id"
+//	@Success		200	{object}	[]models.Attachment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/attachments [get]
+func GetAttachments(context *gin.Context) {
+	attachments, err := middlewares.Store(context).Attachments().FindByTask(currentTask(context).ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, attachments)
+}
+
+// GetAttachment downloads an attachment
+//
+//	@Summary		Downloads an attachment
+//	@Description	Managers and technicians can: download the attachments of the tasks they can read
+//	@Description	The file is decrypted as it is streamed
+//	@Tags			attachments
+//	@Produce		octet-stream
+//	@Param			id				path		string	true	"task id"
+//	@Param			attachment_id	path		string	true	"attachment id"
+//	@Success		200	{file}		file
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/attachments/attachment_id [get]
+func GetAttachment(context *gin.Context) {
+	attachment := currentAttachment(context)
+	fileKey, err := attachment.OpenFileKey()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stored, err := storage.Default.Get(context.Request.Context(), attachment.StorageKey)
+	if err == storage.ErrNotFound {
+		context.JSON(http.StatusNotFound, gin.H{"error": "attachment file not found"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer stored.Close()
+	file, err := utils.NewOpeningReader(stored, fileKey)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	// the status and length are sent before the file is read, a chunk that
+	// fails to decrypt cuts the response short instead
+	context.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, file, map[string]string{
+		"Content-Disposition":    disposition,
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
+
+// DeleteAttachment deletes an attachment with its file
+//
+//	@Summary		Deletes an attachment
+//	@Description	Technicians can: delete the attachments they uploaded
+//	@Description	Managers can: delete any attachment
+//	@Tags			attachments
+//	@Produce		json
+//	@Param			id				path		string	true	"task id"
+//	@Param			attachment_id	path		string	true	"attachment id"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/attachments/attachment_id [delete]
+func DeleteAttachment(context *gin.Context) {
+	attachment := currentAttachment(context)
+	res, err := middlewares.Store(context).Attachments().Delete(attachment.ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	removeStoredFiles(context, []models.Attachment{*attachment})
+	context.Header("Entity", fmt.Sprintf("%d", attachment.ID))
+	context.JSON(http.StatusNoContent, "")
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"github.com/vitorbiten/maintenance/api/app/storage"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+var (
+	pngFile = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("compressor"), 10000)...)
+	pdfFile = []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%EOF\n")
+)
+
+// multipartFile builds a request body with content as the "file" field.
+func multipartFile(filename string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if filename != "" {
+		part, err := writer.CreateFormFile("file", filename)
+		OnError(err, fmt.Sprintf("Cannot create form file: %v", err))
+		_, err = part.Write(content)
+		OnError(err, fmt.Sprintf("Cannot write form file: %v", err))
+	}
+	err := writer.Close()
+	OnError(err, fmt.Sprintf("Cannot close form: %v", err))
+	return body, writer.FormDataContentType()
+}
+
+func TestAttachments(t *testing.T) {
+	t.Setenv("ATTACHMENT_MAX_BYTES", "200000")
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, tasks, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	tokens := []string{}
+	for _, user := range users {
+		token, err := SignIn(user.Email, "password")
+		OnError(err, fmt.Sprintf("Cannot login as %s: %v\n", user.Email, err))
+		tokens = append(tokens, fmt.Sprintf("Bearer %v", token))
+	}
+	managerTokenString := tokens[0]
+	technicianTokenString := tokens[2]
+	secondTechnicianTokenString := tokens[3]
+	technicianTask := fmt.Sprintf("/tasks/%d/attachments", tasks[0].ID)
+	secondTechnicianTask := fmt.Sprintf("/tasks/%d/attachments", tasks[1].ID)
+
+	// ids of the attachments created by the samples, in order
+	created := []uint64{}
+	attachment := func(i int) string { return fmt.Sprintf("%s/%d", technicianTask, created[i]) }
+
+	samples := []struct {
+		method       string
+		path         func() string
+		filename     string
+		content      []byte
+		tokenGiven   string
+		statusCode   int
+		contentType  string
+		download     []byte
+		count        int
+		errorMessage string
+	}{
+		{
+			method:      "POST",
+			path:        func() string { return technicianTask },
+			filename:    "../../compressor.png",
+			content:     pngFile,
+			tokenGiven:  technicianTokenString,
+			statusCode:  201,
+			contentType: "image/png",
+		},
+		{
+			// The client's content type is ignored
+			method:      "POST",
+			path:        func() string { return technicianTask },
+			filename:    "invoice.jpg",
+			content:     pdfFile,
+			tokenGiven:  managerTokenString,
+			statusCode:  201,
+			contentType: "application/pdf",
+		},
+		{
+			method:       "POST",
+			path:         func() string { return technicianTask },
+			filename:     "notes.txt",
+			content:      []byte("just some notes"),
+			tokenGiven:   technicianTokenString,
+			statusCode:   415,
+			errorMessage: "unsupported content type text/plain",
+		},
+		{
+			method:       "POST",
+			path:         func() string { return technicianTask },
+			filename:     "huge.png",
+			content:      append(pngFile, pngFile...),
+			tokenGiven:   technicianTokenString,
+			statusCode:   413,
+			errorMessage: "file max size is 200000 bytes",
+		},
+		{
+			method:       "POST",
+			path:         func() string { return technicianTask },
+			filename:     "empty.png",
+			content:      []byte{},
+			tokenGiven:   technicianTokenString,
+			statusCode:   422,
+			errorMessage: "empty file",
+		},
+		{
+			method:       "POST",
+			path:         func() string { return technicianTask },
+			tokenGiven:   technicianTokenString,
+			statusCode:   422,
+			errorMessage: "required file",
+		},
+		{
+			// When technician attaches to another technician's task
+			method:       "POST",
+			path:         func() string { return secondTechnicianTask },
+			filename:     "compressor.png",
+			content:      pngFile,
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "GET",
+			path:       func() string { return technicianTask },
+			tokenGiven: technicianTokenString,
+			statusCode: 200,
+			count:      2,
+		},
+		{
+			method:      "GET",
+			path:        func() string { return attachment(0) },
+			tokenGiven:  managerTokenString,
+			statusCode:  200,
+			contentType: "image/png",
+			download:    pngFile,
+		},
+		{
+			method:       "GET",
+			path:         func() string { return attachment(0) },
+			tokenGiven:   secondTechnicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			// When the attachment belongs to another task
+			method:       "GET",
+			path:         func() string { return fmt.Sprintf("%s/%d", secondTechnicianTask, created[0]) },
+			tokenGiven:   managerTokenString,
+			statusCode:   404,
+			errorMessage: "attachment not found",
+		},
+		{
+			method:       "DELETE",
+			path:         func() string { return attachment(1) },
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "DELETE",
+			path:       func() string { return attachment(0) },
+			tokenGiven: managerTokenString,
+			statusCode: 204,
+		},
+		{
+			method:       "GET",
+			path:         func() string { return attachment(0) },
+			tokenGiven:   technicianTokenString,
+			statusCode:   404,
+			errorMessage: "attachment not found",
+		},
+		{
+			method:       "GET",
+			path:         func() string { return technicianTask },
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "authentication required",
+		},
+	}
+
+	for _, v := range samples {
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		body, contentType := &bytes.Buffer{}, ""
+		if v.method == "POST" {
+			body, contentType = multipartFile(v.filename, v.content)
+		}
+		req, err := http.NewRequest(v.method, v.path(), body)
+		OnError(err, fmt.Sprintf("Error on %s %s: %v", v.method, v.path(), err))
+		req.Header.Set("Authorization", v.tokenGiven)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 204 {
+			continue
+		}
+		if v.download != nil {
+			assert.Equal(t, rr.Header().Get("Content-Type"), v.contentType)
+			assert.Equal(t, rr.Header().Get("Content-Disposition"), `attachment; filename=compressor.png`)
+			assert.Equal(t, bytes.Equal(rr.Body.Bytes(), v.download), true)
+			continue
+		}
+		if v.count > 0 {
+			list := []map[string]interface{}{}
+			err = json.Unmarshal(rr.Body.Bytes(), &list)
+			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+			assert.Equal(t, len(list), v.count)
+			continue
+		}
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		if v.statusCode == 201 {
+			assert.Equal(t, responseMap["content_type"], v.contentType)
+			assert.Equal(t, responseMap["size"], float64(len(v.content)))
+			assert.Equal(t, responseMap["filename"], models.AttachmentFilename(v.filename))
+			created = append(created, uint64(responseMap["id"].(float64)))
+		}
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 404 || v.statusCode == 413 || v.statusCode == 415 || v.statusCode == 422 {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	// What reaches the storage is sealed, and goes away with the task
+	remaining, err := repository.Default.Attachments().FindByID(created[1])
+	OnError(err, fmt.Sprintf("Cannot find attachment: %v", err))
+	stored, err := storage.Default.Get(context.Background(), remaining.StorageKey)
+	OnError(err, fmt.Sprintf("Cannot read stored file: %v", err))
+	sealed, err := io.ReadAll(stored)
+	stored.Close()
+	OnError(err, fmt.Sprintf("Cannot read stored file: %v", err))
+	assert.Equal(t, bytes.Contains(sealed, []byte("%PDF")), false)
+
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/tasks/%d", tasks[0].ID), nil)
+	OnError(err, fmt.Sprintf("Error deleting task: %v", err))
+	req.Header.Set("Authorization", managerTokenString)
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 204)
+	_, err = storage.Default.Get(context.Background(), remaining.StorageKey)
+	assert.Equal(t, err, storage.ErrNotFound)
+}
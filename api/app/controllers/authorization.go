@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/policy"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+const (
+	taskKey       = "task"
+	commentKey    = "comment"
+	attachmentKey = "attachment"
+)
+
+// lookupStatus answers a missing target with 404 and a failing store with
+// 500.
+func lookupStatus(err error) int {
+	if errors.Is(err, repository.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// taskTarget loads the task of the :id parameter, its author and assignees
+// own it.
+func taskTarget(c *gin.Context, user *models.User) (*middlewares.Target, int, error) {
+	return loadTask(c, user, (*models.Task).Involves)
+}
+
+// taskAuthorTarget loads the task of the :id parameter like taskTarget, only
+// its author owns it. Assignees work on a work order without rewriting it.
+func taskAuthorTarget(c *gin.Context, user *models.User) (*middlewares.Target, int, error) {
+	return loadTask(c, user, (*models.Task).AuthoredBy)
+}
+
+func loadTask(c *gin.Context, user *models.User, owns func(task *models.Task, uid uint64) bool) (*middlewares.Target, int, error) {
+	task, err := middlewares.Store(c).Tasks().FindByID(c.GetUint64(middlewares.IDKey))
+	if err != nil {
+		return nil, lookupStatus(err), err
+	}
+	c.Set(taskKey, task)
+	ownership := policy.Others
+	if owns(task, user.ID) {
+		ownership = policy.Own
+	}
+	return &middlewares.Target{Ownership: ownership}, 0, nil
+}
+
+// commentTarget loads the :comment_id comment of the task loaded by
+// taskTarget, its author owns it.
+func commentTarget(c *gin.Context, user *models.User) (*middlewares.Target, int, error) {
+	cid, err := strconv.ParseUint(c.Param("comment_id"), 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	comment, err := middlewares.Store(c).Comments().FindByID(cid)
+	if err != nil {
+		return nil, lookupStatus(err), err
+	}
+	if comment.TaskID != currentTask(c).ID {
+		return nil, http.StatusNotFound, errors.New("comment not found")
+	}
+	c.Set(commentKey, comment)
+	ownership := policy.Others
+	if comment.AuthorID == user.ID {
+		ownership = policy.Own
+	}
+	return &middlewares.Target{Ownership: ownership}, 0, nil
+}
+
+// attachmentTarget loads the :attachment_id attachment of the task loaded by
+// taskTarget, whoever uploaded it owns it.
+func attachmentTarget(c *gin.Context, user *models.User) (*middlewares.Target, int, error) {
+	aid, err := strconv.ParseUint(c.Param("attachment_id"), 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	attachment, err := middlewares.Store(c).Attachments().FindByID(aid)
+	if err != nil {
+		return nil, lookupStatus(err), err
+	}
+	if attachment.TaskID != currentTask(c).ID {
+		return nil, http.StatusNotFound, errors.New("attachment not found")
+	}
+	c.Set(attachmentKey, attachment)
+	ownership := policy.Others
+	if attachment.AuthorID == user.ID {
+		ownership = policy.Own
+	}
+	return &middlewares.Target{Ownership: ownership}, 0, nil
+}
+
+// userTarget compares the :id parameter with the caller, users own
+// themselves. Reads also load the target to check its role.
+func userTarget(load bool) middlewares.TargetLoader {
+	return func(c *gin.Context, user *models.User) (*middlewares.Target, int, error) {
+		uid := c.GetUint64(middlewares.IDKey)
+		if !load {
+			if uid == user.ID {
+				return &middlewares.Target{Ownership: policy.Own, Role: user.UserType}, 0, nil
+			}
+			return &middlewares.Target{Ownership: policy.Others}, 0, nil
+		}
+		requestedUser, err := middlewares.Store(c).Users().FindByID(uid)
+		if err != nil {
+			return nil, lookupStatus(err), err
+		}
+		c.Set(userKey, requestedUser)
+		ownership := policy.Others
+		if requestedUser.ID == user.ID {
+			ownership = policy.Own
+		}
+		return &middlewares.Target{Ownership: ownership, Role: requestedUser.UserType}, 0, nil
+	}
+}
+
+const userKey = "requested_user"
+
+func currentTask(c *gin.Context) *models.Task {
+	return c.MustGet(taskKey).(*models.Task)
+}
+
+func currentComment(c *gin.Context) *models.Comment {
+	return c.MustGet(commentKey).(*models.Comment)
+}
+
+func currentAttachment(c *gin.Context) *models.Attachment {
+	return c.MustGet(attachmentKey).(*models.Attachment)
+}
+
+func requestedUser(c *gin.Context) *models.User {
+	return c.MustGet(userKey).(*models.User)
+}
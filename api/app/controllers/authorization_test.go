@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// failingStore loses its connection whenever a task is looked up.
+type failingStore struct {
+	repository.Store
+}
+
+func (s failingStore) WithContext(ctx context.Context) repository.Store {
+	return failingStore{s.Store.WithContext(ctx)}
+}
+
+func (s failingStore) Tasks() repository.TaskRepository {
+	return failingTasks{s.Store.Tasks()}
+}
+
+type failingTasks struct {
+	repository.TaskRepository
+}
+
+func (r failingTasks) FindByID(tid uint64) (*models.Task, error) {
+	return &models.Task{}, errors.New("connection refused")
+}
+
+func TestTargetLookupFailure(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing tables")
+	users, tasks, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding users and tasks: %v\n", err))
+	token, err := SignIn(users[2].Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login: %v\n", err))
+
+	// a missing task is not found
+	code, _, _ := requestJSON("GET", "/tasks/999", token, "")
+	assert.Equal(t, code, 404)
+
+	// a failing store is not mistaken for a missing task
+	store := repository.Default
+	repository.Default = failingStore{store}
+	defer func() { repository.Default = store }()
+	code, _, responseMap := requestJSON("GET", fmt.Sprintf("/tasks/%d", tasks[0].ID), token, "")
+	assert.Equal(t, code, 500)
+	assert.Equal(t, responseMap["error"], "connection refused")
+}
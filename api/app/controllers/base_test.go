@@ -10,9 +10,12 @@ import (
 	"github.com/joho/godotenv"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/auth"
 	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/migrations"
 	"github.com/vitorbiten/maintenance/api/app/models"
-	"github.com/vitorbiten/maintenance/api/app/utils"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"github.com/vitorbiten/maintenance/api/app/storage"
 )
 
 func TestMain(m *testing.M) {
@@ -21,10 +24,29 @@ func TestMain(m *testing.M) {
 		log.Printf("Error getting env %v\n", err)
 	}
 
+	if os.Getenv("ENCRYPTION_KEYS") == "" {
+		os.Setenv("ENCRYPTION_KEYS", "test:XL8KDbDXhcW3SozhAdbf5aYZf+So0zTugi970gpGBVE=")
+	}
+	if os.Getenv("SEARCH_INDEX_KEY") == "" {
+		os.Setenv("SEARCH_INDEX_KEY", "9x9y/ahBJQ4qAliUpatodaZ1DBwEjkU12SzobCFTEpU=")
+	}
+	if os.Getenv("JWT_SIGNING_KEYS") == "" {
+		keys, err := auth.GenerateKeySet()
+		OnError(err, "cannot generate signing keys: %v")
+		auth.SetKeySet(keys)
+	}
+
 	gin.SetMode(gin.TestMode)
 
+	dir, err := os.MkdirTemp("", "attachments")
+	OnError(err, "cannot create attachments dir: %v")
+	storage.Default, err = storage.NewLocal(dir)
+	OnError(err, "cannot load attachment storage: %v")
+
 	Database()
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func OnError(err error, text string) {
@@ -35,12 +57,33 @@ func OnError(err error, text string) {
 
 func SetupRouter() *gin.Engine {
 	router := gin.Default()
+	err := router.SetTrustedProxies(auth.TrustedProxies())
+	if err != nil {
+		log.Fatalf("cannot set trusted proxies: %v", err)
+	}
 	InitializeRoutes(router)
 	return router
 }
 
+// testMySQL is set when TEST_DB_HOST names a MySQL server for the tests,
+// they run against the memory store otherwise.
+var testMySQL = os.Getenv("TEST_DB_HOST") != ""
+
+// RequireMySQL skips tests of what only the MySQL store has.
+func RequireMySQL(t *testing.T) {
+	if !testMySQL {
+		t.Skip("needs the MySQL test database, set TEST_DB_HOST")
+	}
+}
+
 func Database() {
+	if !testMySQL {
+		repository.Default = repository.NewMemoryStore()
+		log.Printf("Using the memory store")
+		return
+	}
 	adapters.LoadTestDatabase()
+	repository.LoadMySQL(adapters.DB)
 	err := MigrateDB()
 	if err != nil {
 		log.Fatalf("cannot migrate db: %s", err)
@@ -61,19 +104,19 @@ func MigrateDB() error {
 	if err != nil {
 		log.Fatalf("cannot use database: %s", err)
 	}
-	_, err = adapters.DB.Exec("CREATE TABLE IF NOT EXISTS `users` ( `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT, `nickname` varchar(255) NOT NULL, `email` varchar(100) NOT NULL, `user_type` enum('manager','technician') DEFAULT 'technician', `password` varchar(100) NOT NULL, `created_at` datetime DEFAULT CURRENT_TIMESTAMP, `updated_at` datetime DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY `nickname` (`nickname`), UNIQUE KEY `email` (`email`) ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=latin1;")
+	_, err = migrations.Up(adapters.DB)
 	if err != nil {
-		log.Fatalf("cannot migrated users table: %s", err)
-	}
-	_, err = adapters.DB.Exec("CREATE TABLE IF NOT EXISTS `tasks` ( `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT, `summary` text NOT NULL, `author_id` bigint(10) unsigned NOT NULL, `date` datetime DEFAULT CURRENT_TIMESTAMP, `created_at` datetime DEFAULT CURRENT_TIMESTAMP, `updated_at` datetime DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), KEY `tasks_author_id_users_id_foreign` (`author_id`), CONSTRAINT `tasks_author_id_users_id_foreign` FOREIGN KEY (`author_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=latin1;")
-	if err != nil {
-		log.Fatalf("cannot migrated tasks table")
+		log.Fatalf("cannot apply migrations: %s", err)
 	}
 	log.Printf("Successfully migrated dbs table")
 	return nil
 }
 
 func RefreshTables() error {
+	if !testMySQL {
+		repository.Default = repository.NewMemoryStore()
+		return nil
+	}
 	_, err := adapters.DB.Exec("DELETE FROM `users`;")
 	if err != nil {
 		log.Fatalf("cannot erase users table: %s", err)
@@ -82,10 +125,43 @@ func RefreshTables() error {
 	if err != nil {
 		log.Fatalf("cannot erase tasks table: %s", err)
 	}
+	_, err = adapters.DB.Exec("DELETE FROM `revoked_tokens`;")
+	if err != nil {
+		log.Fatalf("cannot erase revoked_tokens table: %s", err)
+	}
+	_, err = adapters.DB.Exec("DELETE FROM `outbox`;")
+	if err != nil {
+		log.Fatalf("cannot erase outbox table: %s", err)
+	}
+	_, err = adapters.DB.Exec("DELETE FROM `login_attempts`;")
+	if err != nil {
+		log.Fatalf("cannot erase login_attempts table: %s", err)
+	}
 	log.Printf("Successfully refreshed user table")
 	return nil
 }
 
+// seedUser saves a verified user through the default store.
+func seedUser(user *models.User) error {
+	user.Prepare()
+	_, err := repository.Default.Users().Save(user)
+	if err != nil {
+		return err
+	}
+	_, err = repository.Default.Users().Verify(user.ID)
+	return err
+}
+
+// seedTask saves a task through the default store.
+func seedTask(task *models.Task) error {
+	err := task.Prepare()
+	if err != nil {
+		return err
+	}
+	_, err = repository.Default.Tasks().Save(task)
+	return err
+}
+
 func SeedOneUser() (models.User, error) {
 	user := models.User{
 		Nickname: "Pet",
@@ -93,12 +169,7 @@ func SeedOneUser() (models.User, error) {
 		Password: "password",
 		UserType: enums.TECHNICIAN,
 	}
-	err := user.HashPassword()
-	if err != nil {
-		return models.User{}, err
-	}
-	user.Prepare()
-	_, err = adapters.DB.Exec("INSERT INTO `users` (`nickname`, `email`, `user_type`, `password`) VALUES (?, ?, ?, ?);", user.Nickname, user.Email, user.UserType, user.Password)
+	err := seedUser(&user)
 	if err != nil {
 		log.Fatalf("cannot seed users table: %v", err)
 	}
@@ -106,11 +177,6 @@ func SeedOneUser() (models.User, error) {
 }
 
 func SeedUsers() ([]models.User, error) {
-	var err error
-	if err != nil {
-		return nil, err
-	}
-
 	users := []models.User{
 		{
 			Nickname: "Victor Reed",
@@ -144,21 +210,11 @@ func SeedUsers() ([]models.User, error) {
 		},
 	}
 
-	for i, user := range users {
-		err = user.HashPassword()
-		if err != nil {
-			return []models.User{}, err
-		}
-		users[i].Prepare()
-		res, err := adapters.DB.Exec("INSERT INTO `users` (`nickname`, `email`, `user_type`, `password`) VALUES (?, ?, ?, ?);", users[i].Nickname, users[i].Email, users[i].UserType, users[i].Password)
-		if err != nil {
-			return []models.User{}, err
-		}
-		lastInsertedId, err := res.LastInsertId()
+	for i := range users {
+		err := seedUser(&users[i])
 		if err != nil {
 			return []models.User{}, err
 		}
-		users[i].ID = uint64(lastInsertedId)
 	}
 	return users, nil
 }
@@ -170,30 +226,15 @@ func SeedOneUserAndOneTask() (models.User, models.Task, error) {
 		Password: "password",
 		UserType: enums.TECHNICIAN,
 	}
-	err := user.HashPassword()
-	if err != nil {
-		log.Fatalf("cannot seed users table: %v", err)
-	}
-	user.Prepare()
-	res, err := adapters.DB.Exec("INSERT INTO `users` (`nickname`, `email`, `user_type`, `password`) VALUES (?, ?, ?, ?);", user.Nickname, user.Email, user.UserType, user.Password)
-	if err != nil {
-		return models.User{}, models.Task{}, err
-	}
-	lastInsertedId, err := res.LastInsertId()
+	err := seedUser(&user)
 	if err != nil {
 		return models.User{}, models.Task{}, err
 	}
 	task := models.Task{
-		ID:       1,
 		Summary:  "This is the summary sam",
-		AuthorID: uint64(lastInsertedId),
-	}
-	encryptedTask := task
-	err = utils.Encrypt(&encryptedTask.Summary)
-	if err != nil {
-		log.Fatalf("cannot seed tasks table: %v", err)
+		AuthorID: user.ID,
 	}
-	_, err = adapters.DB.Exec("INSERT INTO `tasks` (`summary`, `author_id`) VALUES (?, ?);", encryptedTask.Summary, encryptedTask.AuthorID)
+	err = seedTask(&task)
 	if err != nil {
 		return models.User{}, models.Task{}, err
 	}
@@ -218,19 +259,24 @@ func SeedUsersAndTasks() ([]models.User, []models.Task, error) {
 	}
 
 	for i := range tasks {
-		err = utils.Encrypt(&tasks[i].Summary)
-		if err != nil {
-			log.Fatalf("cannot seed tasks table: %v", err)
-		}
-		res, err := adapters.DB.Exec("INSERT INTO `tasks` (`summary`, `author_id`) VALUES (?, ?);", tasks[i].Summary, tasks[i].AuthorID)
+		err = seedTask(&tasks[i])
 		if err != nil {
 			log.Fatalf("cannot seed tasks table: %v", err)
 		}
-		lastInsertedId, err := res.LastInsertId()
-		if err != nil {
-			return []models.User{}, []models.Task{}, err
-		}
-		tasks[i].ID = uint64(lastInsertedId)
 	}
 	return users, tasks, nil
 }
+
+// PendingOutboxMessages counts the messages for controller the relay has not
+// sent, every message the memory store keeps.
+func PendingOutboxMessages(controller string) int {
+	if store, ok := repository.Default.(*repository.MemoryStore); ok {
+		return len(store.Messages(controller))
+	}
+	var count int
+	err := adapters.DB.QueryRow("SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL AND controller = ?;", controller).Scan(&count)
+	if err != nil {
+		log.Fatalf("cannot count outbox messages: %v", err)
+	}
+	return count
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+// CreateComment adds a comment to the thread of a task
+//
+//	@Summary		Comments on a task
+//	@Description	Managers and technicians can: comment on the tasks they can read
+//	@Description	The other party of the task is notified
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"task id"
+//	@Param			body	body		models.CommentBody	true	"comment body (max length: 2500)"
+//	@Success		201	{object}	models.Comment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/comments [post]
+func CreateComment(context *gin.Context) {
+	comment := models.Comment{}
+
+	tokenUser := middlewares.CurrentUser(context)
+	task := currentTask(context)
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = json.Unmarshal(body, &comment)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = comment.Validate()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = comment.Prepare()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	comment.TaskID = task.ID
+	comment.AuthorID = tokenUser.ID
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		_, err := store.Comments().Save(&comment)
+		if err != nil {
+			return err
+		}
+		recipients, err := commentRecipients(store, task, tokenUser)
+		if err != nil {
+			return err
+		}
+		var messages []map[string]interface{}
+		for _, recipient := range recipients {
+			messages = append(messages, map[string]interface{}{
+				"nickname": tokenUser.Nickname,
+				"task_id":  strconv.Itoa(int(task.ID)),
+				"email":    recipient.Email,
+			})
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		return store.Outbox().Save(context.Request.Context(), messages, "comment")
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusCreated, comment)
+}
+
+// commentRecipients returns who is told about a new comment: the author and
+// assignees of the task and, when a technician comments, the managers. The
+// commenter and deactivated users are left out.
+func commentRecipients(store repository.Store, task *models.Task, by *models.User) ([]models.User, error) {
+	seen := map[uint64]bool{by.ID: true}
+	recipients := []models.User{}
+	for _, uid := range append([]uint64{task.AuthorID}, task.AssigneeIDs...) {
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		user, err := store.Users().FindByID(uid)
+		if err != nil {
+			return nil, err
+		}
+		if user.Active() {
+			recipients = append(recipients, *user)
+		}
+	}
+	if by.UserType != enums.TECHNICIAN {
+		return recipients, nil
+	}
+	managers, err := store.Users().FindAllManagers()
+	if err != nil {
+		return nil, err
+	}
+	for _, manager := range *managers {
+		if seen[manager.ID] {
+			continue
+		}
+		seen[manager.ID] = true
+		recipients = append(recipients, manager)
+	}
+	return recipients, nil
+}
+
+// GetComments returns the thread of a task
+//
+//	@Summary		Get the comments of a task
+//	@Description	Managers and technicians can: get the comments of the tasks they can read, oldest first
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id	path		string	true	"task id"
+//	@Success		200	{object}	[]models.Comment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/comments [get]
+func GetComments(context *gin.Context) {
+	comments, err := middlewares.Store(context).Comments().FindByTask(currentTask(context).ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, comments)
+}
+
+// GetComment returns a comment by id
+//
+//	@Summary		Get a comment by id
+//	@Description	Managers and technicians can: get the comments of the tasks they can read
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id			path		string	true	"task id"
+//	@Param			comment_id	path		string	true	"comment id"
+//	@Success		200	{object}	models.Comment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Router			/tasks/id/comments/comment_id [get]
+func GetComment(context *gin.Context) {
+	context.JSON(http.StatusOK, currentComment(context))
+}
+
+// UpdateComment edits a comment
+//
+//	@Summary		Edits a comment
+//	@Description	Managers and technicians can: edit their own comments, the previous body is kept in the history
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string				true	"task id"
+//	@Param			comment_id	path		string				true	"comment id"
+//	@Param			body		body		models.CommentBody	true	"comment body (max length: 2500)"
+//	@Success		200	{object}	models.Comment
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/comments/comment_id [put]
+func UpdateComment(context *gin.Context) {
+	comment := models.Comment{}
+
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = json.Unmarshal(body, &comment)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = comment.Validate()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = comment.Prepare()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	commentUpdated, err := middlewares.Store(context).Comments().Update(currentComment(context).ID, &comment)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, commentUpdated)
+}
+
+// DeleteComment deletes a comment with its history
+//
+//	@Summary		Deletes a comment
+//	@Description	Technicians can: delete their own comments
+//	@Description	Managers can: delete any comment
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id			path		string	true	"task id"
+//	@Param			comment_id	path		string	true	"comment id"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/comments/comment_id [delete]
+func DeleteComment(context *gin.Context) {
+	cid := currentComment(context).ID
+	res, err := middlewares.Store(context).Comments().Delete(cid)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if res == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		return
+	}
+	context.Header("Entity", fmt.Sprintf("%d", cid))
+	context.JSON(http.StatusNoContent, "")
+}
+
+// GetCommentRevisions returns the edit history of a comment
+//
+//	@Summary		Get the edit history of a comment
+//	@Description	Managers and technicians can: get the previous bodies of the comments of the tasks they can read, oldest first
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id			path		string	true	"task id"
+//	@Param			comment_id	path		string	true	"comment id"
+//	@Success		200	{object}	[]models.CommentRevision
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/comments/comment_id/revisions [get]
+func GetCommentRevisions(context *gin.Context) {
+	revisions, err := middlewares.Store(context).Comments().Revisions(currentComment(context).ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, revisions)
+}
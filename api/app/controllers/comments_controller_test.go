@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestComments(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, tasks, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	tokens := []string{}
+	for _, user := range users {
+		token, err := SignIn(user.Email, "password")
+		OnError(err, fmt.Sprintf("Cannot login as %s: %v\n", user.Email, err))
+		tokens = append(tokens, fmt.Sprintf("Bearer %v", token))
+	}
+	managerTokenString := tokens[0]
+	technicianTokenString := tokens[2]
+	secondTechnicianTokenString := tokens[3]
+	technicianTask := fmt.Sprintf("/tasks/%d/comments", tasks[0].ID)
+	secondTechnicianTask := fmt.Sprintf("/tasks/%d/comments", tasks[1].ID)
+
+	// ids of the comments created by the samples, in order
+	created := []uint64{}
+	comment := func(i int) string { return fmt.Sprintf("%s/%d", technicianTask, created[i]) }
+
+	samples := []struct {
+		method       string
+		path         func() string
+		inputJSON    string
+		tokenGiven   string
+		statusCode   int
+		body         string
+		count        int
+		messages     int
+		errorMessage string
+	}{
+		{
+			method:     "POST",
+			path:       func() string { return technicianTask },
+			inputJSON:  `{"body": "The part is missing"}`,
+			tokenGiven: technicianTokenString,
+			statusCode: 201,
+			body:       "The part is missing",
+			messages:   2,
+		},
+		{
+			method:     "POST",
+			path:       func() string { return technicianTask },
+			inputJSON:  `{"body": "Ordered it"}`,
+			tokenGiven: managerTokenString,
+			statusCode: 201,
+			body:       "Ordered it",
+			messages:   1,
+		},
+		{
+			method:       "POST",
+			path:         func() string { return technicianTask },
+			inputJSON:    `{"body": ""}`,
+			tokenGiven:   technicianTokenString,
+			statusCode:   422,
+			errorMessage: "required body",
+		},
+		{
+			// When technician comments on another technician's task
+			method:       "POST",
+			path:         func() string { return secondTechnicianTask },
+			inputJSON:    `{"body": "Need a hand?"}`,
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "GET",
+			path:       func() string { return technicianTask },
+			tokenGiven: technicianTokenString,
+			statusCode: 200,
+			count:      2,
+		},
+		{
+			method:       "GET",
+			path:         func() string { return technicianTask },
+			tokenGiven:   secondTechnicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "PUT",
+			path:       func() string { return comment(0) },
+			inputJSON:  `{"body": "The part arrived"}`,
+			tokenGiven: technicianTokenString,
+			statusCode: 200,
+			body:       "The part arrived",
+		},
+		{
+			// When technician edits the manager's comment
+			method:       "PUT",
+			path:         func() string { return comment(1) },
+			inputJSON:    `{"body": "Never ordered it"}`,
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "GET",
+			path:       func() string { return comment(0) + "/revisions" },
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			count:      1,
+		},
+		{
+			method:     "GET",
+			path:       func() string { return comment(0) },
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			body:       "The part arrived",
+		},
+		{
+			// When the comment belongs to another task
+			method:       "GET",
+			path:         func() string { return fmt.Sprintf("%s/%d", secondTechnicianTask, created[0]) },
+			tokenGiven:   managerTokenString,
+			statusCode:   404,
+			errorMessage: "comment not found",
+		},
+		{
+			method:       "DELETE",
+			path:         func() string { return comment(1) },
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "DELETE",
+			path:       func() string { return comment(0) },
+			tokenGiven: managerTokenString,
+			statusCode: 204,
+		},
+		{
+			method:       "GET",
+			path:         func() string { return comment(0) },
+			tokenGiven:   technicianTokenString,
+			statusCode:   404,
+			errorMessage: "comment not found",
+		},
+		{
+			method:     "GET",
+			path:       func() string { return technicianTask + "/unknown" },
+			tokenGiven: technicianTokenString,
+			statusCode: 400,
+		},
+		{
+			method:       "GET",
+			path:         func() string { return technicianTask },
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "authentication required",
+		},
+	}
+
+	for _, v := range samples {
+		pendingBefore := PendingOutboxMessages("comment")
+
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(v.method, v.path(), bytes.NewBufferString(v.inputJSON))
+		OnError(err, fmt.Sprintf("Error on %s %s: %v", v.method, v.path(), err))
+		req.Header.Set("Authorization", v.tokenGiven)
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 204 {
+			continue
+		}
+		if v.count > 0 {
+			list := []map[string]interface{}{}
+			err = json.Unmarshal(rr.Body.Bytes(), &list)
+			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+			assert.Equal(t, len(list), v.count)
+			continue
+		}
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		if v.statusCode == 200 || v.statusCode == 201 {
+			assert.Equal(t, responseMap["body"], v.body)
+			assert.Equal(t, PendingOutboxMessages("comment")-pendingBefore, v.messages)
+		}
+		if v.statusCode == 201 {
+			created = append(created, uint64(responseMap["id"].(float64)))
+		}
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 404 || v.statusCode == 422 {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
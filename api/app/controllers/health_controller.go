@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/migrations"
+)
+
+// readinessTimeout bounds every check, a probe that hangs is as bad as one
+// that fails.
+const readinessTimeout = 3 * time.Second
+
+// Check is the state of one dependency, Required tells whether the API stops
+// being ready when it is down.
+type Check struct {
+	Status     string      `json:"status"`
+	Required   bool        `json:"required"`
+	DurationMS int64       `json:"duration_ms"`
+	Error      string      `json:"error,omitempty"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+type Health struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+type readinessCheck struct {
+	name     string
+	required bool
+	run      func(ctx context.Context) (interface{}, error)
+}
+
+// readinessChecks are run on every /readyz. The broker is not required since
+// task writes go through the outbox, which holds the messages until the
+// publisher reconnects.
+var readinessChecks = []readinessCheck{
+	{"database", true, checkDatabase},
+	{"migrations", true, checkMigrations},
+	{"broker", false, checkBroker},
+}
+
+func checkDatabase(ctx context.Context) (interface{}, error) {
+	err := adapters.PingDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := adapters.DB.Stats()
+	return gin.H{"open_connections": stats.OpenConnections, "in_use": stats.InUse}, nil
+}
+
+func checkMigrations(ctx context.Context) (interface{}, error) {
+	if adapters.DB == nil {
+		return nil, errors.New("database not loaded")
+	}
+	version, err := migrations.Check(ctx, adapters.DB)
+	if err != nil {
+		return nil, err
+	}
+	if len(version.Pending) > 0 {
+		return version, errors.New("pending migrations")
+	}
+	return version, nil
+}
+
+func checkBroker(ctx context.Context) (interface{}, error) {
+	health := adapters.GetPublisherHealth()
+	if !health.Connected {
+		if health.LastError == "" {
+			return nil, errors.New("not connected")
+		}
+		return nil, errors.New(health.LastError)
+	}
+	return nil, nil
+}
+
+// Healthz tells the API process is alive
+//
+//	@Summary		Liveness probe
+//	@Description	Answers as long as the process serves requests, the dependencies are not checked so an outage of the database does not restart every replica
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	Health
+//	@Router			/healthz [get]
+func Healthz(context *gin.Context) {
+	context.JSON(http.StatusOK, Health{Status: "ok"})
+}
+
+// Readyz tells whether the API can serve requests
+//
+//	@Summary		Readiness probe
+//	@Description	Pings the database, compares the applied migrations with the ones the API expects and reports the broker connection
+//	@Description	The status is "ok", "degraded" when only the broker is down, or "unavailable" with a 503 when a required check fails
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	Health
+//	@Failure		503	{object}	Health
+//	@Router			/readyz [get]
+func Readyz(context *gin.Context) {
+	health := Readiness(context.Request.Context())
+	code := http.StatusOK
+	if health.Status == "unavailable" {
+		code = http.StatusServiceUnavailable
+	}
+	context.JSON(code, health)
+}
+
+// Readiness runs the checks concurrently and sums them up.
+func Readiness(ctx context.Context) Health {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	checks := make([]Check, len(readinessChecks))
+	var wg sync.WaitGroup
+	for i, check := range readinessChecks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			details, err := check.run(ctx)
+			checks[i] = Check{Status: "up", Required: check.required, Details: details}
+			if err != nil {
+				checks[i].Status = "down"
+				checks[i].Error = err.Error()
+			}
+			checks[i].DurationMS = time.Since(start).Milliseconds()
+		}(i, check)
+	}
+	wg.Wait()
+
+	health := Health{Status: "ok", Checks: map[string]Check{}}
+	for i, check := range readinessChecks {
+		health.Checks[check.name] = checks[i]
+		if checks[i].Status == "up" {
+			continue
+		}
+		if check.required {
+			health.Status = "unavailable"
+		} else if health.Status == "ok" {
+			health.Status = "degraded"
+		}
+	}
+	return health
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/migrations"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func getHealth(t *testing.T, path string) (int, Health) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", path, nil)
+	OnError(err, fmt.Sprintf("Error on GET %s: %v", path, err))
+	router.ServeHTTP(rr, req)
+
+	health := Health{}
+	err = json.Unmarshal(rr.Body.Bytes(), &health)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	return rr.Code, health
+}
+
+func TestHealthz(t *testing.T) {
+	code, health := getHealth(t, "/healthz")
+	assert.Equal(t, code, 200)
+	assert.Equal(t, health.Status, "ok")
+}
+
+func TestReadyz(t *testing.T) {
+	RequireMySQL(t)
+	// the publisher is not loaded in the tests, the broker is down
+	code, health := getHealth(t, "/readyz")
+	assert.Equal(t, code, 200)
+	assert.Equal(t, health.Status, "degraded")
+	assert.Equal(t, health.Checks["database"].Status, "up")
+	assert.Equal(t, health.Checks["migrations"].Status, "up")
+	assert.Equal(t, health.Checks["broker"].Status, "down")
+	assert.Equal(t, health.Checks["broker"].Required, false)
+	assert.Equal(t, health.Checks["broker"].Error, "publisher not loaded")
+
+	loaded, err := migrations.Load()
+	OnError(err, fmt.Sprintf("Cannot load migrations: %v", err))
+	latest := loaded[len(loaded)-1].ID
+	details := health.Checks["migrations"].Details.(map[string]interface{})
+	assert.Equal(t, details["current"], latest)
+	assert.Equal(t, details["expected"], latest)
+}
+
+func TestReadyzPendingMigrations(t *testing.T) {
+	RequireMySQL(t)
+	loaded, err := migrations.Load()
+	OnError(err, fmt.Sprintf("Cannot load migrations: %v", err))
+	latest := loaded[len(loaded)-1].ID
+	_, err = adapters.DB.Exec("DELETE FROM gorp_migrations WHERE id = ?", latest)
+	OnError(err, fmt.Sprintf("Cannot forget migration: %v", err))
+	defer func() {
+		_, err := adapters.DB.Exec("INSERT INTO gorp_migrations (id, applied_at) VALUES (?, NOW())", latest)
+		OnError(err, fmt.Sprintf("Cannot restore migration: %v", err))
+	}()
+
+	code, health := getHealth(t, "/readyz")
+	assert.Equal(t, code, 503)
+	assert.Equal(t, health.Status, "unavailable")
+	assert.Equal(t, health.Checks["database"].Status, "up")
+	assert.Equal(t, health.Checks["migrations"].Status, "down")
+	assert.Equal(t, health.Checks["migrations"].Error, "pending migrations")
+	details := health.Checks["migrations"].Details.(map[string]interface{})
+	assert.Equal(t, details["pending"], []interface{}{latest})
+}
@@ -1,26 +1,38 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/vitorbiten/maintenance/api/app/adapters"
 	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
 	"github.com/vitorbiten/maintenance/api/app/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 )
 
 // Login creates an auth token
 //
 //	@Summary		Creates an auth token
+//	@Description	Failed logins are counted per account and per client address, each failure of an account doubles the wait before its next try
+//	@Description	An account or an address that fails too often is locked for a while and the account owner is emailed, the wait is sent in Retry-After
 //	@Tags			login
 //	@Produce		json
 //	@Param			email		body		models.Email	true	"user email"
 //	@Param			password	body		models.Password	true	"user password"
-//	@Success		200	{object}	models.User
+//	@Success		200	{object}	models.TokenPair
+//	@Failure		403	{object}	nil
 //	@Failure		422	{object}	nil
+//	@Failure		429	{object}	nil
+//	@Failure		500	{object}	nil
 //	@Router			/login [post]
 func Login(context *gin.Context) {
 	body, err := io.ReadAll(context.Request.Body)
@@ -40,24 +52,210 @@ func Login(context *gin.Context) {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	token, err := SignIn(user.Email, user.Password)
+	authenticatedUser, err := signIn(context.Request.Context(), middlewares.Store(context), user.Email, user.Password, context.ClientIP())
+	var throttled *throttledError
+	if errors.As(err, &throttled) {
+		context.Header("Retry-After", fmt.Sprintf("%.0f", math.Ceil(throttled.retryAfter.Seconds())))
+		context.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err == errDeactivated {
+		context.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "incorrect details"})
 		return
 	}
+	familyID, err := auth.CreateTokenFamily()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var tokens *models.TokenPair
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		tokens, err = issueTokenPair(store, authenticatedUser.ID, familyID)
+		return err
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, tokens)
+}
 
-	context.JSON(http.StatusOK, token)
+var errDeactivated = errors.New("account deactivated")
+
+// throttledError refuses a login without checking the password, while the
+// account waits out its delay or the account or the address is locked.
+type throttledError struct {
+	retryAfter time.Duration
 }
 
-func SignIn(email, password string) (string, error) {
-	user := models.User{}
-	_, err := user.FindUserByEmail(adapters.DB, email)
+func (e *throttledError) Error() string {
+	return "too many failed login attempts"
+}
+
+// unknownPassword is compared when the email is unknown, so an unknown email
+// costs the same bcrypt comparison as a wrong password.
+var unknownPassword = sync.OnceValue(func() string {
+	hashed, _ := models.Hash("unknown password")
+	return string(hashed)
+})
+
+// authenticate only reports a deactivated account once the password matched.
+func authenticate(store repository.Store, email, password string) (*models.User, error) {
+	user, err := store.Users().FindByEmail(email)
 	if err != nil {
-		return "", err
+		_ = models.VerifyPassword(unknownPassword(), password)
+		return nil, err
 	}
 	err = models.VerifyPassword(user.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active() {
+		return nil, errDeactivated
+	}
+	return user, nil
+}
+
+// loginClock times failed logins and their delays, tests move it forward.
+var loginClock = time.Now
+
+// loginSubject is a counter of failed logins, the account of the email or the
+// address of the client.
+type loginSubject struct {
+	key         string
+	scope       string
+	maxFailures int
+}
+
+// signIn authenticates unless the failed logins of the account or of ip ask
+// to wait, and counts the failure otherwise. Unknown emails are counted and
+// locked the same as known ones so the answers do not tell them apart. ip is
+// empty when the caller has no address.
+func signIn(ctx context.Context, store repository.Store, email, password, ip string) (*models.User, error) {
+	policy := auth.CurrentLoginPolicy()
+	now := loginClock()
+	subjects := []loginSubject{{auth.AccountSubject(email), "account", policy.AccountMaxFailures}}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{auth.AddressSubject(ip), "address", policy.AddressMaxFailures})
+	}
+	var wait time.Duration
+	failed := false
+	for _, subject := range subjects {
+		attempt, err := store.LoginAttempts().Find(subject.key)
+		if err != nil {
+			return nil, err
+		}
+		// an office behind one address is only locked out, not slowed down
+		subjectWait := attempt.LockedFor(now)
+		if subject.scope == "account" {
+			subjectWait = policy.Wait(attempt, now)
+			failed = attempt.Failures > 0
+		}
+		if subjectWait > wait {
+			wait = subjectWait
+		}
+	}
+	if wait > 0 {
+		return nil, &throttledError{retryAfter: wait}
+	}
+
+	user, err := authenticate(store, email, password)
+	if err == nil && failed {
+		err = store.LoginAttempts().Delete(subjects[0].key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err == nil || err == errDeactivated {
+		return user, err
+	}
+	recordErr := recordLoginFailure(ctx, store, policy, now, email, subjects)
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	return nil, err
+}
+
+// recordLoginFailure counts the failure and locks the subjects that reached
+// their maximum, the owner of a newly locked account is emailed.
+func recordLoginFailure(ctx context.Context, store repository.Store, policy auth.LoginPolicy, now time.Time, email string, subjects []loginSubject) error {
+	for _, subject := range subjects {
+		attempt, err := store.LoginAttempts().RecordFailure(subject.key, now, policy.Window)
+		if err != nil {
+			return err
+		}
+		if attempt.Failures < subject.maxFailures {
+			continue
+		}
+		until := now.Add(policy.Lockout)
+		locked, err := store.LoginAttempts().Lock(subject.key, now, until)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			continue
+		}
+		logging.FromContext(ctx).Warn("login locked", "scope", subject.scope, "failures", attempt.Failures, "locked_until", until)
+		if subject.scope == "account" {
+			err = announceLockout(ctx, store, email, until)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// announceLockout sends a lockout message for the owner of the account, an
+// unknown email has nobody to tell.
+func announceLockout(ctx context.Context, store repository.Store, email string, until time.Time) error {
+	owner, err := store.Users().FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return store.Outbox().Save(ctx, []map[string]interface{}{{
+		"nickname":     owner.Nickname,
+		"email":        owner.Email,
+		"locked_until": until.UTC().Format(time.RFC3339),
+	}}, "lockout")
+}
+
+// SignIn returns an access token for the given credentials.
+func SignIn(email, password string) (string, error) {
+	user, err := signIn(context.Background(), repository.Default, email, password, "")
+	if err != nil {
 		return "", err
 	}
 	return auth.CreateToken(user.ID)
 }
+
+// issueTokenPair creates an access token and stores a new refresh token in
+// the given family.
+func issueTokenPair(store repository.Store, uid uint64, familyID string) (*models.TokenPair, error) {
+	accessToken, err := auth.CreateToken(uid)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, hash, err := auth.CreateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	record := models.RefreshToken{
+		UserID:    uid,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenExpiration()),
+	}
+	err = store.Tokens().SaveRefreshToken(&record)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
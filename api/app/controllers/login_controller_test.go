@@ -3,12 +3,14 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 	"gopkg.in/go-playground/assert.v1"
 )
 
@@ -43,7 +45,7 @@ func TestSignIn(t *testing.T) {
 	for _, v := range samples {
 		token, err := SignIn(v.email, v.password)
 		if err != nil {
-			assert.Equal(t, err, errors.New(v.errorMessage))
+			assert.Equal(t, err.Error(), v.errorMessage)
 		} else {
 			assert.NotEqual(t, token, "")
 		}
@@ -114,3 +116,153 @@ func TestLogin(t *testing.T) {
 		}
 	}
 }
+
+func postLogin(email, password, address string) (int, string, map[string]interface{}) {
+	return postLoginForwarded(email, password, address, "")
+}
+
+// postLoginForwarded logs in from address with forwardedFor as its
+// X-Forwarded-For header, when one is given.
+func postLoginForwarded(email, password, address, forwardedFor string) (int, string, map[string]interface{}) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)))
+	OnError(err, fmt.Sprintf("Error on POST /login: %v", err))
+	req.RemoteAddr = address + ":41000"
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	router.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	return rr.Code, rr.Header().Get("Retry-After"), responseMap
+}
+
+func TestLoginDelay(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	user, err := SeedOneUser()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	t.Setenv("LOGIN_BASE_DELAY_MS", "1000")
+	// whole seconds are kept by MySQL datetime columns
+	at := time.Now().Truncate(time.Second)
+	loginClock = func() time.Time { return at }
+	defer func() { loginClock = time.Now }()
+
+	code, _, _ := postLogin(user.Email, "wrong password", "10.0.0.1")
+	assert.Equal(t, code, 422)
+
+	// the right password has to wait for the delay of the failure
+	code, retryAfter, responseMap := postLogin(user.Email, "password", "10.0.0.1")
+	assert.Equal(t, code, 429)
+	assert.Equal(t, retryAfter, "1")
+	assert.Equal(t, responseMap["error"], "too many failed login attempts")
+
+	at = at.Add(time.Second)
+	code, _, _ = postLogin(user.Email, "password", "10.0.0.1")
+	assert.Equal(t, code, 200)
+
+	// a successful login forgets the failures
+	code, _, _ = postLogin(user.Email, "wrong password", "10.0.0.1")
+	assert.Equal(t, code, 422)
+	attempt, err := repository.Default.LoginAttempts().Find(auth.AccountSubject(user.Email))
+	OnError(err, fmt.Sprintf("Cannot read login attempts: %v", err))
+	assert.Equal(t, attempt.Failures, 1)
+}
+
+func TestLoginLockout(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerToken, err := SignIn(users[0].Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	technicianToken, err := SignIn(users[3].Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as technician: %v\n", err))
+	t.Setenv("LOGIN_BASE_DELAY_MS", "1")
+	t.Setenv("LOGIN_MAX_FAILURES", "3")
+
+	fail := func(email string, times int) {
+		for i := 0; i < times; i++ {
+			time.Sleep(10 * time.Millisecond)
+			code, _, responseMap := postLogin(email, "wrong password", "10.0.0.2")
+			assert.Equal(t, code, 422)
+			assert.Equal(t, responseMap["error"], "incorrect details")
+		}
+	}
+
+	// the third failure locks the account and emails its owner once
+	fail(users[2].Email, 3)
+	assert.Equal(t, PendingOutboxMessages("lockout"), 1)
+	code, retryAfter, locked := postLogin(users[2].Email, "password", "10.0.0.3")
+	assert.Equal(t, code, 429)
+	assert.Equal(t, retryAfter, "900")
+	code, _, _ = postLogin(users[2].Email, "wrong password", "10.0.0.3")
+	assert.Equal(t, code, 429)
+	assert.Equal(t, PendingOutboxMessages("lockout"), 1)
+
+	// unknown emails are locked the same way, with nobody to email
+	fail("nobody@gmail.com", 3)
+	code, retryAfter, unknown := postLogin("nobody@gmail.com", "password", "10.0.0.3")
+	assert.Equal(t, code, 429)
+	assert.Equal(t, retryAfter, "900")
+	assert.Equal(t, unknown, locked)
+	assert.Equal(t, PendingOutboxMessages("lockout"), 1)
+
+	unlock := func(token string) int {
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", fmt.Sprintf("/users/%d/unlock", users[2].ID), nil)
+		OnError(err, fmt.Sprintf("Error on POST /users/id/unlock: %v", err))
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, unlock(technicianToken), 403)
+	assert.Equal(t, unlock(managerToken), 200)
+	code, _, _ = postLogin(users[2].Email, "password", "10.0.0.3")
+	assert.Equal(t, code, 200)
+}
+
+func TestLoginAddressLockout(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	t.Setenv("LOGIN_MAX_FAILURES_PER_IP", "3")
+
+	// one failure per account stays under the account limit
+	for _, email := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		code, _, _ := postLogin(email, "password", "10.0.0.4")
+		assert.Equal(t, code, 422)
+	}
+	code, _, _ := postLogin(users[2].Email, "password", "10.0.0.4")
+	assert.Equal(t, code, 429)
+	code, _, _ = postLogin(users[2].Email, "password", "10.0.0.5")
+	assert.Equal(t, code, 200)
+	// address lockouts are not emailed
+	assert.Equal(t, PendingOutboxMessages("lockout"), 0)
+}
+
+func TestLoginSpoofedForwardedFor(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	t.Setenv("LOGIN_MAX_FAILURES_PER_IP", "3")
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	// a new X-Forwarded-For per failure still counts against the peer address
+	for i, email := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		code, _, _ := postLoginForwarded(email, "password", "10.0.0.6", fmt.Sprintf("192.0.2.%d", i+1))
+		assert.Equal(t, code, 422)
+	}
+	code, _, _ := postLoginForwarded(users[2].Email, "password", "10.0.0.6", "192.0.2.9")
+	assert.Equal(t, code, 429)
+
+	// the header of a trusted proxy names the client
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.6")
+	code, _, _ = postLoginForwarded(users[2].Email, "password", "10.0.0.6", "192.0.2.9")
+	assert.Equal(t, code, 200)
+}
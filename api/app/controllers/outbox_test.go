@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	rabbitmqAdapter "github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/outbox"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	RequireMySQL(t)
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	technicianToken, err := SignIn(users[2].Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as technician: %v\n", err))
+
+	spans := tracing.UseInMemory()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"summary": "the summary"}`))
+	OnError(err, fmt.Sprintf("Error on POST /tasks: %v", err))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", technicianToken))
+	req.Header.Set("X-Request-ID", "create-task-1")
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-00f067aa0ba902b7-01", traceID))
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 201)
+	assert.Equal(t, rr.Header().Get("X-Request-ID"), "create-task-1")
+	assert.Equal(t, PendingOutboxMessages("notification"), 2)
+
+	// The request continues the caller's trace, down to its queries
+	handlerSpans, sqlSpans := 0, 0
+	for _, span := range spans.GetSpans() {
+		if span.SpanContext.TraceID().String() != traceID {
+			continue
+		}
+		if span.Name == "/tasks" {
+			handlerSpans++
+		}
+		for _, attr := range span.Attributes {
+			if attr.Key == "db.system" && attr.Value.AsString() == "mysql" {
+				sqlSpans++
+				break
+			}
+		}
+	}
+	assert.Equal(t, handlerSpans, 1)
+	assert.NotEqual(t, sqlSpans, 0)
+
+	// When the broker is down the messages stay pending
+	rabbitmqAdapter.PublishMessages = func(ctx context.Context, messages []map[string]interface{}, controller string) error {
+		return errors.New("failed to connect to RabbitMQ")
+	}
+	sent, err := outbox.RelayOnce(repository.Default)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, sent, 0)
+	assert.Equal(t, PendingOutboxMessages("notification"), 2)
+
+	emails := []interface{}{}
+	rabbitmqAdapter.PublishMessages = func(ctx context.Context, messages []map[string]interface{}, controller string) error {
+		assert.Equal(t, controller, "notification")
+		// the relay runs after the request, the id comes from the outbox row
+		assert.Equal(t, logging.RequestID(ctx), "create-task-1")
+		assert.Equal(t, trace.SpanContextFromContext(ctx).TraceID().String(), traceID)
+		for _, message := range messages {
+			emails = append(emails, message["email"])
+		}
+		return nil
+	}
+	sent, err = outbox.RelayOnce(repository.Default)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sent, 2)
+	assert.Equal(t, emails, []interface{}{users[0].Email, users[1].Email})
+	assert.Equal(t, PendingOutboxMessages("notification"), 0)
+
+	// Sent messages are not published again
+	sent, err = outbox.RelayOnce(repository.Default)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sent, 0)
+
+	// Sent messages are kept for OUTBOX_RETENTION
+	t.Setenv("OUTBOX_RETENTION", "1h")
+	pruned, err := outbox.Prune(repository.Default, time.Now())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pruned, int64(0))
+	pruned, err = outbox.Prune(repository.Default, time.Now().Add(2*time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pruned, int64(2))
+}
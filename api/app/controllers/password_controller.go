@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+// passwordResetInterval is the least time between two reset emails of an
+// account, asking again sooner answers the same without sending anything.
+const passwordResetInterval = time.Minute
+
+const errInvalidResetToken = "invalid or expired reset token"
+
+// ForgotPassword emails a password reset link
+//
+//	@Summary		Emails a link to reset the password
+//	@Description	The answer is the same whether the email belongs to an account or not, active accounts get a single use link valid for PASSWORD_RESET_EXP_MINUTES
+//	@Description	A new link replaces the previous ones, at most one is sent per minute
+//	@Tags			login
+//	@Accept			json
+//	@Produce		json
+//	@Param			email	body		models.ForgotPasswordBody	true	"account email"
+//	@Success		202	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/password/forgot [post]
+func ForgotPassword(context *gin.Context) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	request := models.ForgotPasswordBody{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	user := models.User{Email: request.Email}
+	user.Prepare()
+	err = user.Validate("email")
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = sendPasswordReset(context.Request.Context(), middlewares.Store(context), user.Email)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusAccepted, gin.H{"message": "if the email belongs to an account, a reset link was sent to it"})
+}
+
+// sendPasswordReset stores a new reset token for the account of email and
+// queues the password_reset message with its link, in one transaction.
+// Unknown and deactivated accounts are skipped without an error.
+func sendPasswordReset(ctx context.Context, store repository.Store, email string) error {
+	return store.Transaction(func(store repository.Store) error {
+		found, err := store.Users().FindByEmail(email)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		user, err := store.Users().FindByID(found.ID)
+		if err != nil {
+			return err
+		}
+		if !user.Active() {
+			return nil
+		}
+		recent, err := store.SingleUseTokens().Recent(models.PasswordResetPurpose, user.ID, passwordResetInterval)
+		if err != nil || recent {
+			return err
+		}
+		return sendSingleUseToken(ctx, store, user, models.PasswordResetPurpose, "password_reset", auth.PasswordResetExpiration(), auth.PasswordResetURL)
+	})
+}
+
+// ResetPassword sets a new password with a reset token
+//
+//	@Summary		Sets a new password with an emailed reset token
+//	@Description	The token is single use, every refresh token of the account is revoked and access tokens issued before stop working
+//	@Tags			login
+//	@Accept			json
+//	@Produce		json
+//	@Param			reset	body		models.ResetPasswordBody	true	"reset token and new password"
+//	@Success		204	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/password/reset [post]
+func ResetPassword(context *gin.Context) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	request := models.ResetPasswordBody{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Token == "" {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "required reset token"})
+		return
+	}
+	if request.Password == "" {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "required password"})
+		return
+	}
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		user, err := redeemSingleUseToken(store, models.PasswordResetPurpose, request.Token)
+		if err != nil {
+			return err
+		}
+		_, err = store.Users().ResetPassword(user.ID, request.Password)
+		if err != nil {
+			return err
+		}
+		err = store.Tokens().RevokeUser(user.ID)
+		if err != nil {
+			return err
+		}
+		// the owner proved they read the account's email, the lockout of
+		// their failed logins is lifted
+		return store.LoginAttempts().Delete(auth.AccountSubject(user.Email))
+	})
+	if err == errInvalidSingleUseToken {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": errInvalidResetToken})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusNoContent, "")
+}
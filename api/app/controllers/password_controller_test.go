@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func postJSON(path, body string) (int, map[string]interface{}) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	OnError(err, fmt.Sprintf("Error on POST %s: %v", path, err))
+	router.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	if rr.Body.Len() > 0 {
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	}
+	return rr.Code, responseMap
+}
+
+// linkToken reads the token in the link of the latest message for controller
+func linkToken(controller string) string {
+	message := map[string]interface{}{}
+	if store, ok := repository.Default.(*repository.MemoryStore); ok {
+		messages := store.Messages(controller)
+		message = messages[len(messages)-1]
+	} else {
+		var payload string
+		err := adapters.DB.QueryRow("SELECT payload FROM outbox WHERE controller = ? ORDER BY id DESC LIMIT 1;", controller).Scan(&payload)
+		OnError(err, fmt.Sprintf("Cannot read %s message: %v", controller, err))
+		err = json.Unmarshal([]byte(payload), &message)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	}
+	link, err := url.Parse(message["link"].(string))
+	OnError(err, fmt.Sprintf("Cannot parse link: %v", err))
+	return link.Query().Get("token")
+}
+
+func TestForgotPassword(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	user, err := SeedOneUser()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+		messages     int
+	}{
+		{
+			inputJSON:    `{"email": "kangmail.com"}`,
+			statusCode:   422,
+			errorMessage: "invalid email",
+		},
+		{
+			inputJSON:    `{"email": ""}`,
+			statusCode:   422,
+			errorMessage: "required email",
+		},
+		{
+			// unknown emails get the same answer
+			inputJSON:  `{"email": "frank@gmail.com"}`,
+			statusCode: 202,
+		},
+		{
+			inputJSON:  fmt.Sprintf(`{"email": "%s"}`, user.Email),
+			statusCode: 202,
+			messages:   1,
+		},
+		{
+			// a second ask within a minute sends nothing
+			inputJSON:  fmt.Sprintf(`{"email": "%s"}`, user.Email),
+			statusCode: 202,
+			messages:   1,
+		},
+	}
+
+	for _, v := range samples {
+		code, responseMap := postJSON("/password/forgot", v.inputJSON)
+		assert.Equal(t, code, v.statusCode)
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+		if v.statusCode == 202 {
+			assert.Equal(t, responseMap["message"], "if the email belongs to an account, a reset link was sent to it")
+		}
+		assert.Equal(t, PendingOutboxMessages("password_reset"), v.messages)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	// the old password is tried once before the new one
+	t.Setenv("LOGIN_BASE_DELAY_MS", "1")
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	user, err := SeedOneUser()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	tokens := login(user.Email, "password")
+
+	code, _ := postJSON("/password/forgot", fmt.Sprintf(`{"email": "%s"}`, user.Email))
+	assert.Equal(t, code, 202)
+	token := linkToken("password_reset")
+	assert.NotEqual(t, token, "")
+
+	err = repository.Default.SingleUseTokens().Save(&models.SingleUseToken{UserID: user.ID, Purpose: models.PasswordResetPurpose, Email: user.Email, TokenHash: auth.HashToken("expired"), ExpiresAt: time.Now().Add(-time.Minute)})
+	OnError(err, fmt.Sprintf("Cannot seed expired reset: %v", err))
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{inputJSON: `{"token": "", "password": "new password"}`, statusCode: 422, errorMessage: "required reset token"},
+		{inputJSON: fmt.Sprintf(`{"token": "%s", "password": ""}`, token), statusCode: 422, errorMessage: "required password"},
+		{inputJSON: `{"token": "wrong", "password": "new password"}`, statusCode: 422, errorMessage: "invalid or expired reset token"},
+		{inputJSON: `{"token": "expired", "password": "new password"}`, statusCode: 422, errorMessage: "invalid or expired reset token"},
+	}
+	for _, v := range samples {
+		code, responseMap := postJSON("/password/reset", v.inputJSON)
+		assert.Equal(t, code, v.statusCode)
+		assert.Equal(t, responseMap["error"], v.errorMessage)
+	}
+
+	// access tokens are compared to the reset by the second they were issued
+	time.Sleep(time.Second)
+	code, _ = postJSON("/password/reset", fmt.Sprintf(`{"token": "%s", "password": "new password"}`, token))
+	assert.Equal(t, code, 204)
+
+	// the token is single use
+	code, responseMap := postJSON("/password/reset", fmt.Sprintf(`{"token": "%s", "password": "other password"}`, token))
+	assert.Equal(t, code, 422)
+	assert.Equal(t, responseMap["error"], "invalid or expired reset token")
+
+	// the sessions from before the reset are revoked
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/users/%d", user.ID), nil)
+	OnError(err, fmt.Sprintf("Error on GET /users/id: %v", err))
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 401)
+	code, _ = refresh(tokens.RefreshToken)
+	assert.Equal(t, code, 401)
+
+	code, _ = postJSON("/login", fmt.Sprintf(`{"email": "%s", "password": "password"}`, user.Email))
+	assert.Equal(t, code, 422)
+	fresh := login(user.Email, "new password")
+	assert.NotEqual(t, fresh.AccessToken, "")
+}
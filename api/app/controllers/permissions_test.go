@@ -0,0 +1,611 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+)
+
+// TestPermissionsMap checks every cell of the README permissions map against
+// the real routes, tables are reseeded for each row so they don't interfere.
+func TestPermissionsMap(t *testing.T) {
+	const (
+		manager          = 0
+		secondManager    = 1
+		technician       = 2
+		secondTechnician = 3
+	)
+
+	samples := []struct {
+		cell      string
+		caller    int
+		method    string
+		path      func(users []models.User, tasks []models.Task) string
+		inputJSON string
+		// input builds the body when it needs seeded ids, overriding inputJSON
+		input func(users []models.User) string
+		// setup seeds more tasks for the row and returns all of them
+		setup      func(users []models.User, tasks []models.Task) []models.Task
+		statusCode int
+	}{
+		// Users, manager, self
+		{
+			cell:       "manager cannot create a manager",
+			caller:     manager,
+			method:     "POST",
+			path:       func(users []models.User, tasks []models.Task) string { return "/users" },
+			inputJSON:  `{"nickname":"New", "email": "new@gmail.com", "password": "password", "user_type": "manager"}`,
+			statusCode: 422,
+		},
+		{
+			cell:   "manager reads self",
+			caller: manager,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[manager].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "manager updates self",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[manager].ID)
+			},
+			inputJSON:  `{"nickname":"Grand", "email": "grand@gmail.com", "password": "password"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "manager deletes self",
+			caller: manager,
+			method: "DELETE",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[manager].ID)
+			},
+			statusCode: 204,
+		},
+		// Users, manager, others
+		{
+			cell:       "manager lists technicians",
+			caller:     manager,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return "/users" },
+			statusCode: 200,
+		},
+		{
+			cell:   "manager reads technicians",
+			caller: manager,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "manager cannot read managers",
+			caller: manager,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[secondManager].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "manager cannot update others",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			inputJSON:  `{"nickname":"Mike", "email": "mike@gmail.com", "password": "password"}`,
+			statusCode: 403,
+		},
+		{
+			cell:   "manager cannot delete others",
+			caller: manager,
+			method: "DELETE",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "manager promotes others",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/role", users[technician].ID)
+			},
+			inputJSON:  `{"user_type": "manager"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "manager demotes others",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/role", users[secondManager].ID)
+			},
+			inputJSON:  `{"user_type": "technician"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "manager deactivates others",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/deactivate", users[technician].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "manager reactivates others",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/reactivate", users[technician].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "manager unlocks others",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/unlock", users[technician].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "manager cannot demote self",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/role", users[manager].ID)
+			},
+			inputJSON:  `{"user_type": "technician"}`,
+			statusCode: 403,
+		},
+		{
+			cell:   "manager cannot deactivate self",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/deactivate", users[manager].ID)
+			},
+			statusCode: 403,
+		},
+		// Users, technician, self
+		{
+			cell:       "technician creates self",
+			caller:     -1,
+			method:     "POST",
+			path:       func(users []models.User, tasks []models.Task) string { return "/users" },
+			inputJSON:  `{"nickname":"New", "email": "new@gmail.com", "password": "password"}`,
+			statusCode: 201,
+		},
+		{
+			cell:   "technician reads self",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "technician updates self",
+			caller: technician,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			inputJSON:  `{"nickname":"Tomas", "email": "tomas@gmail.com", "password": "password"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "technician deletes self",
+			caller: technician,
+			method: "DELETE",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[technician].ID)
+			},
+			statusCode: 204,
+		},
+		// Users, technician, others
+		{
+			cell:       "technician cannot list users",
+			caller:     technician,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return "/users" },
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot read others",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot update others",
+			caller: technician,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[secondTechnician].ID)
+			},
+			inputJSON:  `{"nickname":"Mike", "email": "mike@gmail.com", "password": "password"}`,
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot delete others",
+			caller: technician,
+			method: "DELETE",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d", users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot promote self",
+			caller: technician,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/role", users[technician].ID)
+			},
+			inputJSON:  `{"user_type": "manager"}`,
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot deactivate others",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/deactivate", users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot unlock others",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/users/%d/unlock", users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		// Tasks, manager, self
+		{
+			cell:   "manager creates work orders",
+			caller: manager,
+			method: "POST",
+			path:   func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			input: func(users []models.User) string {
+				return fmt.Sprintf(`{"summary": "summary", "assignee_ids": [%d]}`, users[technician].ID)
+			},
+			statusCode: 201,
+		},
+		{
+			cell:       "manager cannot create unassigned tasks",
+			caller:     manager,
+			method:     "POST",
+			path:       func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			inputJSON:  `{"summary": "summary"}`,
+			statusCode: 422,
+		},
+		{
+			cell:   "manager updates own work orders",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d", tasks[2].ID)
+			},
+			inputJSON:  `{"summary": "summary"}`,
+			setup:      seedWorkOrder,
+			statusCode: 200,
+		},
+		// Tasks, manager, others
+		{
+			cell:       "manager lists tasks",
+			caller:     manager,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			statusCode: 200,
+		},
+		{
+			cell:       "manager reads others",
+			caller:     manager,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			statusCode: 200,
+		},
+		{
+			cell:       "manager cannot update others",
+			caller:     manager,
+			method:     "PUT",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			inputJSON:  `{"summary": "summary"}`,
+			statusCode: 403,
+		},
+		{
+			cell:       "manager deletes others",
+			caller:     manager,
+			method:     "DELETE",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			statusCode: 204,
+		},
+		{
+			cell:   "manager transitions others",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/transitions", tasks[0].ID)
+			},
+			inputJSON:  `{"status": "cancelled"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "manager assigns others",
+			caller: manager,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/assignees", tasks[0].ID)
+			},
+			input: func(users []models.User) string {
+				return fmt.Sprintf(`{"assignee_ids": [%d]}`, users[secondTechnician].ID)
+			},
+			statusCode: 200,
+		},
+		// Tasks, technician, self
+		{
+			cell:       "technician creates tasks",
+			caller:     technician,
+			method:     "POST",
+			path:       func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			inputJSON:  `{"summary": "summary"}`,
+			statusCode: 201,
+		},
+		{
+			cell:       "technician lists own tasks",
+			caller:     technician,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			statusCode: 200,
+		},
+		{
+			cell:       "technician reads own tasks",
+			caller:     technician,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			statusCode: 200,
+		},
+		{
+			cell:       "technician updates own tasks",
+			caller:     technician,
+			method:     "PUT",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			inputJSON:  `{"summary": "summary"}`,
+			statusCode: 200,
+		},
+		{
+			cell:       "technician cannot delete own tasks",
+			caller:     technician,
+			method:     "DELETE",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[0].ID) },
+			statusCode: 403,
+		},
+		{
+			cell:   "technician transitions own tasks",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/transitions", tasks[0].ID)
+			},
+			inputJSON:  `{"status": "in_progress"}`,
+			statusCode: 200,
+		},
+		{
+			cell:   "technician cannot assign own tasks",
+			caller: technician,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/assignees", tasks[0].ID)
+			},
+			input: func(users []models.User) string {
+				return fmt.Sprintf(`{"assignee_ids": [%d]}`, users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot create work orders",
+			caller: technician,
+			method: "POST",
+			path:   func(users []models.User, tasks []models.Task) string { return "/tasks" },
+			input: func(users []models.User) string {
+				return fmt.Sprintf(`{"summary": "summary", "assignee_ids": [%d]}`, users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:   "assignee cannot update work order",
+			caller: technician,
+			method: "PUT",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d", tasks[2].ID)
+			},
+			inputJSON:  `{"summary": "summary"}`,
+			setup:      seedWorkOrder,
+			statusCode: 403,
+		},
+		{
+			cell:   "assignee transitions work order",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/transitions", tasks[2].ID)
+			},
+			inputJSON:  `{"status": "in_progress"}`,
+			setup:      seedWorkOrder,
+			statusCode: 200,
+		},
+		// Tasks, technician, others
+		{
+			cell:   "technician cannot list others",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks?author_id=%d", users[secondTechnician].ID)
+			},
+			statusCode: 403,
+		},
+		{
+			cell:       "technician cannot read others",
+			caller:     technician,
+			method:     "GET",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[1].ID) },
+			statusCode: 403,
+		},
+		{
+			cell:       "technician cannot update others",
+			caller:     technician,
+			method:     "PUT",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[1].ID) },
+			inputJSON:  `{"summary": "summary"}`,
+			statusCode: 403,
+		},
+		{
+			cell:       "technician cannot delete others",
+			caller:     technician,
+			method:     "DELETE",
+			path:       func(users []models.User, tasks []models.Task) string { return fmt.Sprintf("/tasks/%d", tasks[1].ID) },
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot transition others",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/transitions", tasks[1].ID)
+			},
+			inputJSON:  `{"status": "in_progress"}`,
+			statusCode: 403,
+		},
+		// Comments
+		{
+			cell:   "manager comments on others",
+			caller: manager,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/comments", tasks[0].ID)
+			},
+			inputJSON:  `{"body": "comment"}`,
+			statusCode: 201,
+		},
+		{
+			cell:   "technician comments on own tasks",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/comments", tasks[0].ID)
+			},
+			inputJSON:  `{"body": "comment"}`,
+			statusCode: 201,
+		},
+		{
+			cell:   "technician cannot comment on others",
+			caller: technician,
+			method: "POST",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/comments", tasks[1].ID)
+			},
+			inputJSON:  `{"body": "comment"}`,
+			statusCode: 403,
+		},
+		{
+			cell:   "technician cannot read comments of others",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/comments", tasks[1].ID)
+			},
+			statusCode: 403,
+		},
+		// Attachments
+		{
+			cell:   "manager lists attachments of others",
+			caller: manager,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/attachments", tasks[0].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "technician lists attachments of own tasks",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/attachments", tasks[0].ID)
+			},
+			statusCode: 200,
+		},
+		{
+			cell:   "technician cannot list attachments of others",
+			caller: technician,
+			method: "GET",
+			path: func(users []models.User, tasks []models.Task) string {
+				return fmt.Sprintf("/tasks/%d/attachments", tasks[1].ID)
+			},
+			statusCode: 403,
+		},
+	}
+
+	for _, v := range samples {
+		err := RefreshTables()
+		OnError(err, "Error refreshing tables")
+		users, tasks, err := SeedUsersAndTasks()
+		OnError(err, fmt.Sprintf("Error seeding users and tasks: %v\n", err))
+		if v.setup != nil {
+			tasks = v.setup(users, tasks)
+		}
+
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		inputJSON := v.inputJSON
+		if v.input != nil {
+			inputJSON = v.input(users)
+		}
+		req, err := http.NewRequest(v.method, v.path(users, tasks), bytes.NewBufferString(inputJSON))
+		OnError(err, fmt.Sprintf("Error on %s: %v", v.cell, err))
+		if v.caller >= 0 {
+			token, err := SignIn(users[v.caller].Email, "password")
+			OnError(err, fmt.Sprintf("Cannot login: %v\n", err))
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		}
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != v.statusCode {
+			t.Errorf("%s: expected %d, got %d", v.cell, v.statusCode, rr.Code)
+		}
+	}
+}
+
+// seedWorkOrder adds a task of the first manager assigned to the first
+// technician.
+func seedWorkOrder(users []models.User, tasks []models.Task) []models.Task {
+	order := models.Task{
+		Summary:     "Fix the boiler",
+		AuthorID:    users[0].ID,
+		AssigneeIDs: []uint64{users[2].ID},
+	}
+	err := seedTask(&order)
+	OnError(err, fmt.Sprintf("Error seeding work order: %v\n", err))
+	return append(tasks, order)
+}
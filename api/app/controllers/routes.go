@@ -1,32 +1,93 @@
 package controllers
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	_ "github.com/vitorbiten/maintenance/api/app/docs"
+	"github.com/vitorbiten/maintenance/api/app/metrics"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/policy"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
+// probes are scraped every few seconds, tracing them would bury the requests.
+var probes = map[string]bool{"/metrics": true, "/healthz": true, "/readyz": true}
+
 func InitializeRoutes(r *gin.Engine) {
+	r.Use(
+		otelgin.Middleware("maintenance-api", otelgin.WithFilter(func(r *http.Request) bool {
+			return !probes[r.URL.Path]
+		})),
+		middlewares.RequestID(),
+		middlewares.Metrics(),
+	)
+
 	// Home Route
 	r.GET("/", Home)
 
-	// Login Route
+	// Public routes
 	r.POST("/login", Login)
+	r.POST("/token/refresh", RefreshToken)
+	r.GET("/.well-known/jwks.json", JWKS)
+	r.POST("/users", CreateUser)
+	r.POST("/password/forgot", ForgotPassword)
+	r.POST("/password/reset", ResetPassword)
+	r.GET("/verify", VerifyEmail)
+
+	// Authenticated routes
+	authenticated := r.Group("/")
+	authenticated.Use(middlewares.SetMiddlewareAuthentication())
+	authenticated.POST("/logout", Logout)
+	authenticated.POST("/verify/resend", ResendVerification)
 
 	//Users routes
-	r.POST("/users", CreateUser)
-	r.GET("/users", GetUsers)
-	r.GET("/users/:id", GetUser)
-	r.PUT("/users/:id", UpdateUser)
-	r.DELETE("/users/:id", DeleteUser)
+	users := authenticated.Group("/users")
+	users.GET("", middlewares.Authorize(policy.USERS, policy.LIST, nil), GetUsers)
+	users.GET("/:id", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.READ, userTarget(true)), GetUser)
+	users.PUT("/:id", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.UPDATE, userTarget(false)), UpdateUser)
+	users.DELETE("/:id", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.DELETE, userTarget(false)), DeleteUser)
+	users.PUT("/:id/role", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.ADMINISTER, userTarget(true)), UpdateUserRole)
+	users.POST("/:id/deactivate", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.ADMINISTER, userTarget(true)), DeactivateUser)
+	users.POST("/:id/reactivate", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.ADMINISTER, userTarget(true)), ReactivateUser)
+	users.POST("/:id/unlock", middlewares.RequireID(), middlewares.Authorize(policy.USERS, policy.ADMINISTER, userTarget(true)), UnlockUser)
 
 	//Tasks routes
-	r.POST("/tasks", CreateTask)
-	r.GET("/tasks", GetTasks)
-	r.GET("/tasks/:id", GetTask)
-	r.PUT("/tasks/:id", UpdateTask)
-	r.DELETE("/tasks/:id", DeleteTask)
+	tasks := authenticated.Group("/tasks")
+	tasks.POST("", middlewares.Authorize(policy.TASKS, policy.CREATE, nil), middlewares.RequireVerified(), CreateTask)
+	tasks.GET("", middlewares.Authorize(policy.TASKS, policy.LIST, nil), GetTasks)
+	tasks.GET("/search", middlewares.Authorize(policy.TASKS, policy.LIST, nil), SearchTasks)
+	tasks.GET("/:id", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.READ, taskTarget), GetTask)
+	tasks.PUT("/:id", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.UPDATE, taskAuthorTarget), UpdateTask)
+	tasks.DELETE("/:id", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.DELETE, taskTarget), DeleteTask)
+	tasks.POST("/:id/transitions", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.TRANSITION, taskTarget), TransitionTask)
+	tasks.PUT("/:id/assignees", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.ASSIGN, taskTarget), AssignTask)
+	tasks.DELETE("/:id/assignees/:user_id", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.ASSIGN, taskTarget), UnassignTask)
+
+	//Comments routes
+	comments := tasks.Group("/:id/comments", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.READ, taskTarget))
+	comments.GET("", GetComments)
+	comments.POST("", CreateComment)
+	comments.GET("/:comment_id", middlewares.Authorize(policy.COMMENTS, policy.READ, commentTarget), GetComment)
+	comments.PUT("/:comment_id", middlewares.Authorize(policy.COMMENTS, policy.UPDATE, commentTarget), UpdateComment)
+	comments.DELETE("/:comment_id", middlewares.Authorize(policy.COMMENTS, policy.DELETE, commentTarget), DeleteComment)
+	comments.GET("/:comment_id/revisions", middlewares.Authorize(policy.COMMENTS, policy.READ, commentTarget), GetCommentRevisions)
+
+	//Attachments routes
+	attachments := tasks.Group("/:id/attachments", middlewares.RequireID(), middlewares.Authorize(policy.TASKS, policy.READ, taskTarget))
+	attachments.GET("", GetAttachments)
+	attachments.POST("", CreateAttachment)
+	attachments.GET("/:attachment_id", middlewares.Authorize(policy.ATTACHMENTS, policy.READ, attachmentTarget), GetAttachment)
+	attachments.DELETE("/:attachment_id", middlewares.Authorize(policy.ATTACHMENTS, policy.DELETE, attachmentTarget), DeleteAttachment)
+
+	// Kubernetes probes
+	r.GET("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+
+	// Prometheus scrapes
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	r.GET("/swagger/*any",
 		ginSwagger.WrapHandler(swaggerfiles.Handler))
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+// errInvalidSingleUseToken is answered with the message of the purpose, like
+// errInvalidResetToken.
+var errInvalidSingleUseToken = errors.New("invalid single use token")
+
+// sendSingleUseToken replaces the unused tokens of purpose of the user with a
+// new one and queues the message of controller with its link.
+func sendSingleUseToken(ctx context.Context, store repository.Store, user *models.User, purpose, controller string, expiration time.Duration, link func(token string) string) error {
+	token, hash, err := auth.CreateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = store.SingleUseTokens().Use(purpose, user.ID)
+	if err != nil {
+		return err
+	}
+	record := models.SingleUseToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(expiration),
+	}
+	err = store.SingleUseTokens().Save(&record)
+	if err != nil {
+		return err
+	}
+	return store.Outbox().Save(ctx, []map[string]interface{}{{
+		"nickname":   user.Nickname,
+		"email":      user.Email,
+		"link":       link(token),
+		"expires_at": record.ExpiresAt.UTC().Format(time.RFC3339),
+	}}, controller)
+}
+
+// redeemSingleUseToken uses token and returns its owner. It fails with
+// errInvalidSingleUseToken when the token is unknown, used or expired, was
+// sent to an email the owner no longer has or the owner is deactivated.
+func redeemSingleUseToken(store repository.Store, purpose, token string) (*models.User, error) {
+	record, err := store.SingleUseTokens().FindByHash(purpose, auth.HashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errInvalidSingleUseToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !record.Usable(time.Now()) {
+		return nil, errInvalidSingleUseToken
+	}
+	user, err := store.Users().FindByID(record.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errInvalidSingleUseToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active() || user.Email != record.Email {
+		return nil, errInvalidSingleUseToken
+	}
+	return user, store.SingleUseTokens().Use(purpose, user.ID)
+}
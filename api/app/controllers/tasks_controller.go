@@ -2,60 +2,47 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/vitorbiten/maintenance/api/app/adapters"
-	"github.com/vitorbiten/maintenance/api/app/auth"
 	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
 	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/policy"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 )
 
 // CreateTask creates a task
 //
 //	@Summary		Creates a task
 //	@Description	Technicians can: create tasks
+//	@Description	Managers can: create work orders assigned to technicians
 //	@Tags			tasks
 //	@Produce		json
-//	@Param			summary			body		models.Summary	true	"task summary (max length: 2500)"
-//	@Param			date			body		models.Date		false	"task date"
+//	@Param			summary			body		models.Summary		true	"task summary (max length: 2500)"
+//	@Param			date			body		models.Date			false	"task date"
+//	@Param			assignee_ids	body		models.Assignees	false	"technicians of a work order, required for managers"
 //	@Success		200	{object}	models.Task
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		404	{object}	nil
 //	@Failure		422	{object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/tasks [post]
 func CreateTask(context *gin.Context) {
-	user := models.User{}
 	task := models.Task{}
 
+	tokenUser := middlewares.CurrentUser(context)
 	body, err := io.ReadAll(context.Request.Body)
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	uid, err := auth.ExtractTokenID(context.Request)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	tx, err := adapters.DB.Begin()
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	tokenUser, err := user.FindUserByID(tx, uid)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-	if tokenUser.UserType != enums.TECHNICIAN {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
 	err = json.Unmarshal(body, &task)
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
@@ -66,105 +53,201 @@ func CreateTask(context *gin.Context) {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	err = task.Prepare()
-	if err != nil {
-		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	// technicians author their own tasks, managers author work orders for
+	// the technicians they assign
+	if tokenUser.UserType == enums.MANAGER {
+		task.AssigneeIDs, err = validateAssignees(middlewares.Store(context), task.AssigneeIDs)
+		if err != nil {
+			context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	} else if len(task.AssigneeIDs) > 0 {
+		middlewares.Forbidden(context)
 		return
 	}
-	task.AuthorID = uid
-	taskCreated, err := task.SaveTask(tx)
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if task.AssigneeIDs == nil {
+		task.AssigneeIDs = []uint64{}
 	}
-	task.ID = uint64(taskCreated)
-	managers, err := user.FindAllManagers(tx)
+	err = task.Prepare()
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	var messages []map[string]interface{}
-	if len(*managers) > 0 {
+	task.AuthorID = tokenUser.ID
+	task.Status = enums.OPEN
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		_, err := store.Tasks().Save(&task)
+		if err != nil {
+			return err
+		}
+		if tokenUser.UserType == enums.MANAGER {
+			return saveAssignmentMessages(context, store, tokenUser, &task, task.AssigneeIDs)
+		}
+		managers, err := store.Users().FindAllManagers()
+		if err != nil {
+			return err
+		}
+		var messages []map[string]interface{}
 		for _, manager := range *managers {
 			messages = append(messages, map[string]interface{}{
 				"nickname":  tokenUser.Nickname,
-				"task_id":   strconv.Itoa(int(taskCreated)),
+				"task_id":   strconv.Itoa(int(task.ID)),
 				"task_date": task.Date,
 				"email":     manager.Email,
 			})
 		}
-		err = adapters.PublishMessages(messages, "notification")
-		if err != nil {
-			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if len(messages) == 0 {
+			return nil
 		}
-	}
-	err = tx.Commit()
+		return store.Outbox().Save(context.Request.Context(), messages, "notification")
+	})
 	if err != nil {
-		_ = tx.Rollback()
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	context.JSON(http.StatusCreated, task)
 }
 
-// GetTasks returns all existing tasks
+// GetTasks returns a page of tasks
 //
 //	@Summary		Get tasks
 //	@Description	Managers can: get all tasks
+//	@Description	Technicians can: get only the tasks they authored or are assigned to
 //	@Tags			tasks
 //	@Produce		json
-//	@Success		200	{array}		models.Task
+//	@Param			limit			query		int		false	"page size (default: 20, max: 100)"
+//	@Param			cursor			query		string	false	"next_cursor of the previous page"
+//	@Param			author_id		query		int		false	"filter by author id"
+//	@Param			assignee_id		query		int		false	"filter by assignee id"
+//	@Param			date_from		query		string	false	"minimum task date (RFC3339)"
+//	@Param			date_to			query		string	false	"maximum task date (RFC3339)"
+//	@Param			created_from	query		string	false	"minimum creation date (RFC3339)"
+//	@Param			created_to		query		string	false	"maximum creation date (RFC3339)"
+//	@Param			updated_from	query		string	false	"minimum update date (RFC3339)"
+//	@Param			updated_to		query		string	false	"maximum update date (RFC3339)"
+//	@Param			sort			query		string	false	"id (default), date, created_at or updated_at"
+//	@Param			order			query		string	false	"asc (default) or desc"
+//	@Success		200	{object}	models.TaskPage
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		404	{object}	nil
+//	@Failure		422	{object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/tasks [get]
 func GetTasks(context *gin.Context) {
-	user := models.User{}
-	task := models.Task{}
-
-	uid, err := auth.ExtractTokenID(context.Request)
+	tokenUser := middlewares.CurrentUser(context)
+	filter, err := parseTaskFilter(context)
 	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tx, err := adapters.DB.Begin()
+	if !policy.Allowed(tokenUser.UserType, policy.TASKS, policy.LIST, policy.Others, "") {
+		if filter.AuthorID != 0 && filter.AuthorID != tokenUser.ID || filter.AssigneeID != 0 && filter.AssigneeID != tokenUser.ID {
+			middlewares.Forbidden(context)
+			return
+		}
+		filter.InvolvedID = tokenUser.ID
+	}
+	page, err := middlewares.Store(context).Tasks().Find(filter)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	tokenUser, err := user.FindUserByID(tx, uid)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
+	context.JSON(http.StatusOK, page)
+}
+
+func parseTaskFilter(context *gin.Context) (*models.TaskFilter, error) {
+	filter := models.TaskFilter{
+		Cursor: context.Query("cursor"),
+		Sort:   context.Query("sort"),
+		Order:  context.Query("order"),
+	}
+	var err error
+	if limit := context.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return nil, errors.New("invalid limit")
+		}
 	}
-	if tokenUser.UserType == enums.MANAGER {
-		allTasks, err := task.FindAllTasks(adapters.DB)
+	if authorID := context.Query("author_id"); authorID != "" {
+		filter.AuthorID, err = strconv.ParseUint(authorID, 10, 64)
 		if err != nil {
-			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, errors.New("invalid author_id")
 		}
-		err = tx.Commit()
+	}
+	if assigneeID := context.Query("assignee_id"); assigneeID != "" {
+		filter.AssigneeID, err = strconv.ParseUint(assigneeID, 10, 64)
 		if err != nil {
-			_ = tx.Rollback()
-			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, errors.New("invalid assignee_id")
+		}
+	}
+	for param, field := range map[string]**time.Time{
+		"date_from":    &filter.DateFrom,
+		"date_to":      &filter.DateTo,
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+		"updated_from": &filter.UpdatedFrom,
+		"updated_to":   &filter.UpdatedTo,
+	} {
+		value := context.Query(param)
+		if value == "" {
+			continue
 		}
-		context.JSON(http.StatusOK, allTasks)
-	} else {
-		authorTasks, err := task.FindTasksByAuthorID(adapters.DB, uint64(tokenUser.ID))
+		parsed, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, fmt.Errorf("invalid %s", param)
 		}
-		err = tx.Commit()
+		*field = &parsed
+	}
+	filter.Prepare()
+	err = filter.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// SearchTasks ranks tasks by the words of their summaries
+//
+//	@Summary		Search tasks
+//	@Description	Managers can: search all tasks
+//	@Description	Technicians can: search only the tasks they authored or are assigned to
+//	@Description	Tasks with more of the query words come first, then the ones repeating them more
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			q		query		string	true	"words to search for, case and accent insensitive"
+//	@Param			limit	query		int		false	"maximum results (default: 20, max: 100)"
+//	@Success		200	{object}	models.TaskSearchResults
+//	@Failure		401	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/search [get]
+func SearchTasks(context *gin.Context) {
+	tokenUser := middlewares.CurrentUser(context)
+	search := models.TaskSearch{Query: context.Query("q")}
+	if limit := context.Query("limit"); limit != "" {
+		var err error
+		search.Limit, err = strconv.Atoi(limit)
 		if err != nil {
-			_ = tx.Rollback()
-			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid limit"})
 			return
 		}
-		context.JSON(http.StatusOK, authorTasks)
 	}
+	search.Prepare()
+	err := search.Validate()
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !policy.Allowed(tokenUser.UserType, policy.TASKS, policy.LIST, policy.Others, "") {
+		search.InvolvedID = tokenUser.ID
+	}
+	results, err := middlewares.Store(context).Tasks().Search(&search)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, results)
 }
 
 // GetTask returns a task by id
@@ -179,55 +262,19 @@ func GetTasks(context *gin.Context) {
 //	@Success		200	{object}	models.Task
 //	@Failure		400	{object}	nil
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		404	{object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/tasks/id [get]
 func GetTask(context *gin.Context) {
-	user := models.User{}
-	task := models.Task{}
-
-	pid, err := strconv.ParseUint(context.Param("id"), 10, 64)
-	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	uid, err := auth.ExtractTokenID(context.Request)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	tx, err := adapters.DB.Begin()
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	requestUser, err := user.FindUserByID(tx, uid)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-	taskReceived, err := task.FindTaskByID(adapters.DB, pid)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-	if uid != taskReceived.AuthorID && requestUser.UserType != enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	err = tx.Commit()
-	if err != nil {
-		_ = tx.Rollback()
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	context.JSON(http.StatusOK, taskReceived)
+	context.JSON(http.StatusOK, currentTask(context))
 }
 
 // UpdateTask updates a task by id
 //
 //	@Summary		Updates task by id
 //	@Description	Technicians can: update only their tasks
+//	@Description	Only the author updates a task, assignees of a work order can transition it but not update it
 //	@Tags			tasks
 //	@Accept			json
 //	@Produce		json
@@ -237,133 +284,308 @@ func GetTask(context *gin.Context) {
 //	@Success		200	{object}	models.Task
 //	@Failure		400	{object}	nil
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		422	{object}	nil
 //	@Failure		404	{object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/tasks/id [put]
 func UpdateTask(context *gin.Context) {
-	user := models.User{}
 	task := models.Task{}
 
-	tid, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	tid := context.GetUint64(middlewares.IDKey)
+	body, err := io.ReadAll(context.Request.Body)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	err = json.Unmarshal(body, &task)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	uid, err := auth.ExtractTokenID(context.Request)
+	// only the summary and the date change here, the assignees through the
+	// assignees routes and the status through transitions
+	current := currentTask(context)
+	task.ID, task.AuthorID, task.Status, task.CreatedAt = current.ID, current.AuthorID, current.Status, current.CreatedAt
+	task.AssigneeIDs = current.AssigneeIDs
+	err = task.Validate()
 	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tx, err := adapters.DB.Begin()
+	err = task.Prepare()
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tokenUser, err := user.FindUserByID(tx, uid)
+	taskUpdated, err := middlewares.Store(context).Tasks().Update(tid, &task)
 	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	taskReceived, err := task.FindTaskByID(adapters.DB, tid)
+	context.JSON(http.StatusOK, taskUpdated)
+}
+
+// DeleteTask deletes a task by id
+//
+//	@Summary		Deletes a task by id
+//	@Description	Managers can: delete all tasks
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	query		string	false	"task id"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Router			/tasks/id [delete]
+func DeleteTask(context *gin.Context) {
+	pid := context.GetUint64(middlewares.IDKey)
+	attachments, err := middlewares.Store(context).Attachments().FindByTask(pid)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if uid != taskReceived.AuthorID || tokenUser.UserType == enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	res, err := middlewares.Store(context).Tasks().Delete(pid)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if res == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
+	removeStoredFiles(context, *attachments)
+	context.Header("Entity", fmt.Sprintf("%d", pid))
+	context.JSON(http.StatusNoContent, "")
+}
+
+// TransitionTask moves a task to another status
+//
+//	@Summary		Moves a task to another status
+//	@Description	Technicians can: move their tasks forward (open, in_progress, blocked, done)
+//	@Description	Managers can: reopen or cancel tasks
+//	@Tags			tasks
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string			true	"task id"
+//	@Param			status	body		models.Status	true	"target status"
+//	@Success		200	{object}	models.Task
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		409	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/transitions [post]
+func TransitionTask(context *gin.Context) {
+	transition := models.Status{}
+
+	tokenUser := middlewares.CurrentUser(context)
+	taskReceived := currentTask(context)
 	body, err := io.ReadAll(context.Request.Body)
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	err = json.Unmarshal(body, &task)
+	err = json.Unmarshal(body, &transition)
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	err = task.Validate()
-	if err != nil {
-		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	from := taskReceived.Status
+	err = taskReceived.CanTransition(transition.Status, tokenUser)
+	if errors.Is(err, models.ErrForbiddenTransition) {
+		middlewares.Forbidden(context)
 		return
 	}
-	err = task.Prepare()
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	taskUpdated, err := task.UpdateATask(adapters.DB, tid)
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		_, err := store.Tasks().UpdateStatus(taskReceived, transition.Status)
+		if err != nil {
+			return err
+		}
+		var recipients []models.User
+		if tokenUser.UserType == enums.MANAGER {
+			for _, uid := range append([]uint64{taskReceived.AuthorID}, taskReceived.AssigneeIDs...) {
+				if uid == tokenUser.ID {
+					continue
+				}
+				recipient, err := store.Users().FindByID(uid)
+				if err != nil {
+					return err
+				}
+				recipients = append(recipients, *recipient)
+			}
+		} else {
+			managers, err := store.Users().FindAllManagers()
+			if err != nil {
+				return err
+			}
+			recipients = *managers
+		}
+		var messages []map[string]interface{}
+		for _, recipient := range recipients {
+			messages = append(messages, map[string]interface{}{
+				"nickname":    tokenUser.Nickname,
+				"task_id":     strconv.Itoa(int(taskReceived.ID)),
+				"from_status": from,
+				"to_status":   taskReceived.Status,
+				"email":       recipient.Email,
+			})
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		return store.Outbox().Save(context.Request.Context(), messages, "transition")
+	})
+	// a status that changed since the task was read is a conflict
+	if errors.Is(err, models.ErrStaleStatus) {
+		context.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
-	err = tx.Commit()
 	if err != nil {
-		_ = tx.Rollback()
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	context.JSON(http.StatusOK, taskUpdated)
+	context.JSON(http.StatusOK, taskReceived)
 }
 
-// DeleteTask deletes a task by id
+// AssignTask replaces the assignees of a task
 //
-//	@Summary		Deletes a task by id
-//	@Description	Managers can: delete all tasks
+//	@Summary		Reassigns a task to other technicians
+//	@Description	Managers can: assign any task, newly assigned technicians are notified
 //	@Tags			tasks
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	query		string	false	"task id"
-//	@Success		204	{object}	nil
+//	@Param			id				path		string				true	"task id"
+//	@Param			assignee_ids	body		models.Assignees	true	"technician ids"
+//	@Success		200	{object}	models.Task
 //	@Failure		400	{object}	nil
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		404	{object}	nil
-//	@Router			/tasks/id [delete]
-func DeleteTask(context *gin.Context) {
-	user := models.User{}
-	task := models.Task{}
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/assignees [put]
+func AssignTask(context *gin.Context) {
+	assignees := models.Assignees{}
 
-	pid, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	tokenUser := middlewares.CurrentUser(context)
+	taskReceived := currentTask(context)
+	body, err := io.ReadAll(context.Request.Body)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	uid, err := auth.ExtractTokenID(context.Request)
+	err = json.Unmarshal(body, &assignees)
 	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tx, err := adapters.DB.Begin()
+	uids, err := validateAssignees(middlewares.Store(context), assignees.AssigneeIDs)
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tokenUser, err := user.FindUserByID(tx, uid)
+	var taskUpdated *models.Task
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		added, err := store.Tasks().Assign(taskReceived.ID, uids)
+		if err != nil {
+			return err
+		}
+		taskUpdated, err = store.Tasks().FindByID(taskReceived.ID)
+		if err != nil {
+			return err
+		}
+		return saveAssignmentMessages(context, store, tokenUser, taskUpdated, added)
+	})
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	if tokenUser.UserType != enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	res, err := task.DeleteATask(adapters.DB, pid)
+	context.JSON(http.StatusOK, taskUpdated)
+}
+
+// UnassignTask removes an assignee from a task
+//
+//	@Summary		Unassigns a technician from a task
+//	@Description	Managers can: unassign technicians from any task
+//	@Tags			tasks
+//	@Produce		json
+//	@Param			id		path		string	true	"task id"
+//	@Param			user_id	path		string	true	"assignee id"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/tasks/id/assignees/user_id [delete]
+func UnassignTask(context *gin.Context) {
+	tid := context.GetUint64(middlewares.IDKey)
+	uid, err := strconv.ParseUint(context.Param("user_id"), 10, 64)
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if res == 0 {
-		context.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
-		return
-	}
-	err = tx.Commit()
+	res, err := middlewares.Store(context).Tasks().Unassign(tid, uid)
 	if err != nil {
-		_ = tx.Rollback()
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	context.Header("Entity", fmt.Sprintf("%d", pid))
+	if res == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+		return
+	}
+	context.Header("Entity", fmt.Sprintf("%d", tid))
 	context.JSON(http.StatusNoContent, "")
 }
+
+// validateAssignees drops repeated ids and checks every id is an active
+// technician.
+func validateAssignees(store repository.Store, ids []uint64) ([]uint64, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("required assignee_ids")
+	}
+	seen := map[uint64]bool{}
+	uids := []uint64{}
+	for _, uid := range ids {
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		assignee, err := store.Users().FindByID(uid)
+		if err != nil || assignee.UserType != enums.TECHNICIAN || !assignee.Active() {
+			return nil, fmt.Errorf("assignee %d is not an active technician", uid)
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// saveAssignmentMessages queues an assignment email for each of uids.
+func saveAssignmentMessages(context *gin.Context, store repository.Store, assigner *models.User, task *models.Task, uids []uint64) error {
+	var messages []map[string]interface{}
+	for _, uid := range uids {
+		assignee, err := store.Users().FindByID(uid)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, map[string]interface{}{
+			"nickname":  assigner.Nickname,
+			"task_id":   strconv.Itoa(int(task.ID)),
+			"task_date": task.Date,
+			"email":     assignee.Email,
+		})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return store.Outbox().Save(context.Request.Context(), messages, "assignment")
+}
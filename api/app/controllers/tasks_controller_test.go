@@ -9,9 +9,8 @@ import (
 	"strconv"
 	"testing"
 
-	rabbitmqAdapter "github.com/vitorbiten/maintenance/api/app/adapters"
 	"github.com/vitorbiten/maintenance/api/app/models"
-	"github.com/vitorbiten/maintenance/api/app/utils"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 	"gopkg.in/go-playground/assert.v1"
 )
 
@@ -71,36 +70,36 @@ func TestCreateTask(t *testing.T) {
 			errorMessage: "summary max length is 2500 characters",
 		},
 		{
-			// When manager token is passed
+			// When manager does not assign the task
 			inputJSON:    `{"summary": "the summary"}`,
-			statusCode:   401,
+			statusCode:   422,
 			tokenGiven:   managerTokenString,
-			errorMessage: "unauthorized",
+			errorMessage: "required assignee_ids",
+		},
+		{
+			// When technician assigns the task
+			inputJSON:    fmt.Sprintf(`{"summary": "the summary", "assignee_ids": [%d]}`, users[3].ID),
+			statusCode:   403,
+			tokenGiven:   technicianTokenString,
+			errorMessage: "forbidden",
 		},
 		{
 			// When incorrect token is passed
 			inputJSON:    `{"summary": "the summary"}`,
 			statusCode:   401,
 			tokenGiven:   "This is an incorrect token",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When no token is passed
 			inputJSON:    `{"summary": "the summary"}`,
 			statusCode:   401,
 			tokenGiven:   "",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 	}
 	for _, v := range samples {
-		var messagesSent int = 0
-		var messageController string
-
-		rabbitmqAdapter.PublishMessages = func(messages []map[string]interface{}, controller string) error {
-			messagesSent += len(messages)
-			messageController = controller
-			return nil
-		}
+		pendingBefore := PendingOutboxMessages("notification")
 
 		router := SetupRouter()
 		rr := httptest.NewRecorder()
@@ -121,10 +120,9 @@ func TestCreateTask(t *testing.T) {
 			}
 			assert.Equal(t, responseMap["summary"], v.summary)
 			assert.Equal(t, responseMap["author_id"], float64(technicianUser.ID))
-			assert.Equal(t, messagesSent, 2)
-			assert.Equal(t, messageController, "notification")
+			assert.Equal(t, PendingOutboxMessages("notification")-pendingBefore, 2)
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -145,8 +143,10 @@ func TestGetTasks(t *testing.T) {
 	technicianTokenString := fmt.Sprintf("Bearer %v", technicianToken)
 
 	samples := []struct {
+		query        string
 		statusCode   int
 		tasksLength  int
+		nextCursor   bool
 		tokenGiven   string
 		errorMessage string
 	}{
@@ -164,37 +164,86 @@ func TestGetTasks(t *testing.T) {
 			tokenGiven:   managerTokenString,
 			errorMessage: "",
 		},
+		{
+			// When manager filters by author
+			query:        fmt.Sprintf("?author_id=%d", technicianUser.ID),
+			statusCode:   200,
+			tasksLength:  1,
+			tokenGiven:   managerTokenString,
+			errorMessage: "",
+		},
+		{
+			// When the page is smaller than the result set
+			query:        "?limit=1&sort=created_at&order=desc",
+			statusCode:   200,
+			tasksLength:  1,
+			nextCursor:   true,
+			tokenGiven:   managerTokenString,
+			errorMessage: "",
+		},
+		{
+			// When technician filters by another author
+			query:        fmt.Sprintf("?author_id=%d", users[3].ID),
+			statusCode:   403,
+			tokenGiven:   technicianTokenString,
+			errorMessage: "forbidden",
+		},
+		{
+			query:        "?limit=101",
+			statusCode:   422,
+			tokenGiven:   managerTokenString,
+			errorMessage: "limit must be between 1 and 100",
+		},
+		{
+			query:        "?sort=summary",
+			statusCode:   422,
+			tokenGiven:   managerTokenString,
+			errorMessage: "invalid sort",
+		},
+		{
+			query:        "?date_from=yesterday",
+			statusCode:   422,
+			tokenGiven:   managerTokenString,
+			errorMessage: "invalid date_from",
+		},
+		{
+			query:        "?cursor=not-a-cursor",
+			statusCode:   422,
+			tokenGiven:   managerTokenString,
+			errorMessage: "invalid cursor",
+		},
 		{
 			// When incorrect token is passed
 			statusCode:   401,
 			tokenGiven:   "This is an incorrect token",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When no token is passed
 			statusCode:   401,
 			tokenGiven:   "",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 	}
 
 	for _, v := range samples {
 		router := SetupRouter()
 		rr := httptest.NewRecorder()
-		req, err := http.NewRequest("GET", "/tasks", nil)
+		req, err := http.NewRequest("GET", "/tasks"+v.query, nil)
 		OnError(err, fmt.Sprintf("Error on GET /tasks: %v", err))
 		req.Header.Set("Authorization", v.tokenGiven)
 		router.ServeHTTP(rr, req)
 
 		assert.Equal(t, rr.Code, v.statusCode)
 		if v.statusCode == 200 {
-			var tasks []models.Task
-			err = json.Unmarshal(rr.Body.Bytes(), &tasks)
+			var page models.TaskPage
+			err = json.Unmarshal(rr.Body.Bytes(), &page)
 			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
 			assert.Equal(t, rr.Code, http.StatusOK)
-			assert.Equal(t, len(tasks), v.tasksLength)
+			assert.Equal(t, len(page.Tasks), v.tasksLength)
+			assert.Equal(t, page.NextCursor != "", v.nextCursor)
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
 			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
@@ -203,6 +252,48 @@ func TestGetTasks(t *testing.T) {
 	}
 }
 
+func TestGetTasksPagination(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, tasks, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	managerTokenString := fmt.Sprintf("Bearer %v", managerToken)
+
+	for _, order := range []string{"asc", "desc"} {
+		seen := []uint64{}
+		cursor := ""
+		for {
+			router := SetupRouter()
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", fmt.Sprintf("/tasks?limit=1&order=%s&cursor=%s", order, cursor), nil)
+			OnError(err, fmt.Sprintf("Error on GET /tasks: %v", err))
+			req.Header.Set("Authorization", managerTokenString)
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, rr.Code, http.StatusOK)
+			var page models.TaskPage
+			err = json.Unmarshal(rr.Body.Bytes(), &page)
+			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+			for _, task := range page.Tasks {
+				seen = append(seen, task.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		assert.Equal(t, len(seen), len(tasks))
+		if order == "asc" {
+			assert.Equal(t, seen[0], tasks[0].ID)
+		} else {
+			assert.Equal(t, seen[0], tasks[len(tasks)-1].ID)
+		}
+	}
+}
+
 func TestFindTaskByID(t *testing.T) {
 	err := RefreshTables()
 	OnError(err, "Error refreshing users table")
@@ -211,11 +302,7 @@ func TestFindTaskByID(t *testing.T) {
 	managerUser := users[0]
 	technicianUser := users[2]
 	firstTechnicianTask := tasks[0]
-	err = utils.Decrypt(&firstTechnicianTask.Summary)
-	OnError(err, fmt.Sprintf("Cannot decrypt summary: %v", err))
 	secondTechnicianTask := tasks[1]
-	err = utils.Decrypt(&secondTechnicianTask.Summary)
-	OnError(err, fmt.Sprintf("Cannot decrypt summary: %v", err))
 	managerToken, err := SignIn(managerUser.Email, "password")
 	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
 	managerTokenString := fmt.Sprintf("Bearer %v", managerToken)
@@ -254,9 +341,9 @@ func TestFindTaskByID(t *testing.T) {
 		},
 		{
 			id:           strconv.Itoa(int(secondTechnicianTask.ID)),
-			statusCode:   401,
+			statusCode:   403,
 			tokenGiven:   technicianTokenString,
-			errorMessage: "unauthorized",
+			errorMessage: "forbidden",
 		},
 		{
 			// When incorrect id is passed
@@ -270,17 +357,18 @@ func TestFindTaskByID(t *testing.T) {
 			id:           strconv.Itoa(int(secondTechnicianTask.ID)),
 			statusCode:   401,
 			tokenGiven:   "This is an incorrect token",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When no token is passed
 			id:           strconv.Itoa(int(secondTechnicianTask.ID)),
 			statusCode:   401,
 			tokenGiven:   "",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			id:         "unknwon",
+			tokenGiven: technicianTokenString,
 			statusCode: 400,
 		},
 	}
@@ -301,7 +389,7 @@ func TestFindTaskByID(t *testing.T) {
 			assert.Equal(t, responseMap["summary"], v.summary)
 			assert.Equal(t, responseMap["author_id"], float64(v.author_id))
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -345,8 +433,8 @@ func TestUpdateTask(t *testing.T) {
 			id:           strconv.Itoa(int(secondTechnicianTask.ID)),
 			updateJSON:   `{"summary": "This is the updated summary"}`,
 			tokenGiven:   technicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			id:           strconv.Itoa(int(firstTechnicianTask.ID)),
@@ -359,7 +447,7 @@ func TestUpdateTask(t *testing.T) {
 			id:           strconv.Itoa(int(999)),
 			updateJSON:   `{"summary": ""}`,
 			tokenGiven:   technicianTokenString,
-			statusCode:   500,
+			statusCode:   404,
 			errorMessage: "task not found",
 		},
 		{
@@ -367,8 +455,8 @@ func TestUpdateTask(t *testing.T) {
 			id:           strconv.Itoa(int(firstTechnicianTask.ID)),
 			updateJSON:   `{"summary": "This is the updated summary"}`,
 			tokenGiven:   managerTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When no token is provided
@@ -376,7 +464,7 @@ func TestUpdateTask(t *testing.T) {
 			updateJSON:   `{"summary": "This is the updated summary"}`,
 			tokenGiven:   "",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When incorrect token is provided
@@ -384,10 +472,11 @@ func TestUpdateTask(t *testing.T) {
 			updateJSON:   `{"summary": "This is the updated summary"}`,
 			tokenGiven:   "this is an incorrect token",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			id:         "unknwon",
+			tokenGiven: technicianTokenString,
 			statusCode: 400,
 		},
 	}
@@ -408,7 +497,7 @@ func TestUpdateTask(t *testing.T) {
 			assert.Equal(t, responseMap["summary"], v.summary)
 			assert.Equal(t, responseMap["author_id"], float64(v.author_id))
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -458,25 +547,26 @@ func TestDeleteTask(t *testing.T) {
 			// When technician token is provided
 			id:           strconv.Itoa(int(firstTechnicianTask.ID)),
 			tokenGiven:   technicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When no token is provided
 			id:           strconv.Itoa(int(firstTechnicianTask.ID)),
 			tokenGiven:   "",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When incorrect token is provided
 			id:           strconv.Itoa(int(firstTechnicianTask.ID)),
 			tokenGiven:   "this is an incorrect token",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			id:         "unknwon",
+			tokenGiven: technicianTokenString,
 			statusCode: 400,
 		},
 	}
@@ -490,7 +580,7 @@ func TestDeleteTask(t *testing.T) {
 		router.ServeHTTP(rr, req)
 
 		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 401 && v.errorMessage != "" {
+		if (v.statusCode == 401 || v.statusCode == 403) && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
 			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
@@ -498,3 +588,422 @@ func TestDeleteTask(t *testing.T) {
 		}
 	}
 }
+
+func TestTransitionTask(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, tasks, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	technicianUser := users[2]
+	firstTechnicianTask := tasks[0]
+	secondTechnicianTask := tasks[1]
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	managerTokenString := fmt.Sprintf("Bearer %v", managerToken)
+	technicianToken, err := SignIn(technicianUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as technician: %v\n", err))
+	technicianTokenString := fmt.Sprintf("Bearer %v", technicianToken)
+
+	samples := []struct {
+		id             string
+		transitionJSON string
+		tokenGiven     string
+		statusCode     int
+		status         string
+		messages       int
+		errorMessage   string
+	}{
+		{
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "in_progress"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     200,
+			status:         "in_progress",
+			messages:       2,
+		},
+		{
+			// When skipping straight back to open
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "open"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     422,
+			errorMessage:   "invalid status transition",
+		},
+		{
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "done"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     200,
+			status:         "done",
+			messages:       2,
+		},
+		{
+			// When technician tries to reopen
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "open"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     403,
+			errorMessage:   "forbidden",
+		},
+		{
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "open"}`,
+			tokenGiven:     managerTokenString,
+			statusCode:     200,
+			status:         "open",
+			messages:       1,
+		},
+		{
+			// When manager tries to move the task forward
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "in_progress"}`,
+			tokenGiven:     managerTokenString,
+			statusCode:     403,
+			errorMessage:   "forbidden",
+		},
+		{
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "cancelled"}`,
+			tokenGiven:     managerTokenString,
+			statusCode:     200,
+			status:         "cancelled",
+			messages:       1,
+		},
+		{
+			// When technician moves another technician's task
+			id:             strconv.Itoa(int(secondTechnicianTask.ID)),
+			transitionJSON: `{"status": "in_progress"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     403,
+			errorMessage:   "forbidden",
+		},
+		{
+			id:             strconv.Itoa(int(secondTechnicianTask.ID)),
+			transitionJSON: `{"status": "archived"}`,
+			tokenGiven:     managerTokenString,
+			statusCode:     422,
+			errorMessage:   "invalid status",
+		},
+		{
+			id:             strconv.Itoa(999),
+			transitionJSON: `{"status": "in_progress"}`,
+			tokenGiven:     technicianTokenString,
+			statusCode:     404,
+			errorMessage:   "task not found",
+		},
+		{
+			// When no token is provided
+			id:             strconv.Itoa(int(firstTechnicianTask.ID)),
+			transitionJSON: `{"status": "in_progress"}`,
+			tokenGiven:     "",
+			statusCode:     401,
+			errorMessage:   "authentication required",
+		},
+		{
+			id:         "unknwon",
+			tokenGiven: technicianTokenString,
+			statusCode: 400,
+		},
+	}
+
+	for _, v := range samples {
+		pendingBefore := PendingOutboxMessages("transition")
+
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/tasks/"+v.id+"/transitions", bytes.NewBufferString(v.transitionJSON))
+		OnError(err, fmt.Sprintf("Error on POST /tasks/id/transitions: %v", err))
+		req.Header.Set("Authorization", v.tokenGiven)
+		router.ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			assert.Equal(t, responseMap["status"], v.status)
+			assert.Equal(t, PendingOutboxMessages("transition")-pendingBefore, v.messages)
+		}
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 404 || v.statusCode == 422 && v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestAssignTask(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, _, err := SeedUsersAndTasks()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	secondManagerUser := users[1]
+	technicianUser := users[2]
+	secondTechnicianUser := users[3]
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	managerTokenString := fmt.Sprintf("Bearer %v", managerToken)
+	technicianToken, err := SignIn(technicianUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as technician: %v\n", err))
+	technicianTokenString := fmt.Sprintf("Bearer %v", technicianToken)
+
+	// a work order for the first technician
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/tasks", bytes.NewBufferString(fmt.Sprintf(`{"summary": "Fix the elevator", "assignee_ids": [%d, %d]}`, technicianUser.ID, technicianUser.ID)))
+	OnError(err, fmt.Sprintf("Error on POST /tasks: %v", err))
+	req.Header.Set("Authorization", managerTokenString)
+	SetupRouter().ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 201)
+	workOrder := models.Task{}
+	err = json.Unmarshal(rr.Body.Bytes(), &workOrder)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	assert.Equal(t, workOrder.AssigneeIDs, []uint64{technicianUser.ID})
+	assert.Equal(t, PendingOutboxMessages("assignment"), 1)
+
+	// the assignee sees and works on it, other technicians don't
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", fmt.Sprintf("/tasks?assignee_id=%d", technicianUser.ID), nil)
+	OnError(err, fmt.Sprintf("Error on GET /tasks: %v", err))
+	req.Header.Set("Authorization", technicianTokenString)
+	SetupRouter().ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 200)
+	page := models.TaskPage{}
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	assert.Equal(t, len(page.Tasks), 1)
+	assert.Equal(t, page.Tasks[0].ID, workOrder.ID)
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", fmt.Sprintf("/tasks/%d/transitions", workOrder.ID), bytes.NewBufferString(`{"status": "in_progress"}`))
+	OnError(err, fmt.Sprintf("Error on POST /tasks/id/transitions: %v", err))
+	req.Header.Set("Authorization", technicianTokenString)
+	SetupRouter().ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 200)
+
+	workOrderID := strconv.Itoa(int(workOrder.ID))
+	samples := []struct {
+		method       string
+		path         string
+		inputJSON    string
+		tokenGiven   string
+		statusCode   int
+		assignees    []uint64
+		messages     int
+		errorMessage string
+	}{
+		{
+			method:     "PUT",
+			path:       "/tasks/" + workOrderID + "/assignees",
+			inputJSON:  fmt.Sprintf(`{"assignee_ids": [%d, %d]}`, technicianUser.ID, secondTechnicianUser.ID),
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			assignees:  []uint64{technicianUser.ID, secondTechnicianUser.ID},
+			messages:   1,
+		},
+		{
+			// When a manager is assigned
+			method:       "PUT",
+			path:         "/tasks/" + workOrderID + "/assignees",
+			inputJSON:    fmt.Sprintf(`{"assignee_ids": [%d]}`, secondManagerUser.ID),
+			tokenGiven:   managerTokenString,
+			statusCode:   422,
+			errorMessage: fmt.Sprintf("assignee %d is not an active technician", secondManagerUser.ID),
+		},
+		{
+			method:       "PUT",
+			path:         "/tasks/" + workOrderID + "/assignees",
+			inputJSON:    `{"assignee_ids": []}`,
+			tokenGiven:   managerTokenString,
+			statusCode:   422,
+			errorMessage: "required assignee_ids",
+		},
+		{
+			method:       "PUT",
+			path:         "/tasks/" + workOrderID + "/assignees",
+			inputJSON:    fmt.Sprintf(`{"assignee_ids": [%d]}`, secondTechnicianUser.ID),
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:     "DELETE",
+			path:       fmt.Sprintf("/tasks/%s/assignees/%d", workOrderID, technicianUser.ID),
+			tokenGiven: managerTokenString,
+			statusCode: 204,
+		},
+		{
+			method:       "DELETE",
+			path:         fmt.Sprintf("/tasks/%s/assignees/%d", workOrderID, technicianUser.ID),
+			tokenGiven:   managerTokenString,
+			statusCode:   404,
+			errorMessage: "assignment not found",
+		},
+		{
+			// When the unassigned technician reads the work order
+			method:       "GET",
+			path:         "/tasks/" + workOrderID,
+			tokenGiven:   technicianTokenString,
+			statusCode:   403,
+			errorMessage: "forbidden",
+		},
+		{
+			method:       "PUT",
+			path:         "/tasks/999/assignees",
+			inputJSON:    fmt.Sprintf(`{"assignee_ids": [%d]}`, technicianUser.ID),
+			tokenGiven:   managerTokenString,
+			statusCode:   404,
+			errorMessage: "task not found",
+		},
+		{
+			method:     "DELETE",
+			path:       "/tasks/" + workOrderID + "/assignees/unknown",
+			tokenGiven: managerTokenString,
+			statusCode: 400,
+		},
+	}
+
+	for _, v := range samples {
+		pendingBefore := PendingOutboxMessages("assignment")
+
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(v.method, v.path, bytes.NewBufferString(v.inputJSON))
+		OnError(err, fmt.Sprintf("Error on %s %s: %v", v.method, v.path, err))
+		req.Header.Set("Authorization", v.tokenGiven)
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 204 {
+			continue
+		}
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		if v.statusCode == 200 {
+			task := models.Task{}
+			err = json.Unmarshal(rr.Body.Bytes(), &task)
+			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+			assert.Equal(t, task.AssigneeIDs, v.assignees)
+			assert.Equal(t, PendingOutboxMessages("assignment")-pendingBefore, v.messages)
+		}
+		if v.statusCode == 403 || v.statusCode == 404 || v.statusCode == 422 && v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	technicianUser := users[2]
+	var ids []uint64
+	for _, task := range []models.Task{
+		{Summary: "Fix the boiler, the boiler leaks", AuthorID: technicianUser.ID},
+		{Summary: "Boiler pipe replaced", AuthorID: users[3].ID},
+	} {
+		err = task.Prepare()
+		OnError(err, fmt.Sprintf("Cannot prepare task: %v\n", err))
+		saved, err := repository.Default.Tasks().Save(&task)
+		OnError(err, fmt.Sprintf("Cannot save task: %v\n", err))
+		ids = append(ids, saved.ID)
+	}
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	managerTokenString := fmt.Sprintf("Bearer %v", managerToken)
+	technicianToken, err := SignIn(technicianUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as technician: %v\n", err))
+	technicianTokenString := fmt.Sprintf("Bearer %v", technicianToken)
+
+	samples := []struct {
+		query        string
+		tokenGiven   string
+		statusCode   int
+		ids          []uint64
+		errorMessage string
+	}{
+		{
+			query:      "q=BOILER",
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			ids:        []uint64{ids[0], ids[1]},
+		},
+		{
+			query:      "q=boiler+pipe",
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			ids:        []uint64{ids[1], ids[0]},
+		},
+		{
+			query:      "q=boiler&limit=1",
+			tokenGiven: managerTokenString,
+			statusCode: 200,
+			ids:        []uint64{ids[0]},
+		},
+		{
+			// When technician searches other technicians' tasks
+			query:      "q=pipe",
+			tokenGiven: technicianTokenString,
+			statusCode: 200,
+			ids:        []uint64{},
+		},
+		{
+			query:      "q=boiler",
+			tokenGiven: technicianTokenString,
+			statusCode: 200,
+			ids:        []uint64{ids[0]},
+		},
+		{
+			query:        "",
+			tokenGiven:   managerTokenString,
+			statusCode:   422,
+			errorMessage: "required q",
+		},
+		{
+			query:        "q=a",
+			tokenGiven:   managerTokenString,
+			statusCode:   422,
+			errorMessage: "q needs a word with at least 2 letters",
+		},
+		{
+			query:        "q=boiler&limit=abc",
+			tokenGiven:   managerTokenString,
+			statusCode:   422,
+			errorMessage: "invalid limit",
+		},
+		{
+			query:        "q=boiler",
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "authentication required",
+		},
+	}
+
+	for _, v := range samples {
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/tasks/search?"+v.query, nil)
+		OnError(err, fmt.Sprintf("Error on GET /tasks/search: %v", err))
+		req.Header.Set("Authorization", v.tokenGiven)
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			results := models.TaskSearchResults{}
+			err = json.Unmarshal(rr.Body.Bytes(), &results)
+			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+			found := []uint64{}
+			for _, task := range results.Tasks {
+				found = append(found, task.ID)
+			}
+			assert.Equal(t, found, v.ids)
+			continue
+		}
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		assert.Equal(t, responseMap["error"], v.errorMessage)
+	}
+}
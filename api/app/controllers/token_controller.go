@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReuse   = errors.New("refresh token reuse detected")
+	errRefreshTokenExpired = errors.New("refresh token expired")
+)
+
+// RefreshToken rotates a refresh token
+//
+//	@Summary		Exchanges a refresh token for a new token pair
+//	@Description	The refresh token is single use, reusing it revokes every token of its family
+//	@Tags			login
+//	@Produce		json
+//	@Param			refresh_token	body		models.RefreshTokenBody	true	"refresh token"
+//	@Success		200	{object}	models.TokenPair
+//	@Failure		401	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/token/refresh [post]
+func RefreshToken(context *gin.Context) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	request := models.RefreshTokenBody{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if request.RefreshToken == "" {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "required refresh token"})
+		return
+	}
+	store := middlewares.Store(context)
+	var tokens *models.TokenPair
+	var reused string
+	err = store.Transaction(func(store repository.Store) error {
+		refreshToken, err := store.Tokens().FindRefreshToken(auth.HashToken(request.RefreshToken))
+		if errors.Is(err, repository.ErrNotFound) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		if refreshToken.RevokedAt.Valid {
+			reused = refreshToken.FamilyID
+			return errRefreshTokenReuse
+		}
+		if time.Now().After(refreshToken.ExpiresAt) {
+			return errRefreshTokenExpired
+		}
+		user, err := store.Users().FindByID(refreshToken.UserID)
+		if err != nil || !user.Active() {
+			return errDeactivated
+		}
+		err = store.Tokens().RevokeRefreshToken(refreshToken.ID)
+		if err != nil {
+			return err
+		}
+		tokens, err = issueTokenPair(store, refreshToken.UserID, refreshToken.FamilyID)
+		return err
+	})
+	// the family is revoked after the rollback so the revocation sticks
+	if err == errRefreshTokenReuse {
+		revokeErr := store.Tokens().RevokeFamily(reused)
+		if revokeErr != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": revokeErr.Error()})
+			return
+		}
+	}
+	switch err {
+	case nil:
+	case errInvalidRefreshToken, errRefreshTokenReuse, errRefreshTokenExpired, errDeactivated:
+		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	default:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the current tokens
+//
+//	@Summary		Revokes the access token and its refresh token family
+//	@Tags			login
+//	@Produce		json
+//	@Param			refresh_token	body		models.RefreshTokenBody	false	"refresh token"
+//	@Success		204	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/logout [post]
+func Logout(context *gin.Context) {
+	metadata := middlewares.CurrentToken(context)
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	request := models.RefreshTokenBody{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &request)
+		if err != nil {
+			context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	store := middlewares.Store(context)
+	if request.RefreshToken != "" {
+		refreshToken, err := store.Tokens().FindRefreshToken(auth.HashToken(request.RefreshToken))
+		if err == nil && refreshToken.UserID == metadata.UserID {
+			err = store.Tokens().RevokeFamily(refreshToken.FamilyID)
+			if err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	err = store.Tokens().Revoke(metadata.JTI, metadata.ExpiresAt)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusNoContent, "")
+}
+
+// JWKS publishes the token verification keys
+//
+//	@Summary		Public keys access tokens are signed with
+//	@Description	Keys are matched to tokens by their kid header, a rotated key stays listed until it retires
+//	@Tags			login
+//	@Produce		json
+//	@Success		200	{object}	auth.JWKS
+//	@Failure		500	{object}	nil
+//	@Router			/.well-known/jwks.json [get]
+func JWKS(context *gin.Context) {
+	keys, err := auth.CurrentKeySet()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.Header("Cache-Control", "public, max-age=300")
+	context.JSON(http.StatusOK, keys.JWKS())
+}
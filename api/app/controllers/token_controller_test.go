@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func login(email, password string) models.TokenPair {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)))
+	OnError(err, fmt.Sprintf("Error on POST /login: %v", err))
+	router.ServeHTTP(rr, req)
+	tokens := models.TokenPair{}
+	err = json.Unmarshal(rr.Body.Bytes(), &tokens)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	return tokens
+}
+
+func refresh(refreshToken string) (int, map[string]interface{}) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)))
+	OnError(err, fmt.Sprintf("Error on POST /token/refresh: %v", err))
+	router.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	return rr.Code, responseMap
+}
+
+func TestRefreshToken(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	user, err := SeedOneUser()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+
+	tokens := login(user.Email, "password")
+	assert.NotEqual(t, tokens.AccessToken, "")
+	assert.NotEqual(t, tokens.RefreshToken, "")
+
+	statusCode, responseMap := refresh(tokens.RefreshToken)
+	assert.Equal(t, statusCode, 200)
+	rotatedToken := responseMap["refresh_token"].(string)
+	assert.NotEqual(t, rotatedToken, tokens.RefreshToken)
+	assert.NotEqual(t, responseMap["access_token"], "")
+
+	// Reusing the rotated out token revokes the whole family
+	statusCode, responseMap = refresh(tokens.RefreshToken)
+	assert.Equal(t, statusCode, 401)
+	assert.Equal(t, responseMap["error"], "refresh token reuse detected")
+
+	statusCode, responseMap = refresh(rotatedToken)
+	assert.Equal(t, statusCode, 401)
+	assert.Equal(t, responseMap["error"], "refresh token reuse detected")
+
+	statusCode, responseMap = refresh("unknown token")
+	assert.Equal(t, statusCode, 401)
+	assert.Equal(t, responseMap["error"], "invalid refresh token")
+
+	statusCode, responseMap = refresh("")
+	assert.Equal(t, statusCode, 422)
+	assert.Equal(t, responseMap["error"], "required refresh token")
+}
+
+func TestLogout(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	user, err := SeedOneUser()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+
+	tokens := login(user.Email, "password")
+	tokenString := fmt.Sprintf("Bearer %v", tokens.AccessToken)
+
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/logout", bytes.NewBufferString(fmt.Sprintf(`{"refresh_token": "%s"}`, tokens.RefreshToken)))
+	OnError(err, fmt.Sprintf("Error on POST /logout: %v", err))
+	req.Header.Set("Authorization", tokenString)
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 204)
+
+	// The access token is revoked
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/tasks", nil)
+	OnError(err, fmt.Sprintf("Error on GET /tasks: %v", err))
+	req.Header.Set("Authorization", tokenString)
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 401)
+	responseMap := make(map[string]interface{})
+	err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	assert.Equal(t, responseMap["error"], "authentication required")
+
+	// And so is the refresh token family
+	statusCode, _ := refresh(tokens.RefreshToken)
+	assert.Equal(t, statusCode, 401)
+
+	// When no token is passed
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/logout", nil)
+	OnError(err, fmt.Sprintf("Error on POST /logout: %v", err))
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 401)
+}
+
+func TestJWKS(t *testing.T) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	OnError(err, fmt.Sprintf("Error on GET /.well-known/jwks.json: %v", err))
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	jwks := auth.JWKS{}
+	err = json.Unmarshal(rr.Body.Bytes(), &jwks)
+	OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	keys, err := auth.CurrentKeySet()
+	OnError(err, fmt.Sprintf("Cannot load signing keys: %v", err))
+	assert.NotEqual(t, len(jwks.Keys), 0)
+	assert.Equal(t, jwks.Keys[0].ID, keys.Active().ID)
+	assert.Equal(t, jwks.Keys[0].Use, "sig")
+	assert.Equal(t, jwks.Keys[0].Alg, keys.Active().Method.Alg())
+}
@@ -2,22 +2,23 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/vitorbiten/maintenance/api/app/adapters"
 	"github.com/vitorbiten/maintenance/api/app/auth"
-	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
 	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
 )
 
 // CreateUser creates a user
 //
 //	@Summary		Creates a user
 //	@Description	Technicians can: create users
+//	@Description	The account starts unverified and a link to verify its email is sent to it, tasks can only be created once it is verified
 //	@Tags			users
 //	@Produce		json
 //	@Param			nickname	body		models.Nickname	true	"user nickname"
@@ -46,7 +47,15 @@ func CreateUser(context *gin.Context) {
 		return
 	}
 	user.Prepare()
-	userCreated, err := user.SaveUser(adapters.DB)
+	var userCreated *models.User
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		var err error
+		userCreated, err = store.Users().Save(&user)
+		if err != nil {
+			return err
+		}
+		return sendVerification(context.Request.Context(), store, userCreated)
+	})
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "incorrect details"})
 		return
@@ -63,41 +72,15 @@ func CreateUser(context *gin.Context) {
 //	@Success		200	{array}		models.User
 //	@Failure		400	{object}	nil
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/users [get]
 func GetUsers(context *gin.Context) {
-	user := models.User{}
-
-	uid, err := auth.ExtractTokenID(context.Request)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	tx, err := adapters.DB.Begin()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	tokenUser, err := user.FindUserByID(tx, uid)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	if tokenUser.UserType != enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	users, err := user.FindAllTechnicians(adapters.DB)
+	users, err := middlewares.Store(context).Users().FindAllTechnicians()
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	err = tx.Commit()
-	if err != nil {
-		_ = tx.Rollback()
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 	context.JSON(http.StatusOK, users)
 }
 
@@ -112,58 +95,19 @@ func GetUsers(context *gin.Context) {
 //	@Success		200	{object}	models.User
 //	@Failure		400 {object}	nil
 //	@Failure		401 {object}	nil
+//	@Failure		403 {object}	nil
 //	@Failure		404 {object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/users/id [get]
 func GetUser(context *gin.Context) {
-	uid, err := strconv.ParseUint(context.Param("id"), 10, 64)
-	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	tokenID, err := auth.ExtractTokenID(context.Request)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	tx, err := adapters.DB.Begin()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	requestedUser := &models.User{}
-	requestedUser, err = requestedUser.FindUserByID(tx, uid)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-	tokenUser := &models.User{}
-	tokenUser, err = tokenUser.FindUserByID(tx, tokenID)
-	if err != nil {
-		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		return
-	}
-	if tokenID != uid && tokenUser.UserType != enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	if tokenID != uid && requestedUser.UserType == enums.MANAGER {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	err = tx.Commit()
-	if err != nil {
-		_ = tx.Rollback()
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	context.JSON(http.StatusOK, requestedUser)
+	context.JSON(http.StatusOK, requestedUser(context))
 }
 
 // UpdateUser updates an user
 //
 //	@Summary		Updates an user by id
 //	@Description	Technicians can: update their tasks
+//	@Description	A new email starts unverified and a link to verify it is sent to it
 //	@Tags			users
 //	@Produce		json
 //	@Param			id	path		string	true	"user id"
@@ -173,14 +117,11 @@ func GetUser(context *gin.Context) {
 //	@Success		200	{object}	models.User
 //	@Failure		400 {object}	nil
 //	@Failure		401 {object}	nil
+//	@Failure		403 {object}	nil
 //	@Failure		500	{object}	nil
 //	@Router			/users/id [put]
 func UpdateUser(context *gin.Context) {
-	uid, err := strconv.ParseUint(context.Param("id"), 10, 64)
-	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	uid := context.GetUint64(middlewares.IDKey)
 	body, err := io.ReadAll(context.Request.Body)
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
@@ -192,24 +133,34 @@ func UpdateUser(context *gin.Context) {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(context.Request)
-	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-	if tokenID != uid {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
 	err = user.Validate("update")
 	if err != nil {
 		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
 	user.Prepare()
-	updatedUser, err := user.UpdateAUser(adapters.DB, uid)
+	var updatedUser *models.User
+	err = middlewares.Store(context).Transaction(func(store repository.Store) error {
+		previous, err := store.Users().FindByID(uid)
+		if err != nil {
+			return err
+		}
+		_, err = store.Users().Update(uid, &user)
+		if err != nil {
+			return err
+		}
+		updatedUser, err = store.Users().FindByID(uid)
+		if err != nil {
+			return err
+		}
+		// a new email has to be verified again, a link is sent to it
+		if updatedUser.Email != previous.Email && !updatedUser.Verified() {
+			return sendVerification(context.Request.Context(), store, updatedUser)
+		}
+		return nil
+	})
 	if err != nil {
-		if err.Error() == "user not found" {
+		if errors.Is(err, repository.ErrNotFound) {
 			context.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			return
 		}
@@ -231,33 +182,142 @@ func UpdateUser(context *gin.Context) {
 //	@Success		204	{object}	nil
 //	@Failure		400	{object}	nil
 //	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
 //	@Router			/users/id [delete]
 func DeleteUser(context *gin.Context) {
-	user := models.User{}
-
-	uid, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	uid := context.GetUint64(middlewares.IDKey)
+	res, err := middlewares.Store(context).Users().Delete(uid)
 	if err != nil {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(context.Request)
+	if res == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	context.Header("Entity", fmt.Sprintf("%d", uid))
+	context.JSON(http.StatusNoContent, "")
+}
+
+// UpdateUserRole promotes or demotes a user
+//
+//	@Summary		Changes the role of a user by id
+//	@Description	Managers can: make other users managers or technicians
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		string		true	"user id"
+//	@Param			user_type	body		models.Role	true	"manager or technician"
+//	@Success		200	{object}	models.User
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/users/id/role [put]
+func UpdateUserRole(context *gin.Context) {
+	uid := context.GetUint64(middlewares.IDKey)
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	user := models.User{}
+	err = json.Unmarshal(body, &user)
 	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	if tokenID != uid {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	err = user.Validate("role")
+	if err != nil {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
-	res, err := user.DeleteAUser(adapters.DB, uid)
+	updatedUser, err := middlewares.Store(context).Users().UpdateRole(uid, user.UserType)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if res == 0 {
-		context.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	context.JSON(http.StatusOK, updatedUser)
+}
+
+// DeactivateUser deactivates a user
+//
+//	@Summary		Deactivates a user by id
+//	@Description	Managers can: deactivate other users, they cannot log in and their tokens stop working
+//	@Tags			users
+//	@Produce		json
+//	@Param			id	path		string	true	"user id"
+//	@Success		200	{object}	models.User
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/users/id/deactivate [post]
+func DeactivateUser(context *gin.Context) {
+	uid := context.GetUint64(middlewares.IDKey)
+	var deactivatedUser *models.User
+	err := middlewares.Store(context).Transaction(func(store repository.Store) error {
+		var err error
+		deactivatedUser, err = store.Users().Deactivate(uid)
+		if err != nil {
+			return err
+		}
+		return store.Tokens().RevokeUser(uid)
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	context.Header("Entity", fmt.Sprintf("%d", uid))
-	context.JSON(http.StatusNoContent, "")
+	context.JSON(http.StatusOK, deactivatedUser)
+}
+
+// UnlockUser clears the failed logins of a user
+//
+//	@Summary		Unlocks the login of a user by id
+//	@Description	Managers can: unlock other users locked out by failed logins, their failures are forgotten
+//	@Tags			users
+//	@Produce		json
+//	@Param			id	path		string	true	"user id"
+//	@Success		200	{object}	models.User
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/users/id/unlock [post]
+func UnlockUser(context *gin.Context) {
+	user := requestedUser(context)
+	err := middlewares.Store(context).LoginAttempts().Delete(auth.AccountSubject(user.Email))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, user)
+}
+
+// ReactivateUser reactivates a user
+//
+//	@Summary		Reactivates a user by id
+//	@Description	Managers can: reactivate other users, they have to log in again
+//	@Tags			users
+//	@Produce		json
+//	@Param			id	path		string	true	"user id"
+//	@Success		200	{object}	models.User
+//	@Failure		400	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		403	{object}	nil
+//	@Failure		404	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/users/id/reactivate [post]
+func ReactivateUser(context *gin.Context) {
+	uid := context.GetUint64(middlewares.IDKey)
+	reactivatedUser, err := middlewares.Store(context).Users().Reactivate(uid)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, reactivatedUser)
 }
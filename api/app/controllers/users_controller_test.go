@@ -138,20 +138,20 @@ func TestGetUsers(t *testing.T) {
 		{
 			// When technician token is given
 			tokenGiven:   technicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When incorrect token is given
 			tokenGiven:   "This is an incorrect token",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When no token is given
 			tokenGiven:   "",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 	}
 
@@ -173,7 +173,7 @@ func TestGetUsers(t *testing.T) {
 			assert.Equal(t, users[1].ID, v.response[1].ID)
 			assert.Equal(t, users[2].ID, v.response[2].ID)
 		}
-		if v.statusCode == 401 && v.errorMessage != "" {
+		if (v.statusCode == 401 || v.statusCode == 403) && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
 			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
@@ -243,22 +243,22 @@ func TestGetUserByID(t *testing.T) {
 			// When manager tries to get another manager user
 			id:           strconv.Itoa(int(secondManagerUser.ID)),
 			tokenGiven:   ManagerTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When technician tries to get manager user
 			id:           strconv.Itoa(int(managerUser.ID)),
 			tokenGiven:   TechnicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When incorrect token is given
 			id:           strconv.Itoa(int(technicianUser.ID)),
 			tokenGiven:   "This is an incorrect token",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 	}
 	for _, v := range sample {
@@ -277,7 +277,7 @@ func TestGetUserByID(t *testing.T) {
 			assert.Equal(t, responseMap["nickname"], v.nickname)
 			assert.Equal(t, responseMap["email"], v.email)
 		}
-		if v.statusCode == 401 || v.statusCode == 404 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 404 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -379,16 +379,16 @@ func TestUpdateUser(t *testing.T) {
 			id:           strconv.Itoa(int(technicianUser.ID)),
 			updateJSON:   `{"nickname": "Mike", "email": "mike@gmail.com", "password": "password"}`,
 			tokenGiven:   ManagerTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When technician tries to update a manager
 			id:           strconv.Itoa(int(managerUser.ID)),
 			updateJSON:   `{"nickname": "Mike", "email": "mike@gmail.com", "password": "password"}`,
 			tokenGiven:   TechnicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When no token was passed
@@ -396,7 +396,7 @@ func TestUpdateUser(t *testing.T) {
 			updateJSON:   `{"nickname":"Man", "email": "man@gmail.com", "password": "password"}`,
 			statusCode:   401,
 			tokenGiven:   "",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When incorrect token is given
@@ -404,7 +404,7 @@ func TestUpdateUser(t *testing.T) {
 			updateJSON:   `{"nickname":"Woman", "email": "woman@gmail.com", "password": "password"}`,
 			statusCode:   401,
 			tokenGiven:   "This is incorrect token",
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			id:         "unknwon",
@@ -429,7 +429,7 @@ func TestUpdateUser(t *testing.T) {
 			assert.Equal(t, responseMap["nickname"], v.updateNickname)
 			assert.Equal(t, responseMap["email"], v.updateEmail)
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -462,36 +462,36 @@ func TestDeleteUser(t *testing.T) {
 			errorMessage: "",
 		},
 		{
-			// When manager tries to delete a technician
+			// When the deleted manager tries to delete a technician
 			id:           strconv.Itoa(int(technicianUser.ID)),
 			tokenGiven:   ManagerTokenString,
 			statusCode:   401,
-			errorMessage: "unauthorized",
+			errorMessage: "authentication required",
 		},
 		{
 			// When technician tries to update a manager
 			id:           strconv.Itoa(int(managerUser.ID)),
 			tokenGiven:   TechnicianTokenString,
-			statusCode:   401,
-			errorMessage: "unauthorized",
+			statusCode:   403,
+			errorMessage: "forbidden",
 		},
 		{
 			// When no token is given
 			id:           strconv.Itoa(int(managerUser.ID)),
 			tokenGiven:   "",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			// When incorrect token is given
 			id:           strconv.Itoa(int(managerUser.ID)),
 			tokenGiven:   "This is an incorrect token",
 			statusCode:   401,
-			errorMessage: "token contains an invalid number of segments",
+			errorMessage: "authentication required",
 		},
 		{
 			id:         "unknwon",
-			tokenGiven: ManagerTokenString,
+			tokenGiven: TechnicianTokenString,
 			statusCode: 400,
 		},
 	}
@@ -505,7 +505,7 @@ func TestDeleteUser(t *testing.T) {
 		router.ServeHTTP(rr, req)
 
 		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 401 && v.errorMessage != "" {
+		if (v.statusCode == 401 || v.statusCode == 403) && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
 			OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
@@ -513,3 +513,134 @@ func TestDeleteUser(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateUserRole(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	technicianUser := users[2]
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	ManagerTokenString := fmt.Sprintf("Bearer %v", managerToken)
+
+	samples := []struct {
+		id           string
+		inputJSON    string
+		statusCode   int
+		userType     string
+		errorMessage string
+	}{
+		{
+			id:         strconv.Itoa(int(technicianUser.ID)),
+			inputJSON:  `{"user_type": "manager"}`,
+			statusCode: 200,
+			userType:   "manager",
+		},
+		{
+			id:         strconv.Itoa(int(technicianUser.ID)),
+			inputJSON:  `{"user_type": "technician"}`,
+			statusCode: 200,
+			userType:   "technician",
+		},
+		{
+			id:           strconv.Itoa(int(technicianUser.ID)),
+			inputJSON:    `{"user_type": "admin"}`,
+			statusCode:   422,
+			errorMessage: "invalid user type",
+		},
+		{
+			id:           strconv.Itoa(int(technicianUser.ID)),
+			inputJSON:    `{}`,
+			statusCode:   422,
+			errorMessage: "invalid user type",
+		},
+		{
+			id:         "999",
+			inputJSON:  `{"user_type": "manager"}`,
+			statusCode: 404,
+		},
+	}
+
+	for _, v := range samples {
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/users/"+v.id+"/role", bytes.NewBufferString(v.inputJSON))
+		OnError(err, fmt.Sprintf("Error on PUT /users/id/role: %v", err))
+		req.Header.Set("Authorization", ManagerTokenString)
+		router.ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			assert.Equal(t, responseMap["user_type"], v.userType)
+			assert.Equal(t, responseMap["password"], nil)
+		}
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	// the wrong password below would otherwise delay the last login
+	t.Setenv("LOGIN_BASE_DELAY_MS", "1")
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+	users, err := SeedUsers()
+	OnError(err, fmt.Sprintf("Error seeding user: %v\n", err))
+	managerUser := users[0]
+	technicianUser := users[2]
+	managerToken, err := SignIn(managerUser.Email, "password")
+	OnError(err, fmt.Sprintf("Cannot login as manager: %v\n", err))
+	tokens := login(technicianUser.Email, "password")
+
+	request := func(method, path, token, body string) (int, map[string]interface{}) {
+		router := SetupRouter()
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		OnError(err, fmt.Sprintf("Error on %s %s: %v", method, path, err))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(rr, req)
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+		return rr.Code, responseMap
+	}
+	path := fmt.Sprintf("/users/%d", technicianUser.ID)
+	credentials := fmt.Sprintf(`{"email": "%s", "password": "password"}`, technicianUser.Email)
+
+	code, _ := request("GET", path, tokens.AccessToken, "")
+	assert.Equal(t, code, 200)
+
+	code, responseMap := request("POST", path+"/deactivate", managerToken, "")
+	assert.Equal(t, code, 200)
+	assert.NotEqual(t, responseMap["deactivated_at"], nil)
+
+	code, responseMap = request("GET", path, tokens.AccessToken, "")
+	assert.Equal(t, code, 401)
+	assert.Equal(t, responseMap["error"], "authentication required")
+
+	code, responseMap = request("POST", "/login", "", credentials)
+	assert.Equal(t, code, 403)
+	assert.Equal(t, responseMap["error"], "account deactivated")
+
+	code, responseMap = request("POST", "/login", "", fmt.Sprintf(`{"email": "%s", "password": "wrong"}`, technicianUser.Email))
+	assert.Equal(t, code, 422)
+	assert.Equal(t, responseMap["error"], "incorrect details")
+
+	code, _ = refresh(tokens.RefreshToken)
+	assert.Equal(t, code, 401)
+
+	code, responseMap = request("POST", path+"/reactivate", managerToken, "")
+	assert.Equal(t, code, 200)
+	assert.Equal(t, responseMap["deactivated_at"], nil)
+
+	code, _ = request("POST", "/login", "", credentials)
+	assert.Equal(t, code, 200)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+)
+
+// verificationInterval is the least time between two verification emails of
+// an account asked with /verify/resend.
+var verificationInterval = time.Minute
+
+const errInvalidVerificationToken = "invalid or expired verification token"
+
+// VerifyEmail confirms the email of an account
+//
+//	@Summary		Confirms the email of an account with an emailed token
+//	@Description	The link sent on registration or by /verify/resend points here, the token is single use and only verifies the email it was sent to
+//	@Tags			users
+//	@Produce		json
+//	@Param			token	query		string	true	"verification token"
+//	@Success		200	{object}	nil
+//	@Failure		422	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/verify [get]
+func VerifyEmail(context *gin.Context) {
+	token := context.Query("token")
+	if token == "" {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": "required verification token"})
+		return
+	}
+	err := middlewares.Store(context).Transaction(func(store repository.Store) error {
+		user, err := redeemSingleUseToken(store, models.EmailVerificationPurpose, token)
+		if err != nil {
+			return err
+		}
+		_, err = store.Users().Verify(user.ID)
+		return err
+	})
+	if err == errInvalidSingleUseToken {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": errInvalidVerificationToken})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendVerification emails a new verification link
+//
+//	@Summary		Emails a new link to verify the email of the caller
+//	@Description	A new link replaces the previous ones, at most one is sent per minute
+//	@Tags			users
+//	@Produce		json
+//	@Success		202	{object}	nil
+//	@Failure		401	{object}	nil
+//	@Failure		409	{object}	nil
+//	@Failure		429	{object}	nil
+//	@Failure		500	{object}	nil
+//	@Router			/verify/resend [post]
+func ResendVerification(context *gin.Context) {
+	user := middlewares.CurrentUser(context)
+	if user.Verified() {
+		context.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+		return
+	}
+	sent := false
+	err := middlewares.Store(context).Transaction(func(store repository.Store) error {
+		recent, err := store.SingleUseTokens().Recent(models.EmailVerificationPurpose, user.ID, verificationInterval)
+		if err != nil || recent {
+			return err
+		}
+		sent = true
+		return sendVerification(context.Request.Context(), store, user)
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !sent {
+		context.Header("Retry-After", fmt.Sprintf("%.0f", verificationInterval.Seconds()))
+		context.JSON(http.StatusTooManyRequests, gin.H{"error": "verification email already sent, try again later"})
+		return
+	}
+	context.JSON(http.StatusAccepted, gin.H{"message": "a verification link was sent"})
+}
+
+// sendVerification stores a new verification token for user and queues the
+// verification message with its link.
+func sendVerification(ctx context.Context, store repository.Store, user *models.User) error {
+	return sendSingleUseToken(ctx, store, user, models.EmailVerificationPurpose, "verification", auth.EmailVerificationExpiration(), auth.EmailVerificationURL)
+}
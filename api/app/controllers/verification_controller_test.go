@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// requestJSON sends body with the access token when one is given and returns
+// the status, the Retry-After header and the decoded response.
+func requestJSON(method, path, accessToken, body string) (int, string, map[string]interface{}) {
+	router := SetupRouter()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+	OnError(err, fmt.Sprintf("Error on %s %s: %v", method, path, err))
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	router.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	if rr.Body.Len() > 0 {
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		OnError(err, fmt.Sprintf("Cannot convert to json: %v", err))
+	}
+	return rr.Code, rr.Header().Get("Retry-After"), responseMap
+}
+
+func TestVerifyEmail(t *testing.T) {
+	err := RefreshTables()
+	OnError(err, "Error refreshing users table")
+
+	code, responseMap := postJSON("/users", `{"nickname":"New", "email": "new@gmail.com", "password": "password"}`)
+	assert.Equal(t, code, 201)
+	assert.Equal(t, responseMap["verified_at"], nil)
+	uid := uint64(responseMap["id"].(float64))
+	assert.Equal(t, PendingOutboxMessages("verification"), 1)
+	first := linkToken("verification")
+	assert.NotEqual(t, first, "")
+	tokens := login("new@gmail.com", "password")
+
+	// unverified accounts cannot create tasks
+	code, _, responseMap = requestJSON("POST", "/tasks", tokens.AccessToken, `{"summary": "Fix the elevator"}`)
+	assert.Equal(t, code, 403)
+	assert.Equal(t, responseMap["error"], "email not verified")
+
+	code, retryAfter, responseMap := requestJSON("POST", "/verify/resend", tokens.AccessToken, "")
+	assert.Equal(t, code, 429)
+	assert.Equal(t, retryAfter, "60")
+	assert.Equal(t, responseMap["error"], "verification email already sent, try again later")
+	assert.Equal(t, PendingOutboxMessages("verification"), 1)
+
+	// once the interval is over a new link replaces the first one
+	verificationInterval = 0
+	code, _, _ = requestJSON("POST", "/verify/resend", tokens.AccessToken, "")
+	verificationInterval = time.Minute
+	assert.Equal(t, code, 202)
+	assert.Equal(t, PendingOutboxMessages("verification"), 2)
+	token := linkToken("verification")
+	assert.NotEqual(t, token, first)
+
+	for hash, verification := range map[string]models.SingleUseToken{
+		"expired":     {Email: "new@gmail.com", ExpiresAt: time.Now().Add(-time.Minute)},
+		"other-email": {Email: "old@gmail.com", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		verification.UserID, verification.Purpose, verification.TokenHash = uid, models.EmailVerificationPurpose, auth.HashToken(hash)
+		err = repository.Default.SingleUseTokens().Save(&verification)
+		OnError(err, fmt.Sprintf("Cannot seed verifications: %v", err))
+	}
+
+	samples := []struct {
+		query        string
+		errorMessage string
+	}{
+		{query: "", errorMessage: "required verification token"},
+		{query: "?token=wrong", errorMessage: "invalid or expired verification token"},
+		{query: "?token=expired", errorMessage: "invalid or expired verification token"},
+		{query: "?token=other-email", errorMessage: "invalid or expired verification token"},
+		{query: "?token=" + first, errorMessage: "invalid or expired verification token"},
+	}
+	for _, v := range samples {
+		code, _, responseMap = requestJSON("GET", "/verify"+v.query, "", "")
+		assert.Equal(t, code, 422)
+		assert.Equal(t, responseMap["error"], v.errorMessage)
+	}
+
+	code, _, responseMap = requestJSON("GET", "/verify?token="+token, "", "")
+	assert.Equal(t, code, 200)
+	assert.Equal(t, responseMap["message"], "email verified")
+
+	// the token is single use
+	code, _, _ = requestJSON("GET", "/verify?token="+token, "", "")
+	assert.Equal(t, code, 422)
+
+	code, _, responseMap = requestJSON("GET", fmt.Sprintf("/users/%d", uid), tokens.AccessToken, "")
+	assert.Equal(t, code, 200)
+	assert.NotEqual(t, responseMap["verified_at"], nil)
+	code, _, _ = requestJSON("POST", "/tasks", tokens.AccessToken, `{"summary": "Fix the elevator"}`)
+	assert.Equal(t, code, 201)
+	code, _, responseMap = requestJSON("POST", "/verify/resend", tokens.AccessToken, "")
+	assert.Equal(t, code, 409)
+	assert.Equal(t, responseMap["error"], "email already verified")
+
+	// a new email has to be verified again
+	code, _, _ = requestJSON("PUT", fmt.Sprintf("/users/%d", uid), tokens.AccessToken, `{"nickname":"New", "email": "moved@gmail.com", "password": "password"}`)
+	assert.Equal(t, code, 200)
+	code, _, responseMap = requestJSON("POST", "/tasks", tokens.AccessToken, `{"summary": "Fix the elevator"}`)
+	assert.Equal(t, code, 403)
+	assert.Equal(t, responseMap["error"], "email not verified")
+
+	// with the link sent to it
+	assert.Equal(t, PendingOutboxMessages("verification"), 3)
+	code, _, _ = requestJSON("GET", "/verify?token="+linkToken("verification"), "", "")
+	assert.Equal(t, code, 200)
+	code, _, _ = requestJSON("POST", "/tasks", tokens.AccessToken, `{"summary": "Fix the elevator"}`)
+	assert.Equal(t, code, 201)
+}
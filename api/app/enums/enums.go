@@ -4,3 +4,11 @@ const (
 	MANAGER    = "manager"
 	TECHNICIAN = "technician"
 )
+
+const (
+	OPEN        = "open"
+	IN_PROGRESS = "in_progress"
+	BLOCKED     = "blocked"
+	DONE        = "done"
+	CANCELLED   = "cancelled"
+)
@@ -0,0 +1,137 @@
+// Package logging sets up log/slog to write JSON lines, ties them to the
+// request that caused them and keeps personal data out of them. The worker
+// has a copy of the redaction rules, keep both in step.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request id in HTTP requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// redactedKeys are attributes whose values never reach the logs.
+var redactedKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+	"nickname":      true,
+	"summary":       true,
+	"body":          true,
+	"filename":      true,
+	// password reset and verification links carry their token
+	"link": true,
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// MaskEmail keeps the first letter and the domain of an address, enough to
+// tell entries apart without naming anyone.
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "[REDACTED]"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// Redact is a slog ReplaceAttr: it drops the values of redactedKeys and masks
+// the email addresses of any other string, messages and errors included.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if redactedKeys[key] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	if err, ok := a.Value.Any().(error); ok && a.Value.Kind() == slog.KindAny {
+		a = slog.String(a.Key, err.Error())
+	}
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	if key == "email" {
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	}
+	value := a.Value.String()
+	if strings.Contains(value, "@") {
+		return slog.String(a.Key, emailPattern.ReplaceAllStringFunc(value, MaskEmail))
+	}
+	return a
+}
+
+// NewHandler writes JSON lines to w with the redaction rules.
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: Redact})
+}
+
+// Setup makes the redacting JSON handler the default, the standard log
+// package included, at the level LOG_LEVEL names (info by default).
+func Setup(service string) {
+	level := slog.LevelInfo
+	_ = level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	slog.SetDefault(slog.New(NewHandler(os.Stdout, level)).With("service", service))
+}
+
+// Fatal logs at error level and exits, like log.Fatal.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random id for a request that came without one.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID accepts ids clients may send: up to 64 letters, digits,
+// dashes, underscores and dots, so they are safe to log and forward.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id stored by WithRequestID, "" when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns the default logger with the request id and the trace
+// id of ctx.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
+}
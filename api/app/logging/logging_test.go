@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestRedact(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(NewHandler(out, slog.LevelInfo))
+	logger.Info("sent to vitor@gmail.com",
+		"email", "kenny@gmail.com",
+		"password", "hunter2",
+		slog.Group("user", "nickname", "Kenny", "id", 3),
+		"error", errors.New("550 denny@gmail.com: mailbox unavailable"),
+		"reason", "550 billy@gmail.com: mailbox unavailable",
+		"status", 201,
+	)
+	line := map[string]interface{}{}
+	err := json.Unmarshal(out.Bytes(), &line)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, line["msg"], "sent to v***@gmail.com")
+	assert.Equal(t, line["email"], "k***@gmail.com")
+	assert.Equal(t, line["password"], "[REDACTED]")
+	assert.Equal(t, line["user"], map[string]interface{}{"nickname": "[REDACTED]", "id": float64(3)})
+	assert.Equal(t, line["reason"], "550 b***@gmail.com: mailbox unavailable")
+	assert.Equal(t, line["status"], float64(201))
+	assert.Equal(t, bytes.Contains(out.Bytes(), []byte("denny@")), false)
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, RequestID(ctx), "")
+	ctx = WithRequestID(ctx, "abc-123")
+	assert.Equal(t, RequestID(ctx), "abc-123")
+
+	assert.Equal(t, ValidRequestID("abc-123_4.5"), true)
+	assert.Equal(t, ValidRequestID(""), false)
+	assert.Equal(t, ValidRequestID("a b"), false)
+	assert.Equal(t, ValidRequestID("abc\ninjected"), false)
+	assert.Equal(t, ValidRequestID(NewRequestID()), true)
+}
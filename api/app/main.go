@@ -1,29 +1,54 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/auth"
 	"github.com/vitorbiten/maintenance/api/app/controllers"
+	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/metrics"
+	"github.com/vitorbiten/maintenance/api/app/middlewares"
+	"github.com/vitorbiten/maintenance/api/app/migrations"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/outbox"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"github.com/vitorbiten/maintenance/api/app/storage"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+	"github.com/vitorbiten/maintenance/api/app/utils"
 	"golang.org/x/sync/errgroup"
 
 	"context"
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func serveApplication() {
 	mainCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	router := gin.Default()
+	router := gin.New()
+	err := router.SetTrustedProxies(auth.TrustedProxies())
+	if err != nil {
+		logging.Fatal("invalid trusted proxies", "error", err)
+	}
+	router.Use(middlewares.Logger(), gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logging.FromContext(c.Request.Context()).Error("panic", "error", fmt.Sprint(recovered))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
 
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://127.0.0.1:8000"}
@@ -41,21 +66,241 @@ func serveApplication() {
 
 	g, gCtx := errgroup.WithContext(mainCtx)
 	g.Go(func() error {
-		log.Printf("Listening to port %s\n", apiPort)
+		slog.Info("listening", "addr", apiPort)
 		return server.ListenAndServe()
 	})
+	g.Go(func() error {
+		return outbox.Run(gCtx, repository.Default)
+	})
 	g.Go(func() error {
 		<-gCtx.Done()
-		log.Println("Shutting down server...")
+		slog.Info("shutting down server")
 		return server.Shutdown(context.Background())
 	})
 
 	if err := g.Wait(); err != nil {
-		log.Printf("Exit reason: %s \n", err)
+		slog.Info("server stopped", "reason", err)
+	}
+}
+
+func migrate(args []string) {
+	if len(args) == 0 {
+		logging.Fatal("usage: migrate up|down [-limit N]|status")
+	}
+	adapters.LoadDatabase()
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(adapters.DB)
+		if err != nil {
+			logging.Fatal("failed to apply migrations", "error", err)
+		}
+		slog.Info("applied migrations", "count", applied)
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ExitOnError)
+		limit := flags.Int("limit", 1, "maximum number of migrations to roll back (0 rolls back all)")
+		_ = flags.Parse(args[1:])
+		rolledBack, err := migrations.Down(adapters.DB, *limit)
+		if err != nil {
+			logging.Fatal("failed to roll back migrations", "error", err)
+		}
+		slog.Info("rolled back migrations", "count", rolledBack)
+	case "status":
+		statuses, err := migrations.GetStatus(adapters.DB)
+		if err != nil {
+			logging.Fatal("failed to read migrations", "error", err)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-60s %s\n", status.ID, appliedAt)
+		}
+	default:
+		logging.Fatal("unknown migrate command", "command", args[0])
+	}
+}
+
+func reencrypt(args []string) {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "number of tasks read per batch")
+	_ = flags.Parse(args)
+	if *batch < 1 {
+		logging.Fatal("batch must be positive")
+	}
+	keyring, err := utils.CurrentKeyring()
+	if err != nil {
+		logging.Fatal("failed to load encryption keys", "error", err)
+	}
+	adapters.LoadDatabase()
+	for _, column := range []struct {
+		name      string
+		reencrypt func(db models.DBTX, afterID uint64, limit int) (uint64, int, error)
+	}{
+		{"task summaries", models.ReencryptSummaries},
+		{"comments", models.ReencryptComments},
+		{"comment revisions", models.ReencryptCommentRevisions},
+		{"attachment filenames", models.ReencryptAttachmentFilenames},
+		{"attachment keys", models.ReencryptAttachmentKeys},
+	} {
+		var lastID uint64
+		total := 0
+		for {
+			next, rewritten, err := column.reencrypt(adapters.DB, lastID, *batch)
+			if err != nil {
+				logging.Fatal("failed to re-encrypt", "column", column.name, "after_id", lastID, "error", err)
+			}
+			if next == 0 {
+				break
+			}
+			total += rewritten
+			lastID = next
+			slog.Info("re-encrypted batch", "column", column.name, "count", rewritten, "last_id", lastID)
+		}
+		slog.Info("re-encrypted column", "column", column.name, "count", total, "key_id", keyring.ActiveKeyID())
+	}
+}
+
+// reindex rebuilds the search tokens of every task, after SEARCH_INDEX_KEY
+// changes or for tasks created before search existed.
+func reindex(args []string) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "number of tasks read per batch")
+	_ = flags.Parse(args)
+	if *batch < 1 {
+		logging.Fatal("batch must be positive")
+	}
+	_, err := utils.CurrentBlindIndex()
+	if err != nil {
+		logging.Fatal("failed to load search index key", "error", err)
+	}
+	adapters.LoadDatabase()
+	var lastID uint64
+	total := 0
+	for {
+		next, reindexed, err := models.ReindexSummaries(adapters.DB, lastID, *batch)
+		if err != nil {
+			logging.Fatal("failed to reindex tasks", "after_id", lastID, "error", err)
+		}
+		if next == 0 {
+			break
+		}
+		total += reindexed
+		lastID = next
+		slog.Info("reindexed batch", "count", reindexed, "last_id", lastID)
+	}
+	slog.Info("reindexed summaries", "count", total)
+}
+
+// createManager bootstraps the first manager, the password is read from
+// MANAGER_PASSWORD or the first line of stdin so it stays out of the shell
+// history. The manager starts verified, later managers are promoted through
+// PUT /users/:id/role.
+func createManager(args []string) {
+	flags := flag.NewFlagSet("create-manager", flag.ExitOnError)
+	nickname := flags.String("nickname", "", "manager nickname")
+	email := flags.String("email", "", "manager email")
+	_ = flags.Parse(args)
+	password := os.Getenv("MANAGER_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			logging.Fatal("failed to read password", "error", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	manager := models.User{Nickname: *nickname, Email: *email, Password: password}
+	manager.Prepare()
+	err := manager.Validate("")
+	if err != nil {
+		logging.Fatal("invalid manager", "error", err)
+	}
+	manager.UserType = enums.MANAGER
+
+	adapters.LoadDatabase()
+	repository.LoadMySQL(adapters.DB)
+	users := repository.Default.Users()
+	managers, err := users.FindAllManagers()
+	if err != nil {
+		logging.Fatal("failed to read managers", "error", err)
 	}
+	if len(*managers) > 0 {
+		logging.Fatal("a manager already exists, promote users through PUT /users/:id/role instead")
+	}
+	_, err = users.Save(&manager)
+	if err != nil {
+		logging.Fatal("failed to create manager", "error", err)
+	}
+	// whoever runs the command vouches for the email, no link is sent
+	_, err = users.Verify(manager.ID)
+	if err != nil {
+		logging.Fatal("failed to verify manager", "error", err)
+	}
+	slog.Info("created manager", "email", manager.Email, "user_id", manager.ID)
 }
 
 func main() {
+	logging.Setup("api")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		reencrypt(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		reindex(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create-manager" {
+		createManager(os.Args[2:])
+		return
+	}
+
+	_, err := utils.CurrentKeyring()
+	if err != nil {
+		logging.Fatal("failed to load encryption keys", "error", err)
+	}
+	_, err = utils.CurrentBlindIndex()
+	if err != nil {
+		logging.Fatal("failed to load search index key", "error", err)
+	}
+	_, err = auth.CurrentKeySet()
+	if err != nil {
+		logging.Fatal("failed to load signing keys", "error", err)
+	}
+	storage.Default, err = storage.FromEnv()
+	if err != nil {
+		logging.Fatal("failed to load attachment storage", "error", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), "maintenance-api")
+	if err != nil {
+		logging.Fatal("failed to set up tracing", "error", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			slog.Warn("failed to flush spans", "error", err)
+		}
+	}()
 	adapters.LoadDatabase()
+	repository.LoadMySQL(adapters.DB)
+	err = metrics.RegisterDB(adapters.DB, os.Getenv("DB_NAME"))
+	if err != nil {
+		logging.Fatal("failed to register database metrics", "error", err)
+	}
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		applied, err := migrations.Up(adapters.DB)
+		if err != nil {
+			logging.Fatal("failed to apply migrations", "error", err)
+		}
+		slog.Info("applied migrations", "count", applied)
+	}
+	adapters.LoadPublisher()
+	defer adapters.ClosePublisher()
 	serveApplication()
 }
@@ -0,0 +1,58 @@
+// Package metrics holds the Prometheus collectors of the API, served at
+// /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry has the API collectors plus the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestDuration is labelled with the route template, not the path, so
+	// ids do not blow up the number of series. Unmatched routes share one.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	RequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being handled.",
+	})
+
+	// PublishedMessages counts the messages sent to the worker by whether the
+	// broker confirmed them.
+	PublishedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_published_messages_total",
+		Help: "Messages published to RabbitMQ by controller and result (success or failure).",
+	}, []string{"controller", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestDuration,
+		RequestsInFlight,
+		PublishedMessages,
+	)
+}
+
+// RegisterDB exposes the connection pool stats of db, it is called once the
+// database is loaded.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics of Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestHandler(t *testing.T) {
+	RequestDuration.WithLabelValues("GET", "/tasks/:id", "200").Observe(0.05)
+	PublishedMessages.WithLabelValues("notification", "success").Add(2)
+	// the stats collector only reads the pool, no connection is opened
+	err := RegisterDB(&sql.DB{}, "maintenance_api")
+	assert.Equal(t, err, nil)
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	text := string(body)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, strings.Contains(text, `http_request_duration_seconds_count{method="GET",route="/tasks/:id",status="200"} 1`), true)
+	assert.Equal(t, strings.Contains(text, `rabbitmq_published_messages_total{controller="notification",result="success"} 2`), true)
+	assert.Equal(t, strings.Contains(text, `go_sql_max_open_connections{db_name="maintenance_api"}`), true)
+}
@@ -1,13 +1,38 @@
 package middlewares
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vitorbiten/maintenance/api/app/auth"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/metrics"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/policy"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	UserKey  = "user"
+	TokenKey = "token"
+	IDKey    = "id"
+)
+
+// Target describes the resource a request acts on, as seen by the caller.
+type Target struct {
+	Ownership policy.Ownership
+	Role      string
+}
+
+// TargetLoader loads the resource of the request, it may store it in the
+// context for the handler and returns the status to abort with on error.
+type TargetLoader func(c *gin.Context, user *models.User) (*Target, int, error)
+
 func SetMiddlewareJSON(next http.HandlerFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Content-Type", "application/json")
@@ -15,16 +40,166 @@ func SetMiddlewareJSON(next http.HandlerFunc) gin.HandlerFunc {
 	}
 }
 
+// SetMiddlewareAuthentication validates the JWT and loads the caller once,
+// handlers and Authorize read it back with CurrentUser. Tokens issued before
+// the password of the caller was reset are rejected.
 func SetMiddlewareAuthentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		fmt.Print("Hello, World!")
-		err := auth.TokenValid(c.Request)
+		metadata, err := auth.ExtractTokenMetadata(Store(c), c.Request)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
-			fmt.Println(err)
-			c.Abort()
+			Unauthorized(c)
+			return
+		}
+		user, err := Store(c).Users().FindByID(metadata.UserID)
+		if err != nil || !user.Active() {
+			Unauthorized(c)
+			return
+		}
+		if user.PasswordChangedAt != nil && metadata.IssuedAt.Before(*user.PasswordChangedAt) {
+			Unauthorized(c)
 			return
 		}
+		c.Set(TokenKey, metadata)
+		c.Set(UserKey, user)
 		c.Next()
 	}
 }
+
+// Unauthorized aborts requests without valid credentials.
+func Unauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+}
+
+// Forbidden aborts requests of authenticated callers the policy denies.
+func Forbidden(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+}
+
+// RequireVerified aborts requests of callers who did not verify their email
+// yet, it runs after SetMiddlewareAuthentication.
+func RequireVerified() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentUser(c).Verified() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email not verified"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireID parses the :id path parameter and stores it in the context.
+func RequireID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(IDKey, id)
+		c.Next()
+	}
+}
+
+// Authorize evaluates the policy for the action on the resource, it runs
+// after SetMiddlewareAuthentication. Without a loader the request acts on the
+// caller's own resources, like creating or listing them.
+func Authorize(resource, action string, load TargetLoader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if !policy.Permits(user.UserType, resource, action) {
+			Forbidden(c)
+			return
+		}
+		target := &Target{Ownership: policy.Own}
+		if load != nil {
+			var status int
+			var err error
+			target, status, err = load(c, user)
+			if err != nil {
+				c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if !policy.Allowed(user.UserType, resource, action, target.Ownership, target.Role) {
+			Forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Store returns the default store bound to the request context.
+func Store(c *gin.Context) repository.Store {
+	return repository.Default.WithContext(c.Request.Context())
+}
+
+func CurrentUser(c *gin.Context) *models.User {
+	return c.MustGet(UserKey).(*models.User)
+}
+
+// CurrentToken returns the metadata of the access token of the request.
+func CurrentToken(c *gin.Context) *auth.TokenMetadata {
+	return c.MustGet(TokenKey).(*auth.TokenMetadata)
+}
+
+// RequestID keeps the X-Request-ID of the request when it is a valid id, or
+// makes one up, echoes it in the response and stores it in the request
+// context so logs and published messages carry it. The request span gets it
+// as an attribute.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		c.Header(logging.RequestIDHeader, id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request_id", id))
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Logger writes a line per request once it is handled. The query string is
+// left out since search terms can name people.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		args := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if user, ok := c.Get(UserKey); ok {
+			args = append(args, "user_id", user.(*models.User).ID)
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, "error", c.Errors.String())
+		}
+		logging.FromContext(c.Request.Context()).Log(c.Request.Context(), level, "request", args...)
+	}
+}
+
+// Metrics records how long each request took by route template, requests
+// that match no route are grouped under "unmatched".
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metrics.RequestsInFlight.Inc()
+		defer metrics.RequestsInFlight.Dec()
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.RequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
@@ -12,7 +12,7 @@ CREATE TABLE IF NOT EXISTS `users` (
   UNIQUE KEY `email` (`email`)
 ) ENGINE=InnoDB DEFAULT CHARSET=latin1;
 
-INSERT INTO maintenance_api.users
+INSERT INTO users
 (id, nickname, email, user_type, password, created_at, updated_at)
 VALUES(0, 'Martin Luther', 'luther@gmail.com', 'manager', 'password', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
 
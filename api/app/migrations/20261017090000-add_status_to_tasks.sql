@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE `tasks`
+  ADD COLUMN `status` enum('open','in_progress','blocked','done','cancelled') NOT NULL DEFAULT 'open' AFTER `author_id`;
+
+-- +migrate Down
+ALTER TABLE `tasks` DROP COLUMN `status`;
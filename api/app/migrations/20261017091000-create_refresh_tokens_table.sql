@@ -0,0 +1,27 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint(10) unsigned NOT NULL,
+  `family_id` char(32) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token_hash` (`token_hash`),
+  KEY `refresh_tokens_family_id` (`family_id`),
+  KEY `refresh_tokens_user_id_users_id_foreign` (`user_id`),
+  CONSTRAINT `refresh_tokens_user_id_users_id_foreign` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+CREATE TABLE IF NOT EXISTS `revoked_tokens` (
+  `jti` char(32) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`jti`),
+  KEY `revoked_tokens_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `revoked_tokens`;
+DROP TABLE `refresh_tokens`;
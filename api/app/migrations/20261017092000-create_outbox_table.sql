@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS `outbox` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `controller` varchar(100) NOT NULL,
+  `payload` text NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  `sent_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `outbox_sent_at` (`sent_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `outbox`;
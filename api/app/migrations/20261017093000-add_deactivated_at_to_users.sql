@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE `users` ADD COLUMN `deactivated_at` datetime DEFAULT NULL AFTER `password`;
+
+-- the seeded manager was stored with a plaintext password and could never log
+-- in, managers are created with `main create-manager` instead
+DELETE FROM `users` WHERE `email` = 'luther@gmail.com' AND `password` = 'password';
+
+-- +migrate Down
+ALTER TABLE `users` DROP COLUMN `deactivated_at`;
@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS `task_search_tokens` (
+  `token` char(32) NOT NULL,
+  `task_id` bigint(10) unsigned NOT NULL,
+  `hits` smallint unsigned NOT NULL DEFAULT 1,
+  PRIMARY KEY (`token`, `task_id`),
+  KEY `task_search_tokens_task_id_tasks_id_foreign` (`task_id`),
+  CONSTRAINT `task_search_tokens_task_id_tasks_id_foreign` FOREIGN KEY (`task_id`) REFERENCES `tasks` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `task_search_tokens`;
@@ -0,0 +1,28 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS `task_comments` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `task_id` bigint(10) unsigned NOT NULL,
+  `author_id` bigint(10) unsigned NOT NULL,
+  `body` text NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `task_comments_task_id_tasks_id_foreign` (`task_id`),
+  KEY `task_comments_author_id_users_id_foreign` (`author_id`),
+  CONSTRAINT `task_comments_task_id_tasks_id_foreign` FOREIGN KEY (`task_id`) REFERENCES `tasks` (`id`) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `task_comments_author_id_users_id_foreign` FOREIGN KEY (`author_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+CREATE TABLE IF NOT EXISTS `task_comment_revisions` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `comment_id` bigint(10) unsigned NOT NULL,
+  `body` text NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `task_comment_revisions_comment_id_task_comments_id_foreign` (`comment_id`),
+  CONSTRAINT `task_comment_revisions_comment_id_task_comments_id_foreign` FOREIGN KEY (`comment_id`) REFERENCES `task_comments` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `task_comment_revisions`;
+DROP TABLE `task_comments`;
@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS `task_attachments` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `task_id` bigint(10) unsigned NOT NULL,
+  `author_id` bigint(10) unsigned NOT NULL,
+  `filename` text NOT NULL,
+  `content_type` varchar(100) NOT NULL,
+  `size` bigint(20) unsigned NOT NULL,
+  `storage_key` varchar(255) NOT NULL,
+  `file_key` varchar(255) NOT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `task_attachments_storage_key_unique` (`storage_key`),
+  KEY `task_attachments_task_id_tasks_id_foreign` (`task_id`),
+  KEY `task_attachments_author_id_users_id_foreign` (`author_id`),
+  CONSTRAINT `task_attachments_task_id_tasks_id_foreign` FOREIGN KEY (`task_id`) REFERENCES `tasks` (`id`) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `task_attachments_author_id_users_id_foreign` FOREIGN KEY (`author_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `task_attachments`;
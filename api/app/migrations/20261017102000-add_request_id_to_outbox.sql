@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE `outbox` ADD COLUMN `request_id` varchar(64) DEFAULT NULL AFTER `payload`;
+
+-- +migrate Down
+ALTER TABLE `outbox` DROP COLUMN `request_id`;
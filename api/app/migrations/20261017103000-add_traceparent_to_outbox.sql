@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE `outbox` ADD COLUMN `traceparent` varchar(55) DEFAULT NULL AFTER `request_id`;
+
+-- +migrate Down
+ALTER TABLE `outbox` DROP COLUMN `traceparent`;
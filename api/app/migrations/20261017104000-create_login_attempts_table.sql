@@ -0,0 +1,14 @@
+-- +migrate Up
+-- subject is the sha256 of an account email or a client address, so failed
+-- logins are counted for unknown emails too without storing them; the times
+-- keep milliseconds since the delays between attempts start at one second
+CREATE TABLE IF NOT EXISTS `login_attempts` (
+  `subject` char(64) NOT NULL,
+  `failures` int unsigned NOT NULL DEFAULT 0,
+  `last_failure_at` datetime(3) NOT NULL,
+  `locked_until` datetime(3) DEFAULT NULL,
+  PRIMARY KEY (`subject`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `login_attempts`;
@@ -0,0 +1,20 @@
+-- +migrate Up
+-- password resets and email verifications are single use tokens told apart
+-- by purpose, only their sha256 is stored with the email they were sent to
+CREATE TABLE IF NOT EXISTS `single_use_tokens` (
+  `id` bigint(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint(10) unsigned NOT NULL,
+  `purpose` varchar(32) NOT NULL,
+  `email` varchar(100) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token_hash` (`token_hash`),
+  KEY `single_use_tokens_user_id_purpose` (`user_id`, `purpose`),
+  CONSTRAINT `single_use_tokens_user_id_users_id_foreign` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+
+-- +migrate Down
+DROP TABLE `single_use_tokens`;
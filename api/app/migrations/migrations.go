@@ -0,0 +1,317 @@
+// Package migrations embeds the sql-migrate files of the API and applies them.
+// Applied migrations are recorded in the same table sql-migrate uses, so
+// databases migrated with either tool stay in agreement.
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+const (
+	table       = "gorp_migrations"
+	lockName    = "maintenance_api_migrations"
+	lockTimeout = 60
+)
+
+type Migration struct {
+	ID   string
+	Up   []string
+	Down []string
+}
+
+type Status struct {
+	ID        string     `json:"id"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Load returns the embedded migrations sorted by id.
+func Load() ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	migrations := make([]Migration, 0, len(names))
+	for _, name := range names {
+		content, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parse(name, string(content))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+// parse splits a sql-migrate file in its up and down statements. Statements
+// end with a semicolon at the end of a line unless they are wrapped in
+// StatementBegin and StatementEnd.
+func parse(id, content string) (Migration, error) {
+	migration := Migration{ID: id}
+	var current *[]string
+	var statement strings.Builder
+	inBlock := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "-- +migrate ") {
+			fields := strings.Fields(strings.TrimPrefix(trimmed, "-- +migrate "))
+			if len(fields) == 0 {
+				return migration, fmt.Errorf("%s: empty migrate directive", id)
+			}
+			switch fields[0] {
+			case "Up":
+				current = &migration.Up
+			case "Down":
+				current = &migration.Down
+			case "StatementBegin":
+				inBlock = true
+			case "StatementEnd":
+				inBlock = false
+				if current != nil && strings.TrimSpace(statement.String()) != "" {
+					*current = append(*current, strings.TrimSpace(statement.String()))
+				}
+				statement.Reset()
+			default:
+				return migration, fmt.Errorf("%s: unknown migrate directive %q", id, fields[0])
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if statement.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if !inBlock && strings.HasSuffix(trimmed, ";") {
+			*current = append(*current, strings.TrimSpace(statement.String()))
+			statement.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return migration, err
+	}
+	if inBlock {
+		return migration, fmt.Errorf("%s: missing StatementEnd", id)
+	}
+	if strings.TrimSpace(statement.String()) != "" && current != nil {
+		*current = append(*current, strings.TrimSpace(statement.String()))
+	}
+	if migration.Up == nil {
+		return migration, fmt.Errorf("%s: missing Up section", id)
+	}
+	return migration, nil
+}
+
+// withLock runs fn on a single connection holding a MySQL advisory lock, so
+// replicas starting at the same time apply each migration once.
+func withLock(db *sql.DB, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?);", lockName, lockTimeout).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return errors.New("could not acquire the migrations lock")
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?);", lockName)
+	}()
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+table+"` (`id` varchar(255) NOT NULL, `applied_at` datetime DEFAULT NULL, PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=latin1;")
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// queryer is implemented by *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func applied(ctx context.Context, conn queryer) (map[string]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT id, applied_at FROM `"+table+"`;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var appliedAt sql.NullTime
+		err = rows.Scan(&id, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[id] = appliedAt.Time
+	}
+	return result, rows.Err()
+}
+
+func run(ctx context.Context, conn *sql.Conn, id string, statements []string, record string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, record, id)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration and returns how many were applied.
+func Up(db *sql.DB) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = withLock(db, func(conn *sql.Conn) error {
+		ctx := context.Background()
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := done[migration.ID]; ok {
+				continue
+			}
+			err = run(ctx, conn, migration.ID, migration.Up, "INSERT INTO `"+table+"` (id, applied_at) VALUES (?, NOW());")
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the last limit applied migrations, all of them when limit
+// is 0, and returns how many were rolled back.
+func Down(db *sql.DB, limit int) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = withLock(db, func(conn *sql.Conn) error {
+		ctx := context.Background()
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if limit > 0 && count >= limit {
+				break
+			}
+			migration := migrations[i]
+			if _, ok := done[migration.ID]; !ok {
+				continue
+			}
+			err = run(ctx, conn, migration.ID, migration.Down, "DELETE FROM `"+table+"` WHERE id = ?;")
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// GetStatus lists the embedded migrations with the time they were applied,
+// pending migrations have no AppliedAt.
+func GetStatus(db *sql.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	err = withLock(db, func(conn *sql.Conn) error {
+		done, err := applied(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := Status{ID: migration.ID}
+			if appliedAt, ok := done[migration.ID]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Version is the schema of the database compared with the embedded
+// migrations: Current is the latest applied migration, Expected the latest
+// embedded one and Pending the embedded migrations not applied yet.
+type Version struct {
+	Current  string   `json:"current"`
+	Expected string   `json:"expected"`
+	Pending  []string `json:"pending,omitempty"`
+}
+
+// Check reads the applied migrations without taking the migrations lock, so
+// it can back a readiness probe while another replica migrates.
+func Check(ctx context.Context, db *sql.DB) (Version, error) {
+	migrations, err := Load()
+	if err != nil {
+		return Version{}, err
+	}
+	done, err := applied(ctx, db)
+	if err != nil {
+		return Version{}, err
+	}
+	version := Version{}
+	for id := range done {
+		if id > version.Current {
+			version.Current = id
+		}
+	}
+	for _, migration := range migrations {
+		version.Expected = migration.ID
+		if _, ok := done[migration.ID]; !ok {
+			version.Pending = append(version.Pending, migration.ID)
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestParse(t *testing.T) {
+	content := `-- +migrate Up
+-- a comment
+CREATE TABLE a (
+  id int
+);
+INSERT INTO a VALUES (1);
+
+-- +migrate StatementBegin
+CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN
+  SET NEW.id = NEW.id + 1;
+END;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP TABLE a;`
+
+	migration, err := parse("1-a.sql", content)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(migration.Up), 3)
+	assert.Equal(t, migration.Up[0], "CREATE TABLE a (\n  id int\n);")
+	assert.Equal(t, migration.Up[1], "INSERT INTO a VALUES (1);")
+	assert.Equal(t, migration.Up[2], "CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN\n  SET NEW.id = NEW.id + 1;\nEND;")
+	assert.Equal(t, migration.Down, []string{"DROP TABLE a;"})
+
+	_, err = parse("2-b.sql", "-- +migrate Down\nDROP TABLE a;")
+	assert.Equal(t, err.Error(), "2-b.sql: missing Up section")
+
+	_, err = parse("3-c.sql", "-- +migrate Up\n-- +migrate StatementBegin\nSELECT 1;")
+	assert.Equal(t, err.Error(), "3-c.sql: missing StatementEnd")
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, len(migrations), 0)
+	for i, migration := range migrations {
+		if i > 0 && migrations[i-1].ID >= migration.ID {
+			t.Errorf("migrations are not sorted: %s before %s", migrations[i-1].ID, migration.ID)
+		}
+		if len(migration.Down) == 0 {
+			t.Errorf("%s has no Down section", migration.ID)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vitorbiten/maintenance/api/app/utils"
+)
+
+// AttachmentTypes are the content types accepted for attachments, as sniffed
+// from the first bytes of the file rather than declared by the client.
+var AttachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// MaxAttachmentSize reads ATTACHMENT_MAX_BYTES, 10 MiB by default.
+func MaxAttachmentSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_BYTES"), 10, 64)
+	if err != nil || size <= 0 {
+		size = 10 << 20
+	}
+	return size
+}
+
+// Attachment is a file attached to a task. The file is sealed with a key of
+// its own before it reaches the storage, and that key is kept here sealed by
+// the keyring like the filename, so deleting the row leaves the stored file
+// unreadable.
+type Attachment struct {
+	ID          uint64    `json:"id" example:"1"`
+	TaskID      uint64    `json:"task_id" example:"1"`
+	AuthorID    uint64    `json:"author_id" example:"3"`
+	Filename    string    `json:"filename" example:"compressor.jpg"`
+	ContentType string    `json:"content_type" example:"image/jpeg"`
+	Size        int64     `json:"size" example:"254731"`
+	StorageKey  string    `json:"-"`
+	FileKey     string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
+}
+
+// AttachmentFilename keeps the last element of a client supplied path without
+// control characters, so it is safe to echo in a Content-Disposition header.
+func AttachmentFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+func (a *Attachment) Validate() error {
+	if a.Filename == "" {
+		return errors.New("required filename")
+	}
+	if len(a.Filename) > 255 {
+		return errors.New("filename max length is 255 characters")
+	}
+	if _, ok := AttachmentTypes[a.ContentType]; !ok {
+		return fmt.Errorf("unsupported content type %s", a.ContentType)
+	}
+	if a.Size <= 0 {
+		return errors.New("empty file")
+	}
+	if max := MaxAttachmentSize(); a.Size > max {
+		return fmt.Errorf("file max size is %d bytes", max)
+	}
+	return nil
+}
+
+// Prepare seals the filename and the key the file is sealed with.
+func (a *Attachment) Prepare(fileKey []byte) error {
+	err := utils.Encrypt(&a.Filename)
+	if err != nil {
+		return err
+	}
+	a.FileKey = base64.StdEncoding.EncodeToString(fileKey)
+	err = utils.Encrypt(&a.FileKey)
+	if err != nil {
+		return err
+	}
+	a.CreatedAt = time.Now()
+	return nil
+}
+
+func (a *Attachment) DecryptFilename() error {
+	return utils.Decrypt(&a.Filename)
+}
+
+// OpenFileKey returns the key the stored file is sealed with.
+func (a *Attachment) OpenFileKey() ([]byte, error) {
+	fileKey := a.FileKey
+	err := utils.Decrypt(&fileKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(fileKey)
+	if err != nil {
+		return nil, utils.ErrCiphertext
+	}
+	return key, nil
+}
+
+func (a *Attachment) SaveAttachment(db DBTX) (int64, error) {
+	res, err := db.Exec("INSERT INTO `task_attachments` (`task_id`, `author_id`, `filename`, `content_type`, `size`, `storage_key`, `file_key`) VALUES (?, ?, ?, ?, ?, ?, ?);", a.TaskID, a.AuthorID, a.Filename, a.ContentType, a.Size, a.StorageKey, a.FileKey)
+	if err != nil {
+		return 0, err
+	}
+	err = a.DecryptFilename()
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (a *Attachment) FindAttachmentByID(db DBTX, aid uint64) (*Attachment, error) {
+	err := db.QueryRow("SELECT id, task_id, author_id, filename, content_type, size, storage_key, file_key, created_at FROM task_attachments WHERE id = ?;", aid).Scan(&a.ID, &a.TaskID, &a.AuthorID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.FileKey, &a.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return &Attachment{}, fmt.Errorf("attachment %w", ErrNotFound)
+	case err != nil:
+		return &Attachment{}, err
+	}
+	err = a.DecryptFilename()
+	if err != nil {
+		return &Attachment{}, err
+	}
+	return a, nil
+}
+
+// FindAttachmentsByTask returns the attachments of a task, oldest first.
+func (a *Attachment) FindAttachmentsByTask(db DBTX, tid uint64) (*[]Attachment, error) {
+	results, err := db.Query("SELECT id, task_id, author_id, filename, content_type, size, storage_key, file_key, created_at FROM task_attachments WHERE task_id = ? ORDER BY id;", tid)
+	if err != nil {
+		return &[]Attachment{}, err
+	}
+	defer results.Close()
+	attachments := []Attachment{}
+	for results.Next() {
+		var attachment Attachment
+		err = results.Scan(&attachment.ID, &attachment.TaskID, &attachment.AuthorID, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.StorageKey, &attachment.FileKey, &attachment.CreatedAt)
+		if err != nil {
+			return &[]Attachment{}, err
+		}
+		err = attachment.DecryptFilename()
+		if err != nil {
+			return &[]Attachment{}, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return &attachments, results.Err()
+}
+
+func (a *Attachment) DeleteAnAttachment(db DBTX, aid uint64) (int64, error) {
+	res, err := db.Exec("DELETE FROM `task_attachments` WHERE id = ?;", aid)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ReencryptAttachmentFilenames rewrites the attachment filenames like
+// ReencryptSummaries.
+func ReencryptAttachmentFilenames(db DBTX, afterID uint64, limit int) (uint64, int, error) {
+	return reencryptColumn(db, "task_attachments", "filename", afterID, limit)
+}
+
+// ReencryptAttachmentKeys reseals the keys of the stored files like
+// ReencryptSummaries, the files themselves are left as they are.
+func ReencryptAttachmentKeys(db DBTX, afterID uint64, limit int) (uint64, int, error) {
+	return reencryptColumn(db, "task_attachments", "file_key", afterID, limit)
+}
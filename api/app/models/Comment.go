@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/utils"
+)
+
+type CommentBody struct {
+	Body string `json:"body" example:"The spare part arrives tomorrow"`
+}
+
+// Comment is a message on the thread of a task, its body is encrypted at rest
+// like the task summary.
+type Comment struct {
+	ID        uint64    `json:"id" example:"1"`
+	TaskID    uint64    `json:"task_id" example:"1"`
+	AuthorID  uint64    `json:"author_id" example:"3"`
+	Body      string    `json:"body" example:"The spare part arrives tomorrow"`
+	CreatedAt time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2023-01-27T20:03:44Z"`
+}
+
+// CommentRevision is a body a comment had before an edit, CreatedAt is when
+// it was replaced.
+type CommentRevision struct {
+	ID        uint64    `json:"id" example:"1"`
+	CommentID uint64    `json:"comment_id" example:"1"`
+	Body      string    `json:"body" example:"The spare part arrives today"`
+	CreatedAt time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
+}
+
+func (c *Comment) Validate() error {
+	if c.Body == "" {
+		return errors.New("required body")
+	}
+	if len(c.Body) > 2500 {
+		return errors.New("body max length is 2500 characters")
+	}
+	return nil
+}
+
+func (c *Comment) Prepare() error {
+	err := utils.Encrypt(&c.Body)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	return nil
+}
+
+func (c *Comment) DecryptBody() error {
+	return utils.Decrypt(&c.Body)
+}
+
+func (c *Comment) SaveComment(db DBTX) (int64, error) {
+	res, err := db.Exec("INSERT INTO `task_comments` (`task_id`, `author_id`, `body`) VALUES (?, ?, ?);", c.TaskID, c.AuthorID, c.Body)
+	if err != nil {
+		return 0, err
+	}
+	err = c.DecryptBody()
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (c *Comment) FindCommentByID(db DBTX, cid uint64) (*Comment, error) {
+	err := db.QueryRow("SELECT id, task_id, author_id, body, created_at, updated_at FROM task_comments WHERE id = ?;", cid).Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Body, &c.CreatedAt, &c.UpdatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return &Comment{}, fmt.Errorf("comment %w", ErrNotFound)
+	case err != nil:
+		return &Comment{}, err
+	}
+	err = c.DecryptBody()
+	if err != nil {
+		return &Comment{}, err
+	}
+	return c, nil
+}
+
+// FindCommentsByTask returns the thread of a task, oldest first.
+func (c *Comment) FindCommentsByTask(db DBTX, tid uint64) (*[]Comment, error) {
+	results, err := db.Query("SELECT id, task_id, author_id, body, created_at, updated_at FROM task_comments WHERE task_id = ? ORDER BY id;", tid)
+	if err != nil {
+		return &[]Comment{}, err
+	}
+	defer results.Close()
+	comments := []Comment{}
+	for results.Next() {
+		var comment Comment
+		err = results.Scan(&comment.ID, &comment.TaskID, &comment.AuthorID, &comment.Body, &comment.CreatedAt, &comment.UpdatedAt)
+		if err != nil {
+			return &[]Comment{}, err
+		}
+		err = comment.DecryptBody()
+		if err != nil {
+			return &[]Comment{}, err
+		}
+		comments = append(comments, comment)
+	}
+	return &comments, results.Err()
+}
+
+// UpdateAComment keeps the current body as a revision and replaces it, it
+// must run in a transaction so the two writes land together.
+func (c *Comment) UpdateAComment(db DBTX, cid uint64) (*Comment, error) {
+	res, err := db.Exec("INSERT INTO `task_comment_revisions` (`comment_id`, `body`) SELECT id, body FROM task_comments WHERE id = ?;", cid)
+	if err != nil {
+		return &Comment{}, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return &Comment{}, err
+	}
+	if count == 0 {
+		return &Comment{}, fmt.Errorf("comment %w", ErrNotFound)
+	}
+	_, err = db.Exec("UPDATE task_comments SET body = ?, updated_at = ? WHERE id = ?;", c.Body, time.Now(), cid)
+	if err != nil {
+		return &Comment{}, err
+	}
+	updated := Comment{}
+	return updated.FindCommentByID(db, cid)
+}
+
+func (c *Comment) DeleteAComment(db DBTX, cid uint64) (int64, error) {
+	res, err := db.Exec("DELETE FROM `task_comments` WHERE id = ?;", cid)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FindCommentRevisions returns the previous bodies of a comment, oldest
+// first.
+func (c *Comment) FindCommentRevisions(db DBTX, cid uint64) (*[]CommentRevision, error) {
+	results, err := db.Query("SELECT id, comment_id, body, created_at FROM task_comment_revisions WHERE comment_id = ? ORDER BY id;", cid)
+	if err != nil {
+		return &[]CommentRevision{}, err
+	}
+	defer results.Close()
+	revisions := []CommentRevision{}
+	for results.Next() {
+		var revision CommentRevision
+		err = results.Scan(&revision.ID, &revision.CommentID, &revision.Body, &revision.CreatedAt)
+		if err != nil {
+			return &[]CommentRevision{}, err
+		}
+		err = utils.Decrypt(&revision.Body)
+		if err != nil {
+			return &[]CommentRevision{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return &revisions, results.Err()
+}
+
+// ReencryptComments rewrites the comment bodies like ReencryptSummaries.
+func ReencryptComments(db DBTX, afterID uint64, limit int) (uint64, int, error) {
+	return reencryptColumn(db, "task_comments", "body", afterID, limit)
+}
+
+// ReencryptCommentRevisions rewrites the previous comment bodies like
+// ReencryptSummaries.
+func ReencryptCommentRevisions(db DBTX, afterID uint64, limit int) (uint64, int, error) {
+	return reencryptColumn(db, "task_comment_revisions", "body", afterID, limit)
+}
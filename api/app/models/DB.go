@@ -0,0 +1,18 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNotFound is wrapped by the lookups of a missing row, their message names
+// what was missing, like "user not found".
+var ErrNotFound = errors.New("not found")
+
+// DBTX is implemented by both *sql.DB and *sql.Tx so lookups can run inside
+// or outside of a transaction.
+type DBTX interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
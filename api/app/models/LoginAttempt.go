@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttempt counts the failed logins of an account or a client address,
+// Subject is a hash so the emails tried are not stored.
+type LoginAttempt struct {
+	Subject       string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// FindLoginAttempt returns the failures of subject, none when it never
+// failed.
+func FindLoginAttempt(db DBTX, subject string) (*LoginAttempt, error) {
+	attempt := LoginAttempt{Subject: subject}
+	var lockedUntil sql.NullTime
+	err := db.QueryRow("SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE subject = ?;", subject).Scan(&attempt.Failures, &attempt.LastFailureAt, &lockedUntil)
+	switch {
+	case err == sql.ErrNoRows:
+		return &attempt, nil
+	case err != nil:
+		return &LoginAttempt{}, err
+	}
+	attempt.LockedUntil = nullTime(lockedUntil)
+	return &attempt, nil
+}
+
+// RecordLoginFailure counts a failure in one statement so concurrent logins
+// cannot lose one, the count starts over when the previous failure is older
+// than window or the last lockout is over.
+func RecordLoginFailure(db DBTX, subject string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	_, err := db.Exec("INSERT INTO `login_attempts` (`subject`, `failures`, `last_failure_at`) VALUES (?, 1, ?) "+
+		"ON DUPLICATE KEY UPDATE "+
+		"`failures` = IF(`last_failure_at` < ? OR `locked_until` <= ?, 1, `failures` + 1), "+
+		"`locked_until` = IF(`locked_until` <= ?, NULL, `locked_until`), "+
+		"`last_failure_at` = VALUES(`last_failure_at`);",
+		subject, now, now.Add(-window), now, now)
+	if err != nil {
+		return &LoginAttempt{}, err
+	}
+	return FindLoginAttempt(db, subject)
+}
+
+// LockLogin locks subject until the given time and reports whether this call
+// locked it, so a lockout is only announced once.
+func LockLogin(db DBTX, subject string, now time.Time, until time.Time) (bool, error) {
+	res, err := db.Exec("UPDATE login_attempts SET locked_until = ? WHERE subject = ? AND (locked_until IS NULL OR locked_until <= ?);", until, subject, now)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteLoginAttempt forgets the failures of subject, after a successful
+// login or when a manager unlocks the account.
+func DeleteLoginAttempt(db DBTX, subject string) error {
+	_, err := db.Exec("DELETE FROM `login_attempts` WHERE subject = ?;", subject)
+	return err
+}
+
+// LockedFor is how long the subject stays locked.
+func (a *LoginAttempt) LockedFor(now time.Time) time.Duration {
+	if a.LockedUntil == nil || !a.LockedUntil.After(now) {
+		return 0
+	}
+	return a.LockedUntil.Sub(now)
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// OutboxMessage is a message waiting to be published to the worker. It is
+// written in the same transaction as the change it describes, so a message
+// exists if and only if that change was committed. RequestID is the request
+// that wrote it and Traceparent the span it was written in, they are published
+// along so the worker logs and spans can be tied to that request.
+type OutboxMessage struct {
+	ID          uint64
+	Controller  string
+	Payload     map[string]interface{}
+	RequestID   string
+	Traceparent string
+	CreatedAt   time.Time
+	SentAt      sql.NullTime
+}
+
+func SaveOutboxMessages(tx DBTX, messages []map[string]interface{}, controller string, requestID string, traceparent string) error {
+	for _, message := range messages {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO `outbox` (`controller`, `payload`, `request_id`, `traceparent`) VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''));", controller, string(payload), requestID, traceparent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindPendingOutboxMessages locks the oldest unsent messages until tx ends so
+// concurrent relays do not publish the same rows.
+func FindPendingOutboxMessages(tx DBTX, limit int) (*[]OutboxMessage, error) {
+	messages := []OutboxMessage{}
+
+	results, err := tx.Query("SELECT id, controller, payload, COALESCE(request_id, ''), COALESCE(traceparent, ''), created_at FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT ? FOR UPDATE;", limit)
+	if err != nil {
+		return &[]OutboxMessage{}, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var message OutboxMessage
+		var payload string
+		err = results.Scan(&message.ID, &message.Controller, &payload, &message.RequestID, &message.Traceparent, &message.CreatedAt)
+		if err != nil {
+			return &[]OutboxMessage{}, err
+		}
+		err = json.Unmarshal([]byte(payload), &message.Payload)
+		if err != nil {
+			return &[]OutboxMessage{}, err
+		}
+		messages = append(messages, message)
+	}
+	return &messages, results.Err()
+}
+
+func MarkOutboxMessagesSent(tx DBTX, ids []uint64, sentAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{sentAt}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := tx.Exec("UPDATE outbox SET sent_at = ? WHERE id IN ("+placeholders+");", args...)
+	return err
+}
+
+// PruneOutboxMessages deletes the messages sent before the given time.
+func PruneOutboxMessages(db DBTX, before time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?;", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The purposes of single use tokens, a token is only accepted for its own.
+const (
+	PasswordResetPurpose     = "password_reset"
+	EmailVerificationPurpose = "email_verification"
+)
+
+type ForgotPasswordBody struct {
+	Email string `json:"email" example:"steve@email.com"`
+}
+
+type ResetPasswordBody struct {
+	Token    string `json:"token" example:"3q2-7wXQ0mZ8JmVh3l3P1w6m0Yk2cFQ6hX1b2VtQ9s4"`
+	Password string `json:"password" example:"password"`
+}
+
+// SingleUseToken is emailed to reset a password or to verify an email. Only
+// its sha256 hash is stored, with the email it was sent to so it cannot act
+// on a later one.
+type SingleUseToken struct {
+	ID        uint64
+	UserID    uint64
+	Purpose   string
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+// Usable reports whether the token was neither used nor expired at now.
+func (t *SingleUseToken) Usable(now time.Time) bool {
+	return !t.UsedAt.Valid && now.Before(t.ExpiresAt)
+}
+
+func (t *SingleUseToken) SaveSingleUseToken(db DBTX) error {
+	res, err := db.Exec("INSERT INTO `single_use_tokens` (`user_id`, `purpose`, `email`, `token_hash`, `expires_at`) VALUES (?, ?, ?, ?, ?);", t.UserID, t.Purpose, t.Email, t.TokenHash, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = uint64(lastInsertedId)
+	return nil
+}
+
+// FindSingleUseTokenByHash locks the token until the transaction of db ends
+// so it cannot be used twice concurrently.
+func (t *SingleUseToken) FindSingleUseTokenByHash(db DBTX, purpose, hash string) (*SingleUseToken, error) {
+	err := db.QueryRow("SELECT id, user_id, purpose, email, token_hash, expires_at, used_at, created_at FROM single_use_tokens WHERE purpose = ? AND token_hash = ? FOR UPDATE;", purpose, hash).Scan(&t.ID, &t.UserID, &t.Purpose, &t.Email, &t.TokenHash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return &SingleUseToken{}, fmt.Errorf("token %w", ErrNotFound)
+	case err != nil:
+		return &SingleUseToken{}, err
+	}
+	return t, nil
+}
+
+// RecentSingleUseToken reports whether a token of purpose was sent to the
+// user within the interval, compared with the database clock that set
+// created_at.
+func RecentSingleUseToken(db DBTX, purpose string, uid uint64, interval time.Duration) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM single_use_tokens WHERE user_id = ? AND purpose = ? AND created_at > NOW() - INTERVAL ? SECOND;", uid, purpose, int(interval.Seconds())).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// UseSingleUseTokens marks the unused tokens of purpose of the user as used.
+func UseSingleUseTokens(db DBTX, purpose string, uid uint64) error {
+	_, err := db.Exec("UPDATE single_use_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL;", time.Now(), uid, purpose)
+	return err
+}
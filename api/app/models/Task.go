@@ -3,8 +3,11 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/vitorbiten/maintenance/api/app/enums"
 	"github.com/vitorbiten/maintenance/api/app/utils"
 )
 
@@ -16,13 +19,99 @@ type Date struct {
 	Date time.Time `json:"date" example:"2023-01-27T20:03:44Z"`
 }
 
+type Status struct {
+	Status string `json:"status" example:"in_progress"`
+}
+
+type Assignees struct {
+	AssigneeIDs []uint64 `json:"assignee_ids" example:"3,4"`
+}
+
 type Task struct {
-	ID        uint64    `json:"id" example:"1"`
-	Summary   string    `json:"summary" example:"Task summary"`
-	AuthorID  uint64    `json:"author_id" example:"3"`
-	Date      time.Time `json:"date" example:"2023-01-27T20:03:44Z"`
-	CreatedAt time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
-	UpdatedAt time.Time `json:"updated_at" example:"2023-01-27T20:03:44Z"`
+	ID       uint64 `json:"id" example:"1"`
+	Summary  string `json:"summary" example:"Task summary"`
+	AuthorID uint64 `json:"author_id" example:"3"`
+	// AssigneeIDs are the technicians a manager assigned the task to.
+	AssigneeIDs []uint64  `json:"assignee_ids" example:"3,4"`
+	Status      string    `json:"status" example:"open"`
+	Date        time.Time `json:"date" example:"2023-01-27T20:03:44Z"`
+	CreatedAt   time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
+	UpdatedAt   time.Time `json:"updated_at" example:"2023-01-27T20:03:44Z"`
+}
+
+func (t *Task) AssignedTo(uid uint64) bool {
+	for _, assignee := range t.AssigneeIDs {
+		if assignee == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthoredBy reports whether the user wrote the task.
+func (t *Task) AuthoredBy(uid uint64) bool {
+	return t.AuthorID == uid
+}
+
+// Involves reports whether the user authored the task or is assigned to it.
+func (t *Task) Involves(uid uint64) bool {
+	return t.AuthoredBy(uid) || t.AssignedTo(uid)
+}
+
+// taskTransitions maps a current status to the statuses it can move to and
+// the role allowed to perform each move. Technicians may only move their own
+// tasks forward, managers may only reopen or cancel them.
+var taskTransitions = map[string]map[string]string{
+	enums.OPEN: {
+		enums.IN_PROGRESS: enums.TECHNICIAN,
+		enums.CANCELLED:   enums.MANAGER,
+	},
+	enums.IN_PROGRESS: {
+		enums.BLOCKED:   enums.TECHNICIAN,
+		enums.DONE:      enums.TECHNICIAN,
+		enums.CANCELLED: enums.MANAGER,
+	},
+	enums.BLOCKED: {
+		enums.IN_PROGRESS: enums.TECHNICIAN,
+		enums.CANCELLED:   enums.MANAGER,
+	},
+	enums.DONE: {
+		enums.OPEN: enums.MANAGER,
+	},
+	enums.CANCELLED: {
+		enums.OPEN: enums.MANAGER,
+	},
+}
+
+var (
+	// ErrForbiddenTransition is a move the role of the user or their relation
+	// to the task does not allow.
+	ErrForbiddenTransition = errors.New("forbidden")
+	// ErrStaleStatus is a status update of a task whose status changed since
+	// it was read.
+	ErrStaleStatus = errors.New("task status changed concurrently")
+)
+
+func ValidStatus(status string) bool {
+	_, ok := taskTransitions[status]
+	return ok
+}
+
+func (t *Task) CanTransition(to string, user *User) error {
+	if !ValidStatus(to) {
+		return errors.New("invalid status")
+	}
+	role, ok := taskTransitions[t.Status][to]
+	if !ok {
+		return errors.New("invalid status transition")
+	}
+	if role != user.UserType {
+		return ErrForbiddenTransition
+	}
+	if role == enums.TECHNICIAN && !t.Involves(user.ID) {
+		return ErrForbiddenTransition
+	}
+	return nil
 }
 
 func (t *Task) Validate() error {
@@ -61,8 +150,8 @@ func (t *Task) DecryptSummary() error {
 }
 
 func (t *Task) DecryptSummaries(tasks *[]Task) error {
-	for _, task := range *tasks {
-		err := utils.Decrypt(&task.Summary)
+	for i := range *tasks {
+		err := (*tasks)[i].DecryptSummary()
 		if err != nil {
 			return err
 		}
@@ -70,8 +159,11 @@ func (t *Task) DecryptSummaries(tasks *[]Task) error {
 	return nil
 }
 
-func (t *Task) SaveTask(tx *sql.Tx) (int64, error) {
-	res, err := tx.Exec("INSERT INTO `tasks` (`summary`, `date`, `author_id`) VALUES (?, ?, ?);", &t.Summary, &t.Date, &t.AuthorID)
+func (t *Task) SaveTask(tx DBTX) (int64, error) {
+	if t.Status == "" {
+		t.Status = enums.OPEN
+	}
+	res, err := tx.Exec("INSERT INTO `tasks` (`summary`, `date`, `author_id`, `status`) VALUES (?, ?, ?, ?);", &t.Summary, &t.Date, &t.AuthorID, &t.Status)
 	if err != nil {
 		return 0, err
 	}
@@ -83,65 +175,120 @@ func (t *Task) SaveTask(tx *sql.Tx) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	for _, uid := range t.AssigneeIDs {
+		_, err = tx.Exec("INSERT INTO `task_assignments` (`task_id`, `user_id`) VALUES (?, ?);", lastInsertedId, uid)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = indexSummary(tx, uint64(lastInsertedId), t.Summary)
+	if err != nil {
+		return 0, err
+	}
 	return lastInsertedId, nil
 }
 
-func (t *Task) FindAllTasks(db *sql.DB) (*[]Task, error) {
-	tasks := []Task{}
-
-	results, err := db.Query("SELECT id, summary, date, author_id, created_at, updated_at FROM tasks;")
-	if err != nil {
-		return &[]Task{}, err
+func (t *Task) FindTasks(db DBTX, filter *TaskFilter) (*TaskPage, error) {
+	column := taskSortColumns[filter.Sort]
+	comparison, direction := ">", "ASC"
+	if filter.Order == "desc" {
+		comparison, direction = "<", "DESC"
 	}
 
-	for results.Next() {
-		var task Task
-		err = results.Scan(&task.ID, &task.Summary, &task.Date, &task.AuthorID, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return &[]Task{}, err
+	query := "SELECT id, summary, date, author_id, status, created_at, updated_at FROM tasks WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.AuthorID != 0 {
+		query += " AND author_id = ?"
+		args = append(args, filter.AuthorID)
+	}
+	if filter.AssigneeID != 0 {
+		query += " AND id IN (SELECT task_id FROM task_assignments WHERE user_id = ?)"
+		args = append(args, filter.AssigneeID)
+	}
+	if filter.InvolvedID != 0 {
+		query += " AND (author_id = ? OR id IN (SELECT task_id FROM task_assignments WHERE user_id = ?))"
+		args = append(args, filter.InvolvedID, filter.InvolvedID)
+	}
+	for _, r := range []struct {
+		column string
+		from   *time.Time
+		to     *time.Time
+	}{
+		{"date", filter.DateFrom, filter.DateTo},
+		{"created_at", filter.CreatedFrom, filter.CreatedTo},
+		{"updated_at", filter.UpdatedFrom, filter.UpdatedTo},
+	} {
+		if r.from != nil {
+			query += fmt.Sprintf(" AND %s >= ?", r.column)
+			args = append(args, *r.from)
+		}
+		if r.to != nil {
+			query += fmt.Sprintf(" AND %s <= ?", r.column)
+			args = append(args, *r.to)
 		}
-		tasks = append(tasks, task)
 	}
-
-	err = t.DecryptSummaries(&tasks)
-	if err != nil {
-		return &[]Task{}, err
+	if filter.cursor != nil {
+		if column == "id" {
+			query += fmt.Sprintf(" AND id %s ?", comparison)
+			args = append(args, filter.cursor.ID)
+		} else {
+			query += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison)
+			args = append(args, filter.cursor.Value, filter.cursor.Value, filter.cursor.ID)
+		}
 	}
-	return &tasks, nil
-}
-
-func (t *Task) FindTasksByAuthorID(db *sql.DB, tid uint64) (*[]Task, error) {
-	tasks := []Task{}
+	if column == "id" {
+		query += fmt.Sprintf(" ORDER BY id %s", direction)
+	} else {
+		query += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s", column, direction)
+	}
+	query += " LIMIT ?;"
+	args = append(args, filter.Limit+1)
 
-	results, err := db.Query("SELECT id, summary, date, author_id, created_at, updated_at FROM tasks WHERE author_id = ?;", tid)
+	results, err := db.Query(query, args...)
 	if err != nil {
-		return &[]Task{}, err
+		return &TaskPage{}, err
 	}
+	defer results.Close()
 
+	tasks := []Task{}
 	for results.Next() {
 		var task Task
-		err = results.Scan(&task.ID, &task.Summary, &task.Date, &task.AuthorID, &task.CreatedAt, &task.UpdatedAt)
+		err = results.Scan(&task.ID, &task.Summary, &task.Date, &task.AuthorID, &task.Status, &task.CreatedAt, &task.UpdatedAt)
 		if err != nil {
-			return &[]Task{}, err
+			return &TaskPage{}, err
 		}
 		tasks = append(tasks, task)
 	}
+	if err = results.Err(); err != nil {
+		return &TaskPage{}, err
+	}
 
-	err = t.DecryptSummaries(&tasks)
+	page := filter.Paginate(tasks)
+	err = loadAssignees(db, page.Tasks)
 	if err != nil {
-		return &[]Task{}, err
+		return &TaskPage{}, err
 	}
-	return &tasks, nil
+	err = t.DecryptSummaries(&page.Tasks)
+	if err != nil {
+		return &TaskPage{}, err
+	}
+	return page, nil
 }
 
-func (t *Task) FindTaskByID(db *sql.DB, tid uint64) (*Task, error) {
-	err := db.QueryRow("SELECT id, summary, date, author_id, created_at, updated_at FROM tasks WHERE id = ?;", tid).Scan(&t.ID, &t.Summary, &t.Date, &t.AuthorID, &t.CreatedAt, &t.UpdatedAt)
+func (t *Task) FindTaskByID(db DBTX, tid uint64) (*Task, error) {
+	err := db.QueryRow("SELECT id, summary, date, author_id, status, created_at, updated_at FROM tasks WHERE id = ?;", tid).Scan(&t.ID, &t.Summary, &t.Date, &t.AuthorID, &t.Status, &t.CreatedAt, &t.UpdatedAt)
 	switch {
 	case err == sql.ErrNoRows:
-		return &Task{}, errors.New("task not found")
+		return &Task{}, fmt.Errorf("task %w", ErrNotFound)
 	case err != nil:
 		return &Task{}, err
 	}
+	tasks := []Task{*t}
+	err = loadAssignees(db, tasks)
+	if err != nil {
+		return &Task{}, err
+	}
+	t.AssigneeIDs = tasks[0].AssigneeIDs
 	err = t.DecryptSummary()
 	if err != nil {
 		return &Task{}, err
@@ -149,7 +296,7 @@ func (t *Task) FindTaskByID(db *sql.DB, tid uint64) (*Task, error) {
 	return t, err
 }
 
-func (t *Task) UpdateATask(db *sql.DB, tid uint64) (*Task, error) {
+func (t *Task) UpdateATask(db DBTX, tid uint64) (*Task, error) {
 	res, err := db.Exec("UPDATE tasks SET summary = ?, date = ?, updated_at = ? WHERE id = ?;", &t.Summary, &t.Date, time.Now(), tid)
 	if err != nil {
 		return &Task{}, err
@@ -163,12 +310,112 @@ func (t *Task) UpdateATask(db *sql.DB, tid uint64) (*Task, error) {
 		if err != nil {
 			return &Task{}, err
 		}
+		err = indexSummary(db, tid, t.Summary)
+		if err != nil {
+			return &Task{}, err
+		}
 		return t, nil
 	}
-	return &Task{}, nil
+	return &Task{}, fmt.Errorf("task %w", ErrNotFound)
 }
 
-func (t *Task) DeleteATask(db *sql.DB, tid uint64) (int64, error) {
+func (t *Task) UpdateStatus(tx DBTX, tid uint64, to string) (*Task, error) {
+	now := time.Now()
+	res, err := tx.Exec("UPDATE tasks SET status = ?, updated_at = ? WHERE id = ? AND status = ?;", to, now, tid, t.Status)
+	if err != nil {
+		return &Task{}, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return &Task{}, err
+	}
+	if count == 0 {
+		return &Task{}, ErrStaleStatus
+	}
+	t.Status = to
+	t.UpdatedAt = now
+	return t, nil
+}
+
+// loadAssignees fills the AssigneeIDs of tasks, in ascending order.
+func loadAssignees(db DBTX, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	index := map[uint64]int{}
+	args := []interface{}{}
+	for i := range tasks {
+		tasks[i].AssigneeIDs = []uint64{}
+		index[tasks[i].ID] = i
+		args = append(args, tasks[i].ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tasks)), ", ")
+	results, err := db.Query("SELECT task_id, user_id FROM task_assignments WHERE task_id IN ("+placeholders+") ORDER BY task_id, user_id;", args...)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for results.Next() {
+		var tid, uid uint64
+		err = results.Scan(&tid, &uid)
+		if err != nil {
+			return err
+		}
+		i := index[tid]
+		tasks[i].AssigneeIDs = append(tasks[i].AssigneeIDs, uid)
+	}
+	return results.Err()
+}
+
+// SetAssignees replaces the assignees of the task with uids and returns the
+// ones that were not assigned before.
+func (t *Task) SetAssignees(db DBTX, tid uint64, uids []uint64) ([]uint64, error) {
+	tasks := []Task{{ID: tid}}
+	err := loadAssignees(db, tasks)
+	if err != nil {
+		return nil, err
+	}
+	current := map[uint64]bool{}
+	for _, uid := range tasks[0].AssigneeIDs {
+		current[uid] = true
+	}
+	wanted := map[uint64]bool{}
+	added := []uint64{}
+	for _, uid := range uids {
+		if wanted[uid] {
+			continue
+		}
+		wanted[uid] = true
+		if current[uid] {
+			continue
+		}
+		_, err = db.Exec("INSERT INTO `task_assignments` (`task_id`, `user_id`) VALUES (?, ?);", tid, uid)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, uid)
+	}
+	for uid := range current {
+		if wanted[uid] {
+			continue
+		}
+		_, err = db.Exec("DELETE FROM `task_assignments` WHERE task_id = ? AND user_id = ?;", tid, uid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+func (t *Task) DeleteAssignee(db DBTX, tid uint64, uid uint64) (int64, error) {
+	res, err := db.Exec("DELETE FROM `task_assignments` WHERE task_id = ? AND user_id = ?;", tid, uid)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (t *Task) DeleteATask(db DBTX, tid uint64) (int64, error) {
 	res, err := db.Exec("DELETE FROM `tasks` WHERE id = ?;", tid)
 	if err != nil {
 		return 0, err
@@ -182,3 +429,69 @@ func (t *Task) DeleteATask(db *sql.DB, tid uint64) (int64, error) {
 	}
 	return 0, nil
 }
+
+// ReencryptSummaries rewrites, under the active key, the summaries of up to
+// limit tasks with an id above afterID. It returns the last id it read, 0 when
+// there are no tasks left, and how many summaries were rewritten. A summary
+// changed since it was read is left for the next run.
+func ReencryptSummaries(db DBTX, afterID uint64, limit int) (uint64, int, error) {
+	return reencryptColumn(db, "tasks", "summary", afterID, limit)
+}
+
+// reencryptColumn rewrites the stale ciphertexts of column in up to limit rows
+// of table with an id above afterID, table and column are never user input.
+func reencryptColumn(db DBTX, table, column string, afterID uint64, limit int) (uint64, int, error) {
+	keyring, err := utils.CurrentKeyring()
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE id > ? ORDER BY id LIMIT ?;", column, table), afterID, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	type row struct {
+		id         uint64
+		ciphertext string
+	}
+	read := []row{}
+	for rows.Next() {
+		r := row{}
+		err = rows.Scan(&r.id, &r.ciphertext)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		read = append(read, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(read) == 0 {
+		return 0, 0, nil
+	}
+	rewritten := 0
+	for _, r := range read {
+		if !keyring.Stale(r.ciphertext) {
+			continue
+		}
+		plainText, err := keyring.Open(r.ciphertext)
+		if err != nil {
+			return 0, rewritten, fmt.Errorf("%s %d: %w", table, r.id, err)
+		}
+		sealed, err := keyring.Seal(plainText)
+		if err != nil {
+			return 0, rewritten, err
+		}
+		res, err := db.Exec(fmt.Sprintf("UPDATE %[1]s SET %[2]s = ? WHERE id = ? AND %[2]s = ?;", table, column), sealed, r.id, r.ciphertext)
+		if err != nil {
+			return 0, rewritten, err
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return 0, rewritten, err
+		}
+		rewritten += int(count)
+	}
+	return read[len(read)-1].id, rewritten, nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultTasksLimit = 20
+	MaxTasksLimit     = 100
+)
+
+var taskSortColumns = map[string]string{
+	"id":         "id",
+	"date":       "date",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+type TaskPage struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor" example:"eyJ2IjoiMjAyMy0wMS0yN1QyMDowMzo0NFoiLCJpZCI6MX0"`
+}
+
+type TaskFilter struct {
+	Limit      int
+	Cursor     string
+	AuthorID   uint64
+	AssigneeID uint64
+	// InvolvedID keeps the tasks the user authored or is assigned to.
+	InvolvedID  uint64
+	DateFrom    *time.Time
+	DateTo      *time.Time
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	UpdatedFrom *time.Time
+	UpdatedTo   *time.Time
+	Sort        string
+	Order       string
+
+	cursor *taskCursor
+}
+
+// taskCursor is the position of the last task of a page, encoded as opaque
+// base64 JSON so the next page continues after it on (sort column, id).
+type taskCursor struct {
+	Sort  string    `json:"s"`
+	Order string    `json:"o"`
+	Value time.Time `json:"v"`
+	ID    uint64    `json:"id"`
+}
+
+func (f *TaskFilter) Prepare() {
+	if f.Limit == 0 {
+		f.Limit = DefaultTasksLimit
+	}
+	if f.Sort == "" {
+		f.Sort = "id"
+	}
+	if f.Order == "" {
+		f.Order = "asc"
+	}
+}
+
+func (f *TaskFilter) Validate() error {
+	if f.Limit < 1 || f.Limit > MaxTasksLimit {
+		return errors.New("limit must be between 1 and 100")
+	}
+	if _, ok := taskSortColumns[f.Sort]; !ok {
+		return errors.New("invalid sort")
+	}
+	if f.Order != "asc" && f.Order != "desc" {
+		return errors.New("invalid order")
+	}
+	if f.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+		if err != nil {
+			return errors.New("invalid cursor")
+		}
+		cursor := taskCursor{}
+		err = json.Unmarshal(data, &cursor)
+		if err != nil {
+			return errors.New("invalid cursor")
+		}
+		if cursor.Sort != f.Sort || cursor.Order != f.Order {
+			return errors.New("cursor does not match sort order")
+		}
+		f.cursor = &cursor
+	}
+	return nil
+}
+
+func (f *TaskFilter) nextCursor(last *Task) string {
+	cursor := taskCursor{Sort: f.Sort, Order: f.Order, ID: last.ID}
+	switch f.Sort {
+	case "date":
+		cursor.Value = last.Date
+	case "created_at":
+		cursor.Value = last.CreatedAt
+	case "updated_at":
+		cursor.Value = last.UpdatedAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (f *TaskFilter) sortValue(t *Task) time.Time {
+	switch f.Sort {
+	case "date":
+		return t.Date
+	case "created_at":
+		return t.CreatedAt
+	case "updated_at":
+		return t.UpdatedAt
+	}
+	return time.Time{}
+}
+
+// Match reports whether t passes the filter and comes after the cursor, it
+// mirrors the WHERE clause of FindTasks for stores that filter in memory.
+func (f *TaskFilter) Match(t *Task) bool {
+	if f.AuthorID != 0 && t.AuthorID != f.AuthorID {
+		return false
+	}
+	if f.AssigneeID != 0 && !t.AssignedTo(f.AssigneeID) {
+		return false
+	}
+	if f.InvolvedID != 0 && !t.Involves(f.InvolvedID) {
+		return false
+	}
+	for _, r := range []struct {
+		value time.Time
+		from  *time.Time
+		to    *time.Time
+	}{
+		{t.Date, f.DateFrom, f.DateTo},
+		{t.CreatedAt, f.CreatedFrom, f.CreatedTo},
+		{t.UpdatedAt, f.UpdatedFrom, f.UpdatedTo},
+	} {
+		if r.from != nil && r.value.Before(*r.from) {
+			return false
+		}
+		if r.to != nil && r.value.After(*r.to) {
+			return false
+		}
+	}
+	if f.cursor == nil {
+		return true
+	}
+	cursor := &Task{ID: f.cursor.ID, Date: f.cursor.Value, CreatedAt: f.cursor.Value, UpdatedAt: f.cursor.Value}
+	return f.Less(cursor, t)
+}
+
+// Less orders tasks like the ORDER BY clause of FindTasks.
+func (f *TaskFilter) Less(a, b *Task) bool {
+	if f.Order == "desc" {
+		a, b = b, a
+	}
+	if f.Sort != "id" {
+		va, vb := f.sortValue(a), f.sortValue(b)
+		if !va.Equal(vb) {
+			return va.Before(vb)
+		}
+	}
+	return a.ID < b.ID
+}
+
+// Paginate trims filtered and ordered tasks, fetched with one extra row, to
+// the limit and sets the cursor of the next page when there is one.
+func (f *TaskFilter) Paginate(tasks []Task) *TaskPage {
+	page := TaskPage{}
+	if len(tasks) > f.Limit {
+		tasks = tasks[:f.Limit]
+		page.NextCursor = f.nextCursor(&tasks[len(tasks)-1])
+	}
+	page.Tasks = tasks
+	return &page
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vitorbiten/maintenance/api/app/utils"
+)
+
+// MaxSearchWords caps the words of a query, each one is a token to look up.
+const MaxSearchWords = 10
+
+type TaskSearchResults struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// TaskSearch looks up tasks by the blind index tokens of the words of Query,
+// ranked by how many of the words they contain and then by how often.
+type TaskSearch struct {
+	Query string
+	Limit int
+	// InvolvedID keeps the tasks the user authored or is assigned to.
+	InvolvedID uint64
+
+	tokens []string
+}
+
+func (s *TaskSearch) Prepare() {
+	s.Query = strings.TrimSpace(s.Query)
+	if s.Limit == 0 {
+		s.Limit = DefaultTasksLimit
+	}
+}
+
+func (s *TaskSearch) Validate() error {
+	if s.Query == "" {
+		return errors.New("required q")
+	}
+	if s.Limit < 1 || s.Limit > MaxTasksLimit {
+		return errors.New("limit must be between 1 and 100")
+	}
+	words := utils.Words(s.Query)
+	if len(words) == 0 {
+		return fmt.Errorf("q needs a word with at least %d letters", utils.MinWordLength)
+	}
+	if len(words) > MaxSearchWords {
+		return fmt.Errorf("q can have at most %d words", MaxSearchWords)
+	}
+	index, err := utils.CurrentBlindIndex()
+	if err != nil {
+		return err
+	}
+	s.tokens = []string{}
+	for word := range words {
+		s.tokens = append(s.tokens, index.Token(word))
+	}
+	sort.Strings(s.tokens)
+	return nil
+}
+
+// TaskScore is how a task matched a search.
+type TaskScore struct {
+	Matched int
+	Hits    int
+}
+
+// Score matches the tokens of a plaintext summary against the query, for
+// stores that search in memory.
+func (s *TaskSearch) Score(tokens map[string]int) TaskScore {
+	score := TaskScore{}
+	for _, token := range s.tokens {
+		if hits, ok := tokens[token]; ok {
+			score.Matched++
+			score.Hits += hits
+		}
+	}
+	return score
+}
+
+// RankedBefore orders scored tasks like the ORDER BY clause of SearchTasks.
+func RankedBefore(a TaskScore, aID uint64, b TaskScore, bID uint64) bool {
+	if a.Matched != b.Matched {
+		return a.Matched > b.Matched
+	}
+	if a.Hits != b.Hits {
+		return a.Hits > b.Hits
+	}
+	return aID > bID
+}
+
+// indexSummary replaces the blind index tokens of a task with the ones of its
+// plaintext summary.
+func indexSummary(db DBTX, tid uint64, summary string) error {
+	index, err := utils.CurrentBlindIndex()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM `task_search_tokens` WHERE task_id = ?;", tid)
+	if err != nil {
+		return err
+	}
+	tokens := index.Tokens(summary)
+	if len(tokens) == 0 {
+		return nil
+	}
+	args := []interface{}{}
+	for token, hits := range tokens {
+		args = append(args, token, tid, hits)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(tokens)), ", ")
+	_, err = db.Exec("INSERT INTO `task_search_tokens` (`token`, `task_id`, `hits`) VALUES "+placeholders+";", args...)
+	return err
+}
+
+func (t *Task) SearchTasks(db DBTX, search *TaskSearch) (*TaskSearchResults, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(search.tokens)), ", ")
+	query := "SELECT t.id, t.summary, t.date, t.author_id, t.status, t.created_at, t.updated_at FROM task_search_tokens s JOIN tasks t ON t.id = s.task_id WHERE s.token IN (" + placeholders + ")"
+	args := []interface{}{}
+	for _, token := range search.tokens {
+		args = append(args, token)
+	}
+	if search.InvolvedID != 0 {
+		query += " AND (t.author_id = ? OR t.id IN (SELECT task_id FROM task_assignments WHERE user_id = ?))"
+		args = append(args, search.InvolvedID, search.InvolvedID)
+	}
+	query += " GROUP BY t.id ORDER BY COUNT(*) DESC, SUM(s.hits) DESC, t.id DESC LIMIT ?;"
+	args = append(args, search.Limit)
+
+	results, err := db.Query(query, args...)
+	if err != nil {
+		return &TaskSearchResults{}, err
+	}
+	defer results.Close()
+
+	tasks := []Task{}
+	for results.Next() {
+		var task Task
+		err = results.Scan(&task.ID, &task.Summary, &task.Date, &task.AuthorID, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+		if err != nil {
+			return &TaskSearchResults{}, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err = results.Err(); err != nil {
+		return &TaskSearchResults{}, err
+	}
+	err = loadAssignees(db, tasks)
+	if err != nil {
+		return &TaskSearchResults{}, err
+	}
+	err = t.DecryptSummaries(&tasks)
+	if err != nil {
+		return &TaskSearchResults{}, err
+	}
+	return &TaskSearchResults{Tasks: tasks}, nil
+}
+
+// ReindexSummaries rebuilds the blind index tokens of up to limit tasks with
+// an id above afterID, each under a lock on the task so a concurrent update
+// wins. It returns the last id it read, 0 when there are no tasks left.
+func ReindexSummaries(db *sql.DB, afterID uint64, limit int) (uint64, int, error) {
+	rows, err := db.Query("SELECT id FROM tasks WHERE id > ? ORDER BY id LIMIT ?;", afterID, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	ids := []uint64{}
+	for rows.Next() {
+		var id uint64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	reindexed := 0
+	for _, id := range ids {
+		err = reindexSummary(db, id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, reindexed, fmt.Errorf("task %d: %w", id, err)
+		}
+		reindexed++
+	}
+	return ids[len(ids)-1], reindexed, nil
+}
+
+func reindexSummary(db *sql.DB, tid uint64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var summary string
+	err = tx.QueryRow("SELECT summary FROM tasks WHERE id = ? FOR UPDATE;", tid).Scan(&summary)
+	if err != nil {
+		return err
+	}
+	err = utils.Decrypt(&summary)
+	if err != nil {
+		return err
+	}
+	err = indexSummary(tx, tid, summary)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type RefreshTokenBody struct {
+	RefreshToken string `json:"refresh_token" example:"3q2-7wXQ0mZ8JmVh3l3P1w6m0Yk2cFQ6hX1b2VtQ9s4"`
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"3q2-7wXQ0mZ8JmVh3l3P1w6m0Yk2cFQ6hX1b2VtQ9s4"`
+}
+
+// RefreshToken is the server side record of an issued refresh token. Only the
+// sha256 hash of the token is stored, tokens rotated from the same login
+// share a FamilyID so a reused token can revoke the whole chain.
+type RefreshToken struct {
+	ID        uint64
+	UserID    uint64
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+	CreatedAt time.Time
+}
+
+func (r *RefreshToken) SaveRefreshToken(tx DBTX) error {
+	res, err := tx.Exec("INSERT INTO `refresh_tokens` (`user_id`, `family_id`, `token_hash`, `expires_at`) VALUES (?, ?, ?, ?);", r.UserID, r.FamilyID, r.TokenHash, r.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ID = uint64(lastInsertedId)
+	return nil
+}
+
+// FindRefreshTokenByHash locks the token until tx ends so it cannot be
+// rotated twice concurrently.
+func (r *RefreshToken) FindRefreshTokenByHash(tx DBTX, hash string) (*RefreshToken, error) {
+	err := tx.QueryRow("SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, created_at FROM refresh_tokens WHERE token_hash = ? FOR UPDATE;", hash).Scan(&r.ID, &r.UserID, &r.FamilyID, &r.TokenHash, &r.ExpiresAt, &r.RevokedAt, &r.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return &RefreshToken{}, fmt.Errorf("refresh token %w", ErrNotFound)
+	case err != nil:
+		return &RefreshToken{}, err
+	}
+	return r, nil
+}
+
+func RevokeRefreshToken(db DBTX, id uint64) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL;", time.Now(), id)
+	return err
+}
+
+func RevokeRefreshTokenFamily(db DBTX, familyID string) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL;", time.Now(), familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to the user.
+func RevokeUserRefreshTokens(db DBTX, uid uint64) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL;", time.Now(), uid)
+	return err
+}
+
+func RevokeToken(db DBTX, jti string, expiresAt time.Time) error {
+	_, err := db.Exec("INSERT IGNORE INTO `revoked_tokens` (`jti`, `expires_at`) VALUES (?, ?);", jti, expiresAt)
+	return err
+}
+
+func IsTokenRevoked(db DBTX, jti string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?;", jti).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
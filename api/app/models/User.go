@@ -4,8 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html"
-	"log"
 	"strings"
 	"time"
 
@@ -26,14 +26,35 @@ type Password struct {
 	Password string `json:"password" example:"password"`
 }
 
+type Role struct {
+	UserType string `json:"user_type" example:"manager"`
+}
+
 type User struct {
-	ID        uint64    `json:"id" example:"1"`
-	Nickname  string    `json:"nickname" example:"Steve"`
-	Email     string    `json:"email"  example:"steve@email.com"`
-	UserType  string    `json:"user_type"  example:"technician"`
-	Password  string    `json:"password,omitempty" example:"password"`
-	CreatedAt time.Time `json:"created_at" example:"2023-01-27T20:03:44Z"`
-	UpdatedAt time.Time `json:"updated_at" example:"2023-01-27T20:03:44Z"`
+	ID       uint64 `json:"id" example:"1"`
+	Nickname string `json:"nickname" example:"Steve"`
+	Email    string `json:"email"  example:"steve@email.com"`
+	UserType string `json:"user_type"  example:"technician"`
+	Password string `json:"password,omitempty" example:"password"`
+	// DeactivatedAt is set while the account is deactivated, deactivated
+	// users cannot log in and their tokens are rejected.
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" example:"2023-01-27T20:03:44Z"`
+	// VerifiedAt is set once the owner confirmed the email, unverified users
+	// cannot create tasks.
+	VerifiedAt *time.Time `json:"verified_at,omitempty" example:"2023-01-27T20:03:44Z"`
+	// PasswordChangedAt is set by a password reset, access tokens issued
+	// before it are rejected.
+	PasswordChangedAt *time.Time `json:"-"`
+	CreatedAt         time.Time  `json:"created_at" example:"2023-01-27T20:03:44Z"`
+	UpdatedAt         time.Time  `json:"updated_at" example:"2023-01-27T20:03:44Z"`
+}
+
+func (u *User) Active() bool {
+	return u.DeactivatedAt == nil
+}
+
+func (u *User) Verified() bool {
+	return u.VerifiedAt != nil
 }
 
 func (u *User) Validate(action string) error {
@@ -67,6 +88,19 @@ func (u *User) Validate(action string) error {
 			return errors.New("invalid email")
 		}
 		return nil
+	case "email":
+		if u.Email == "" {
+			return errors.New("required email")
+		}
+		if err := checkmail.ValidateFormat(u.Email); err != nil {
+			return errors.New("invalid email")
+		}
+		return nil
+	case "role":
+		if u.UserType != enums.MANAGER && u.UserType != enums.TECHNICIAN {
+			return errors.New("invalid user type")
+		}
+		return nil
 
 	default:
 		if u.Nickname == "" {
@@ -159,33 +193,45 @@ func (u *User) RemovePasswords(users *[]User) error {
 	return nil
 }
 
-func (u *User) SaveUser(db *sql.DB) (*User, error) {
+func (u *User) SaveUser(db DBTX) (*User, error) {
 	err := u.HashPassword()
 	if err != nil {
 		return &User{}, err
 	}
 
-	_, err = db.Exec("INSERT INTO `users` (`nickname`, `email`, `password`) VALUES (?, ?, ?);", &u.Nickname, &u.Email, &u.Password)
+	if u.UserType == "" {
+		u.UserType = enums.TECHNICIAN
+	}
+	// the email starts unverified
+	u.VerifiedAt = nil
+	res, err := db.Exec("INSERT INTO `users` (`nickname`, `email`, `user_type`, `password`) VALUES (?, ?, ?, ?);", &u.Nickname, &u.Email, &u.UserType, &u.Password)
 	if err != nil {
 		return &User{}, err
 	}
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return &User{}, err
+	}
+	u.ID = uint64(lastInsertedId)
 	return u, nil
 }
 
-func (u *User) FindAllTechnicians(db *sql.DB) (*[]User, error) {
+func (u *User) FindAllTechnicians(db DBTX) (*[]User, error) {
 	users := []User{}
 
-	results, err := db.Query("SELECT id, nickname, email FROM users WHERE user_type = ?;", enums.TECHNICIAN)
+	results, err := db.Query("SELECT id, nickname, email, deactivated_at FROM users WHERE user_type = ?;", enums.TECHNICIAN)
 	if err != nil {
 		return &[]User{}, err
 	}
 
 	for results.Next() {
 		var user User
-		err = results.Scan(&user.ID, &user.Nickname, &user.Email)
+		var deactivatedAt sql.NullTime
+		err = results.Scan(&user.ID, &user.Nickname, &user.Email, &deactivatedAt)
 		if err != nil {
 			return &[]User{}, err
 		}
+		user.DeactivatedAt = nullTime(deactivatedAt)
 		users = append(users, user)
 	}
 
@@ -196,10 +242,10 @@ func (u *User) FindAllTechnicians(db *sql.DB) (*[]User, error) {
 	return &users, err
 }
 
-func (u *User) FindAllManagers(tx *sql.Tx) (*[]User, error) {
+func (u *User) FindAllManagers(tx DBTX) (*[]User, error) {
 	users := []User{}
 
-	results, err := tx.Query("SELECT id, nickname, email FROM users WHERE user_type = ?;", enums.MANAGER)
+	results, err := tx.Query("SELECT id, nickname, email FROM users WHERE user_type = ? AND deactivated_at IS NULL;", enums.MANAGER)
 	if err != nil {
 		return &[]User{}, err
 	}
@@ -220,11 +266,15 @@ func (u *User) FindAllManagers(tx *sql.Tx) (*[]User, error) {
 	return &users, err
 }
 
-func (u *User) FindUserByID(tx *sql.Tx, uid uint64) (*User, error) {
-	err := tx.QueryRow("SELECT id, nickname, email, password, user_type FROM users WHERE id = ?;", uid).Scan(&u.ID, &u.Nickname, &u.Email, &u.Password, &u.UserType)
+func (u *User) FindUserByID(tx DBTX, uid uint64) (*User, error) {
+	var deactivatedAt, verifiedAt, passwordChangedAt sql.NullTime
+	err := tx.QueryRow("SELECT id, nickname, email, password, user_type, deactivated_at, verified_at, password_changed_at FROM users WHERE id = ?;", uid).Scan(&u.ID, &u.Nickname, &u.Email, &u.Password, &u.UserType, &deactivatedAt, &verifiedAt, &passwordChangedAt)
+	u.DeactivatedAt = nullTime(deactivatedAt)
+	u.VerifiedAt = nullTime(verifiedAt)
+	u.PasswordChangedAt = nullTime(passwordChangedAt)
 	switch {
 	case err == sql.ErrNoRows:
-		return &User{}, errors.New("user not found")
+		return &User{}, fmt.Errorf("user %w", ErrNotFound)
 	case err != nil:
 		return &User{}, err
 	}
@@ -235,11 +285,13 @@ func (u *User) FindUserByID(tx *sql.Tx, uid uint64) (*User, error) {
 	return u, err
 }
 
-func (u *User) FindUserByEmail(db *sql.DB, email string) (*User, error) {
-	err := db.QueryRow("SELECT id, password FROM users WHERE email = ?;", email).Scan(&u.ID, &u.Password)
+func (u *User) FindUserByEmail(db DBTX, email string) (*User, error) {
+	var deactivatedAt sql.NullTime
+	err := db.QueryRow("SELECT id, nickname, email, password, deactivated_at FROM users WHERE email = ?;", email).Scan(&u.ID, &u.Nickname, &u.Email, &u.Password, &deactivatedAt)
+	u.DeactivatedAt = nullTime(deactivatedAt)
 	switch {
 	case err == sql.ErrNoRows:
-		return &User{}, errors.New("user not found")
+		return &User{}, fmt.Errorf("user %w", ErrNotFound)
 	case err != nil:
 		return &User{}, err
 	}
@@ -250,13 +302,17 @@ func (u *User) FindUserByEmail(db *sql.DB, email string) (*User, error) {
 	return u, err
 }
 
-func (u *User) UpdateAUser(db *sql.DB, uid uint64) (*User, error) {
+// UpdateAUser clears the verification when the email changes, the new one
+// has to be confirmed again.
+func (u *User) UpdateAUser(db DBTX, uid uint64) (*User, error) {
 	err := u.HashPassword()
 	if err != nil {
-		log.Fatal(err)
+		return &User{}, err
 	}
 
-	res, err := db.Exec("UPDATE users SET nickname = ?, email = ?, password = ?, updated_at = ? WHERE id = ?;", &u.Nickname, &u.Email, &u.Password, time.Now(), uid)
+	// MySQL assigns in order, verified_at is compared to the email before it
+	// is replaced
+	res, err := db.Exec("UPDATE users SET verified_at = IF(email = ?, verified_at, NULL), nickname = ?, email = ?, password = ?, updated_at = ? WHERE id = ?;", &u.Email, &u.Nickname, &u.Email, &u.Password, time.Now(), uid)
 	if err != nil {
 		return &User{}, err
 	}
@@ -267,10 +323,62 @@ func (u *User) UpdateAUser(db *sql.DB, uid uint64) (*User, error) {
 	if count > 0 {
 		return u, nil
 	}
-	return &User{}, errors.New("user not found")
+	return &User{}, fmt.Errorf("user %w", ErrNotFound)
+}
+
+// ResetPassword hashes Password and stores it as changed at the given time,
+// which is kept to the second like the iat of access tokens.
+func (u *User) ResetPassword(db DBTX, uid uint64, at time.Time) error {
+	err := u.HashPassword()
+	if err != nil {
+		return err
+	}
+	at = at.Truncate(time.Second)
+	res, err := db.Exec("UPDATE users SET password = ?, password_changed_at = ?, updated_at = ? WHERE id = ?;", u.Password, at, time.Now(), uid)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("user %w", ErrNotFound)
+	}
+	u.PasswordChangedAt = &at
+	return nil
+}
+
+func (u *User) UpdateRole(db DBTX, uid uint64, role string) (*User, error) {
+	_, err := db.Exec("UPDATE users SET user_type = ?, updated_at = ? WHERE id = ?;", role, time.Now(), uid)
+	if err != nil {
+		return &User{}, err
+	}
+	return u.FindUserByID(db, uid)
 }
 
-func (u *User) DeleteAUser(db *sql.DB, uid uint64) (int64, error) {
+// SetDeactivatedAt deactivates the user at the given time, nil reactivates
+// them. Like UpdateRole it reads the user back, so a missing user is reported
+// even when MySQL counts an unchanged row as unaffected.
+func (u *User) SetDeactivatedAt(db DBTX, uid uint64, at *time.Time) (*User, error) {
+	_, err := db.Exec("UPDATE users SET deactivated_at = ?, updated_at = ? WHERE id = ?;", at, time.Now(), uid)
+	if err != nil {
+		return &User{}, err
+	}
+	return u.FindUserByID(db, uid)
+}
+
+// SetVerifiedAt marks the email of the user as verified at the given time
+// unless it already was, and reads the user back.
+func (u *User) SetVerifiedAt(db DBTX, uid uint64, at time.Time) (*User, error) {
+	_, err := db.Exec("UPDATE users SET verified_at = COALESCE(verified_at, ?), updated_at = ? WHERE id = ?;", at.Truncate(time.Second), time.Now(), uid)
+	if err != nil {
+		return &User{}, err
+	}
+	return u.FindUserByID(db, uid)
+}
+
+func (u *User) DeleteAUser(db DBTX, uid uint64) (int64, error) {
 	res, err := db.Exec("DELETE FROM `users` WHERE id = ?;", uid)
 	if err != nil {
 		return 0, err
@@ -284,3 +392,10 @@ func (u *User) DeleteAUser(db *sql.DB, uid uint64) (int64, error) {
 	}
 	return 0, nil
 }
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
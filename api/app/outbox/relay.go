@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/repository"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+)
+
+const (
+	batchSize = 100
+	// pruneInterval is how often sent messages past their retention are
+	// deleted.
+	pruneInterval = time.Hour
+)
+
+func pollInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("OUTBOX_POLL_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return time.Second
+	}
+	return interval
+}
+
+// retention is how long sent messages are kept, a week by default.
+func retention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("OUTBOX_RETENTION"))
+	if err != nil || retention <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return retention
+}
+
+// Run publishes pending outbox messages of store and prunes the sent ones
+// until ctx is done.
+func Run(ctx context.Context, store repository.Store) error {
+	ticker := time.NewTicker(pollInterval())
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for {
+				sent, err := RelayOnce(store)
+				if err != nil {
+					slog.Error("outbox relay failed", "error", err)
+					break
+				}
+				if sent < batchSize {
+					break
+				}
+			}
+		case <-pruneTicker.C:
+			pruned, err := Prune(store, time.Now())
+			if err != nil {
+				slog.Error("outbox prune failed", "error", err)
+				continue
+			}
+			slog.Info("outbox pruned", "messages", pruned)
+		}
+	}
+}
+
+// RelayOnce publishes one batch of pending messages and marks the ones the
+// broker confirmed as sent. A crash between the publish and the commit
+// publishes the message again, so delivery is at-least-once.
+func RelayOnce(store repository.Store) (int, error) {
+	sent := []uint64{}
+	var publishErr error
+	err := store.Transaction(func(store repository.Store) error {
+		messages, err := store.Outbox().Pending(batchSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range *messages {
+			ctx := logging.WithRequestID(context.Background(), message.RequestID)
+			ctx = tracing.WithTraceparent(ctx, message.Traceparent)
+			publishErr = adapters.PublishMessages(ctx, []map[string]interface{}{message.Payload}, message.Controller)
+			if publishErr != nil {
+				break
+			}
+			sent = append(sent, message.ID)
+		}
+		return store.Outbox().MarkSent(sent, time.Now())
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(sent), publishErr
+}
+
+// Prune deletes the messages that were sent longer than OUTBOX_RETENTION
+// before now.
+func Prune(store repository.Store, now time.Time) (int64, error) {
+	return store.Outbox().Prune(now.Add(-retention()))
+}
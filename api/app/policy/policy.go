@@ -0,0 +1,119 @@
+package policy
+
+import "github.com/vitorbiten/maintenance/api/app/enums"
+
+type Ownership int
+
+const (
+	// Own is a resource owned by the caller: their own user, a task they
+	// authored or are assigned to, or a comment or attachment they added.
+	// Updating a task only counts its author.
+	Own Ownership = iota
+	// Others is a resource owned by someone else.
+	Others
+	// Any matches both, it is only used in rules.
+	Any
+)
+
+const (
+	USERS       = "users"
+	TASKS       = "tasks"
+	COMMENTS    = "comments"
+	ATTACHMENTS = "attachments"
+)
+
+const (
+	CREATE     = "create"
+	LIST       = "list"
+	READ       = "read"
+	UPDATE     = "update"
+	DELETE     = "delete"
+	TRANSITION = "transition"
+	ASSIGN     = "assign"
+	// ADMINISTER changes the role of a user, (de)activates them or unlocks
+	// their login.
+	ADMINISTER = "administer"
+)
+
+// Rule grants Role the Action on Resource when the ownership matches.
+// TargetRole restricts user rules to targets of that role.
+type Rule struct {
+	Role       string
+	Resource   string
+	Action     string
+	Ownership  Ownership
+	TargetRole string
+}
+
+// Rules mirrors the permissions map of the README, anything not listed is denied.
+var Rules = []Rule{
+	{Role: enums.MANAGER, Resource: USERS, Action: READ, Ownership: Own},
+	{Role: enums.MANAGER, Resource: USERS, Action: UPDATE, Ownership: Own},
+	{Role: enums.MANAGER, Resource: USERS, Action: DELETE, Ownership: Own},
+	{Role: enums.MANAGER, Resource: USERS, Action: READ, Ownership: Others, TargetRole: enums.TECHNICIAN},
+	{Role: enums.MANAGER, Resource: USERS, Action: LIST, Ownership: Any},
+	{Role: enums.MANAGER, Resource: USERS, Action: ADMINISTER, Ownership: Others},
+
+	{Role: enums.TECHNICIAN, Resource: USERS, Action: READ, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: USERS, Action: UPDATE, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: USERS, Action: DELETE, Ownership: Own},
+
+	{Role: enums.MANAGER, Resource: TASKS, Action: CREATE, Ownership: Own},
+	{Role: enums.MANAGER, Resource: TASKS, Action: LIST, Ownership: Any},
+	{Role: enums.MANAGER, Resource: TASKS, Action: READ, Ownership: Any},
+	{Role: enums.MANAGER, Resource: TASKS, Action: UPDATE, Ownership: Own},
+	{Role: enums.MANAGER, Resource: TASKS, Action: DELETE, Ownership: Any},
+	{Role: enums.MANAGER, Resource: TASKS, Action: TRANSITION, Ownership: Any},
+	{Role: enums.MANAGER, Resource: TASKS, Action: ASSIGN, Ownership: Any},
+
+	{Role: enums.TECHNICIAN, Resource: TASKS, Action: CREATE, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: TASKS, Action: LIST, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: TASKS, Action: READ, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: TASKS, Action: UPDATE, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: TASKS, Action: TRANSITION, Ownership: Own},
+
+	// Comment routes first require reading the task, which is also enough to
+	// list and add comments.
+	{Role: enums.MANAGER, Resource: COMMENTS, Action: READ, Ownership: Any},
+	{Role: enums.MANAGER, Resource: COMMENTS, Action: UPDATE, Ownership: Own},
+	{Role: enums.MANAGER, Resource: COMMENTS, Action: DELETE, Ownership: Any},
+
+	{Role: enums.TECHNICIAN, Resource: COMMENTS, Action: READ, Ownership: Any},
+	{Role: enums.TECHNICIAN, Resource: COMMENTS, Action: UPDATE, Ownership: Own},
+	{Role: enums.TECHNICIAN, Resource: COMMENTS, Action: DELETE, Ownership: Own},
+
+	// Attachment routes work the same way, reading the task is enough to
+	// list and upload attachments.
+	{Role: enums.MANAGER, Resource: ATTACHMENTS, Action: READ, Ownership: Any},
+	{Role: enums.MANAGER, Resource: ATTACHMENTS, Action: DELETE, Ownership: Any},
+
+	{Role: enums.TECHNICIAN, Resource: ATTACHMENTS, Action: READ, Ownership: Any},
+	{Role: enums.TECHNICIAN, Resource: ATTACHMENTS, Action: DELETE, Ownership: Own},
+}
+
+// Permits reports whether role may perform the action on some resource, it
+// lets a request be denied before its target is loaded.
+func Permits(role, resource, action string) bool {
+	for _, rule := range Rules {
+		if rule.Role == role && rule.Resource == resource && rule.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func Allowed(role, resource, action string, ownership Ownership, targetRole string) bool {
+	for _, rule := range Rules {
+		if rule.Role != role || rule.Resource != resource || rule.Action != action {
+			continue
+		}
+		if rule.Ownership != Any && rule.Ownership != ownership {
+			continue
+		}
+		if rule.TargetRole != "" && rule.TargetRole != targetRole {
+			continue
+		}
+		return true
+	}
+	return false
+}
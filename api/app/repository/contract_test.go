@@ -0,0 +1,819 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// testStoreContract runs the behaviour every Store has to share, newStore
+// returns an empty store.
+func testStoreContract(t *testing.T, newStore func() Store) {
+	t.Run("users", func(t *testing.T) { testUsers(t, newStore()) })
+	t.Run("administration", func(t *testing.T) { testAdministration(t, newStore()) })
+	t.Run("tasks", func(t *testing.T) { testTasks(t, newStore()) })
+	t.Run("assignments", func(t *testing.T) { testAssignments(t, newStore()) })
+	t.Run("search", func(t *testing.T) { testSearch(t, newStore()) })
+	t.Run("comments", func(t *testing.T) { testComments(t, newStore()) })
+	t.Run("attachments", func(t *testing.T) { testAttachments(t, newStore()) })
+	t.Run("tokens", func(t *testing.T) { testTokens(t, newStore()) })
+	t.Run("single use tokens", func(t *testing.T) { testSingleUseTokens(t, newStore()) })
+	t.Run("login attempts", func(t *testing.T) { testLoginAttempts(t, newStore()) })
+	t.Run("outbox", func(t *testing.T) { testOutbox(t, newStore()) })
+	t.Run("pagination", func(t *testing.T) { testPagination(t, newStore()) })
+	t.Run("transaction", func(t *testing.T) { testTransaction(t, newStore()) })
+}
+
+func saveUser(t *testing.T, store Store, nickname, userType string) *models.User {
+	user := &models.User{Nickname: nickname, Email: nickname + "@gmail.com", Password: "password", UserType: userType}
+	user, err := store.Users().Save(user)
+	if err != nil {
+		t.Fatalf("cannot save user %s: %v", nickname, err)
+	}
+	return user
+}
+
+func saveTask(t *testing.T, store Store, authorID uint64, summary string) *models.Task {
+	task := &models.Task{Summary: summary, AuthorID: authorID}
+	err := task.Prepare()
+	if err != nil {
+		t.Fatalf("cannot prepare task: %v", err)
+	}
+	task, err = store.Tasks().Save(task)
+	if err != nil {
+		t.Fatalf("cannot save task: %v", err)
+	}
+	return task
+}
+
+func testUsers(t *testing.T, store Store) {
+	users := store.Users()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	technician := saveUser(t, store, "technician", "")
+	assert.NotEqual(t, manager.ID, uint64(0))
+	assert.NotEqual(t, manager.ID, technician.ID)
+
+	found, err := users.FindByID(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Nickname, "technician")
+	assert.Equal(t, found.Email, "technician@gmail.com")
+	assert.Equal(t, found.UserType, enums.TECHNICIAN)
+
+	_, err = users.FindByID(999)
+	assert.Equal(t, err.Error(), "user not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	found, err = users.FindByEmail("manager@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, manager.ID)
+	assert.Equal(t, found.Nickname, "manager")
+	assert.Equal(t, found.Email, "manager@gmail.com")
+	assert.Equal(t, models.VerifyPassword(found.Password, "password"), nil)
+	_, err = users.FindByEmail("nobody@gmail.com")
+	assert.Equal(t, err.Error(), "user not found")
+	// emails and nicknames ignore case
+	found, err = users.FindByEmail("Manager@Gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, manager.ID)
+
+	_, err = users.Save(&models.User{Nickname: "other", Email: "technician@gmail.com", Password: "password"})
+	assert.Equal(t, err, ErrDuplicate)
+	_, err = users.Save(&models.User{Nickname: "technician", Email: "other@gmail.com", Password: "password"})
+	assert.Equal(t, err, ErrDuplicate)
+	_, err = users.Save(&models.User{Nickname: "other", Email: "Technician@gmail.com", Password: "password"})
+	assert.Equal(t, err, ErrDuplicate)
+	_, err = users.Save(&models.User{Nickname: "Technician", Email: "other@gmail.com", Password: "password"})
+	assert.Equal(t, err, ErrDuplicate)
+
+	second := saveUser(t, store, "second", "")
+	technicians, err := users.FindAllTechnicians()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*technicians), 2)
+	assert.Equal(t, (*technicians)[0].ID, technician.ID)
+	assert.Equal(t, (*technicians)[1].ID, second.ID)
+	managers, err := users.FindAllManagers()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*managers), 1)
+	assert.Equal(t, (*managers)[0].Email, "manager@gmail.com")
+
+	_, err = users.Update(second.ID, &models.User{Nickname: "renamed", Email: "renamed@gmail.com", Password: "secret"})
+	assert.Equal(t, err, nil)
+	found, err = users.FindByEmail("renamed@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, second.ID)
+	assert.Equal(t, models.VerifyPassword(found.Password, "secret"), nil)
+	_, err = users.Update(second.ID, &models.User{Nickname: "renamed", Email: "manager@gmail.com", Password: "secret"})
+	assert.Equal(t, err, ErrDuplicate)
+	_, err = users.Update(999, &models.User{Nickname: "ghost", Email: "ghost@gmail.com", Password: "secret"})
+	assert.Equal(t, err.Error(), "user not found")
+
+	task := saveTask(t, store, technician.ID, "Hello world")
+	deleted, err := users.Delete(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, int64(1))
+	_, err = store.Tasks().FindByID(task.ID)
+	assert.Equal(t, err.Error(), "task not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+	deleted, err = users.Delete(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, int64(0))
+}
+
+func testAdministration(t *testing.T, store Store) {
+	users := store.Users()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	technician := saveUser(t, store, "technician", "")
+
+	promoted, err := users.UpdateRole(technician.ID, enums.MANAGER)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, promoted.UserType, enums.MANAGER)
+	promoted, err = users.UpdateRole(technician.ID, enums.MANAGER)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, promoted.UserType, enums.MANAGER)
+	managers, err := users.FindAllManagers()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*managers), 2)
+	_, err = users.UpdateRole(999, enums.MANAGER)
+	assert.Equal(t, err.Error(), "user not found")
+
+	deactivated, err := users.Deactivate(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, deactivated.DeactivatedAt, nil)
+	assert.Equal(t, deactivated.Active(), false)
+	again, err := users.Deactivate(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *again.DeactivatedAt, *deactivated.DeactivatedAt)
+	found, err := users.FindByEmail("technician@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Active(), false)
+	managers, err = users.FindAllManagers()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*managers), 1)
+	assert.Equal(t, (*managers)[0].ID, manager.ID)
+
+	demoted, err := users.UpdateRole(technician.ID, enums.TECHNICIAN)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, demoted.Active(), false)
+	technicians, err := users.FindAllTechnicians()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*technicians), 1)
+	assert.NotEqual(t, (*technicians)[0].DeactivatedAt, nil)
+
+	reactivated, err := users.Reactivate(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, reactivated.Active(), true)
+	found, err = users.FindByID(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Active(), true)
+	_, err = users.Deactivate(999)
+	assert.Equal(t, err.Error(), "user not found")
+
+	assert.Equal(t, found.Verified(), false)
+	verified, err := users.Verify(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, verified.Verified(), true)
+	again, err = users.Verify(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *again.VerifiedAt, *verified.VerifiedAt)
+	// keeping the email, in any case, keeps it verified, a new one has to be
+	// verified again
+	_, err = users.Update(technician.ID, &models.User{Nickname: "technician", Email: "Technician@gmail.com", Password: "secret"})
+	assert.Equal(t, err, nil)
+	found, err = users.FindByID(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Verified(), true)
+	_, err = users.Update(technician.ID, &models.User{Nickname: "technician", Email: "moved@gmail.com", Password: "secret"})
+	assert.Equal(t, err, nil)
+	found, err = users.FindByID(technician.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Verified(), false)
+	_, err = users.Verify(999)
+	assert.Equal(t, err.Error(), "user not found")
+
+	reset, err := users.ResetPassword(technician.ID, "new secret")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, reset.PasswordChangedAt, nil)
+	found, err = users.FindByEmail(reset.Email)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, models.VerifyPassword(found.Password, "new secret"), nil)
+	_, err = users.ResetPassword(999, "new secret")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+}
+
+func testTasks(t *testing.T, store Store) {
+	tasks := store.Tasks()
+	technician := saveUser(t, store, "technician", "")
+
+	task := &models.Task{Summary: "Hello world", AuthorID: 999}
+	err := task.Prepare()
+	assert.Equal(t, err, nil)
+	_, err = tasks.Save(task)
+	assert.Equal(t, err, ErrMissingReference)
+
+	task = saveTask(t, store, technician.ID, "Hello world")
+	assert.NotEqual(t, task.ID, uint64(0))
+	assert.Equal(t, task.Summary, "Hello world")
+	assert.Equal(t, task.Status, enums.OPEN)
+
+	found, err := tasks.FindByID(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Summary, "Hello world")
+	assert.Equal(t, found.AuthorID, technician.ID)
+	assert.Equal(t, found.Status, enums.OPEN)
+
+	update := &models.Task{Summary: "Updated summary"}
+	err = update.Prepare()
+	assert.Equal(t, err, nil)
+	updated, err := tasks.Update(task.ID, update)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Summary, "Updated summary")
+	found, err = tasks.FindByID(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Summary, "Updated summary")
+	_, err = tasks.Update(999, update)
+	assert.Equal(t, err.Error(), "task not found")
+
+	stale := *found
+	moved, err := tasks.UpdateStatus(found, enums.IN_PROGRESS)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, moved.Status, enums.IN_PROGRESS)
+	_, err = tasks.UpdateStatus(&stale, enums.CANCELLED)
+	assert.Equal(t, err.Error(), "task status changed concurrently")
+	assert.Equal(t, errors.Is(err, models.ErrStaleStatus), true)
+	found, err = tasks.FindByID(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Status, enums.IN_PROGRESS)
+
+	deleted, err := tasks.Delete(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, int64(1))
+	deleted, err = tasks.Delete(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted, int64(0))
+}
+
+func testAssignments(t *testing.T, store Store) {
+	tasks := store.Tasks()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	first := saveUser(t, store, "first", "")
+	second := saveUser(t, store, "second", "")
+	third := saveUser(t, store, "third", "")
+
+	order := &models.Task{Summary: "Fix the boiler", AuthorID: manager.ID, AssigneeIDs: []uint64{second.ID, first.ID}}
+	err := order.Prepare()
+	assert.Equal(t, err, nil)
+	order, err = tasks.Save(order)
+	assert.Equal(t, err, nil)
+	found, err := tasks.FindByID(order.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.AssigneeIDs, []uint64{first.ID, second.ID})
+	own := saveTask(t, store, first.ID, "Hello world")
+	found, err = tasks.FindByID(own.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.AssigneeIDs, []uint64{})
+
+	invalid := &models.Task{Summary: "Fix the boiler", AuthorID: manager.ID, AssigneeIDs: []uint64{999}}
+	err = invalid.Prepare()
+	assert.Equal(t, err, nil)
+	_, err = tasks.Save(invalid)
+	assert.Equal(t, err, ErrMissingReference)
+
+	filter := &models.TaskFilter{InvolvedID: first.ID}
+	filter.Prepare()
+	page, err := tasks.Find(filter)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(page.Tasks), 2)
+	filter = &models.TaskFilter{AssigneeID: first.ID}
+	filter.Prepare()
+	page, err = tasks.Find(filter)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(page.Tasks), 1)
+	assert.Equal(t, page.Tasks[0].ID, order.ID)
+	assert.Equal(t, page.Tasks[0].AssigneeIDs, []uint64{first.ID, second.ID})
+	assert.Equal(t, page.Tasks[0].Summary, "Fix the boiler")
+
+	added, err := tasks.Assign(order.ID, []uint64{third.ID, second.ID, third.ID})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, added, []uint64{third.ID})
+	found, err = tasks.FindByID(order.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.AssigneeIDs, []uint64{second.ID, third.ID})
+	_, err = tasks.Assign(order.ID, []uint64{999})
+	assert.Equal(t, err, ErrMissingReference)
+	_, err = tasks.Assign(999, []uint64{first.ID})
+	assert.Equal(t, err, ErrMissingReference)
+
+	removed, err := tasks.Unassign(order.ID, second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(1))
+	removed, err = tasks.Unassign(order.ID, second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(0))
+
+	_, err = store.Users().Delete(third.ID)
+	assert.Equal(t, err, nil)
+	found, err = tasks.FindByID(order.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.AssigneeIDs, []uint64{})
+}
+
+func testSearch(t *testing.T, store Store) {
+	tasks := store.Tasks()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	first := saveUser(t, store, "first", "")
+	second := saveUser(t, store, "second", "")
+
+	boiler := saveTask(t, store, first.ID, "Fix the boiler, the boiler leaks")
+	pipe := saveTask(t, store, second.ID, "Boiler pipe replaced")
+	order := &models.Task{Summary: "Inspect the elevator and the boiler room", AuthorID: manager.ID, AssigneeIDs: []uint64{second.ID}}
+	err := order.Prepare()
+	assert.Equal(t, err, nil)
+	order, err = tasks.Save(order)
+	assert.Equal(t, err, nil)
+
+	search := func(query string, involvedID uint64) []uint64 {
+		search := &models.TaskSearch{Query: query, InvolvedID: involvedID}
+		search.Prepare()
+		err := search.Validate()
+		assert.Equal(t, err, nil)
+		results, err := tasks.Search(search)
+		assert.Equal(t, err, nil)
+		ids := []uint64{}
+		for _, task := range results.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, search("BOILER", 0), []uint64{boiler.ID, order.ID, pipe.ID})
+	assert.Equal(t, search("boiler pipe", 0), []uint64{pipe.ID, boiler.ID, order.ID})
+	assert.Equal(t, search("elevator", 0), []uint64{order.ID})
+	assert.Equal(t, search("boiler", second.ID), []uint64{order.ID, pipe.ID})
+	assert.Equal(t, search("plumbing", 0), []uint64{})
+
+	limited := &models.TaskSearch{Query: "boiler", Limit: 1}
+	err = limited.Validate()
+	assert.Equal(t, err, nil)
+	results, err := tasks.Search(limited)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(results.Tasks), 1)
+	assert.Equal(t, results.Tasks[0].Summary, "Fix the boiler, the boiler leaks")
+
+	update := &models.Task{Summary: "Paint the hallway"}
+	err = update.Prepare()
+	assert.Equal(t, err, nil)
+	_, err = tasks.Update(boiler.ID, update)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, search("boiler", 0), []uint64{order.ID, pipe.ID})
+	assert.Equal(t, search("hallway", first.ID), []uint64{boiler.ID})
+
+	_, err = tasks.Delete(pipe.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, search("boiler", 0), []uint64{order.ID})
+}
+
+func saveComment(t *testing.T, store Store, taskID, authorID uint64, body string) *models.Comment {
+	comment := &models.Comment{TaskID: taskID, AuthorID: authorID, Body: body}
+	err := comment.Prepare()
+	if err != nil {
+		t.Fatalf("cannot prepare comment: %v", err)
+	}
+	comment, err = store.Comments().Save(comment)
+	if err != nil {
+		t.Fatalf("cannot save comment: %v", err)
+	}
+	return comment
+}
+
+func testComments(t *testing.T, store Store) {
+	comments := store.Comments()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	technician := saveUser(t, store, "technician", "")
+	task := saveTask(t, store, technician.ID, "Fix the boiler")
+	other := saveTask(t, store, technician.ID, "Paint the hallway")
+
+	first := saveComment(t, store, task.ID, technician.ID, "The part is missing")
+	assert.Equal(t, first.Body, "The part is missing")
+	second := saveComment(t, store, task.ID, manager.ID, "Ordered it")
+	saveComment(t, store, other.ID, manager.ID, "Which color?")
+
+	invalid := &models.Comment{TaskID: 999, AuthorID: manager.ID, Body: "Nobody reads this"}
+	err := invalid.Prepare()
+	assert.Equal(t, err, nil)
+	_, err = comments.Save(invalid)
+	assert.Equal(t, err, ErrMissingReference)
+
+	thread, err := comments.FindByTask(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*thread), 2)
+	assert.Equal(t, (*thread)[0].ID, first.ID)
+	assert.Equal(t, (*thread)[0].Body, "The part is missing")
+	assert.Equal(t, (*thread)[1].AuthorID, manager.ID)
+
+	for _, body := range []string{"The part arrived", "The part is installed"} {
+		edit := &models.Comment{Body: body}
+		err = edit.Prepare()
+		assert.Equal(t, err, nil)
+		updated, err := comments.Update(first.ID, edit)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, updated.Body, body)
+		assert.Equal(t, updated.TaskID, task.ID)
+	}
+	found, err := comments.FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Body, "The part is installed")
+	revisions, err := comments.Revisions(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*revisions), 2)
+	assert.Equal(t, (*revisions)[0].Body, "The part is missing")
+	assert.Equal(t, (*revisions)[1].Body, "The part arrived")
+	edit := &models.Comment{Body: "Nobody reads this"}
+	err = edit.Prepare()
+	assert.Equal(t, err, nil)
+	_, err = comments.Update(999, edit)
+	assert.Equal(t, err.Error(), "comment not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	removed, err := comments.Delete(second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(1))
+	removed, err = comments.Delete(second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(0))
+	_, err = comments.FindByID(second.ID)
+	assert.Equal(t, err.Error(), "comment not found")
+
+	_, err = store.Tasks().Delete(task.ID)
+	assert.Equal(t, err, nil)
+	_, err = comments.FindByID(first.ID)
+	assert.Equal(t, err.Error(), "comment not found")
+	revisions, err = comments.Revisions(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*revisions), 0)
+
+	_, err = store.Users().Delete(manager.ID)
+	assert.Equal(t, err, nil)
+	thread, err = comments.FindByTask(other.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*thread), 0)
+}
+
+func saveAttachment(t *testing.T, store Store, tid, uid uint64, filename, storageKey string) *models.Attachment {
+	attachment := &models.Attachment{TaskID: tid, AuthorID: uid, Filename: filename, ContentType: "image/jpeg", Size: 2048, StorageKey: storageKey}
+	err := attachment.Prepare([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Equal(t, err, nil)
+	saved, err := store.Attachments().Save(attachment)
+	assert.Equal(t, err, nil)
+	return saved
+}
+
+func testAttachments(t *testing.T, store Store) {
+	attachments := store.Attachments()
+	manager := saveUser(t, store, "manager", enums.MANAGER)
+	technician := saveUser(t, store, "technician", "")
+	task := saveTask(t, store, technician.ID, "Fix the boiler")
+	other := saveTask(t, store, technician.ID, "Paint the hallway")
+
+	first := saveAttachment(t, store, task.ID, technician.ID, "boiler.jpg", "tasks/1/a")
+	assert.Equal(t, first.Filename, "boiler.jpg")
+	second := saveAttachment(t, store, task.ID, manager.ID, "invoice.jpg", "tasks/1/b")
+	saveAttachment(t, store, other.ID, manager.ID, "wall.jpg", "tasks/2/c")
+
+	duplicate := &models.Attachment{TaskID: task.ID, AuthorID: manager.ID, Filename: "copy.jpg", ContentType: "image/jpeg", Size: 1, StorageKey: "tasks/1/a"}
+	err := duplicate.Prepare([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Equal(t, err, nil)
+	_, err = attachments.Save(duplicate)
+	assert.Equal(t, err, ErrDuplicate)
+	duplicate.TaskID, duplicate.StorageKey = 999, "tasks/999/d"
+	err = duplicate.Prepare([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Equal(t, err, nil)
+	_, err = attachments.Save(duplicate)
+	assert.Equal(t, err, ErrMissingReference)
+
+	list, err := attachments.FindByTask(task.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*list), 2)
+	assert.Equal(t, (*list)[0].ID, first.ID)
+	assert.Equal(t, (*list)[0].Filename, "boiler.jpg")
+	assert.Equal(t, (*list)[1].AuthorID, manager.ID)
+
+	found, err := attachments.FindByID(first.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.StorageKey, "tasks/1/a")
+	assert.Equal(t, found.Size, int64(2048))
+	fileKey, err := found.OpenFileKey()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(fileKey), "0123456789abcdef0123456789abcdef")
+
+	removed, err := attachments.Delete(second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(1))
+	removed, err = attachments.Delete(second.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, int64(0))
+	_, err = attachments.FindByID(second.ID)
+	assert.Equal(t, err.Error(), "attachment not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	_, err = store.Tasks().Delete(task.ID)
+	assert.Equal(t, err, nil)
+	_, err = attachments.FindByID(first.ID)
+	assert.Equal(t, err.Error(), "attachment not found")
+
+	_, err = store.Users().Delete(manager.ID)
+	assert.Equal(t, err, nil)
+	list, err = attachments.FindByTask(other.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*list), 0)
+}
+
+func saveRefreshToken(t *testing.T, store Store, uid uint64, familyID, hash string) *models.RefreshToken {
+	token := &models.RefreshToken{UserID: uid, FamilyID: familyID, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+	err := store.Tokens().SaveRefreshToken(token)
+	assert.Equal(t, err, nil)
+	return token
+}
+
+func testTokens(t *testing.T, store Store) {
+	tokens := store.Tokens()
+	first := saveUser(t, store, "first", "")
+	second := saveUser(t, store, "second", "")
+
+	rotated := saveRefreshToken(t, store, first.ID, "family-a", "hash-a1")
+	current := saveRefreshToken(t, store, first.ID, "family-a", "hash-a2")
+	other := saveRefreshToken(t, store, first.ID, "family-b", "hash-b1")
+	foreign := saveRefreshToken(t, store, second.ID, "family-c", "hash-c1")
+	err := tokens.SaveRefreshToken(&models.RefreshToken{UserID: second.ID, FamilyID: "family-c", TokenHash: "hash-c1", ExpiresAt: time.Now()})
+	assert.Equal(t, err, ErrDuplicate)
+	err = tokens.SaveRefreshToken(&models.RefreshToken{UserID: 999, FamilyID: "family-d", TokenHash: "hash-d1", ExpiresAt: time.Now()})
+	assert.Equal(t, err, ErrMissingReference)
+
+	found, err := tokens.FindRefreshToken("hash-a1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, rotated.ID)
+	assert.Equal(t, found.FamilyID, "family-a")
+	assert.Equal(t, found.RevokedAt.Valid, false)
+	_, err = tokens.FindRefreshToken("missing")
+	assert.Equal(t, err.Error(), "refresh token not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	err = tokens.RevokeRefreshToken(rotated.ID)
+	assert.Equal(t, err, nil)
+	found, _ = tokens.FindRefreshToken("hash-a1")
+	assert.Equal(t, found.RevokedAt.Valid, true)
+	found, _ = tokens.FindRefreshToken("hash-a2")
+	assert.Equal(t, found.RevokedAt.Valid, false)
+
+	err = tokens.RevokeFamily("family-a")
+	assert.Equal(t, err, nil)
+	found, _ = tokens.FindRefreshToken("hash-a2")
+	assert.Equal(t, found.ID, current.ID)
+	assert.Equal(t, found.RevokedAt.Valid, true)
+	found, _ = tokens.FindRefreshToken("hash-b1")
+	assert.Equal(t, found.RevokedAt.Valid, false)
+
+	err = tokens.RevokeUser(first.ID)
+	assert.Equal(t, err, nil)
+	found, _ = tokens.FindRefreshToken("hash-b1")
+	assert.Equal(t, found.ID, other.ID)
+	assert.Equal(t, found.RevokedAt.Valid, true)
+	found, _ = tokens.FindRefreshToken("hash-c1")
+	assert.Equal(t, found.ID, foreign.ID)
+	assert.Equal(t, found.RevokedAt.Valid, false)
+
+	revoked, err := tokens.IsRevoked("jti-1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, false)
+	err = tokens.Revoke("jti-1", time.Now().Add(time.Hour))
+	assert.Equal(t, err, nil)
+	err = tokens.Revoke("jti-1", time.Now().Add(time.Hour))
+	assert.Equal(t, err, nil)
+	revoked, err = tokens.IsRevoked("jti-1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+
+	_, err = store.Users().Delete(second.ID)
+	assert.Equal(t, err, nil)
+	_, err = tokens.FindRefreshToken("hash-c1")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+}
+
+func saveSingleUseToken(t *testing.T, store Store, uid uint64, purpose, hash string) *models.SingleUseToken {
+	token := &models.SingleUseToken{UserID: uid, Purpose: purpose, Email: "owner@gmail.com", TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+	err := store.SingleUseTokens().Save(token)
+	assert.Equal(t, err, nil)
+	return token
+}
+
+func testSingleUseTokens(t *testing.T, store Store) {
+	tokens := store.SingleUseTokens()
+	first := saveUser(t, store, "first", "")
+	second := saveUser(t, store, "second", "")
+
+	recent, err := tokens.Recent(models.PasswordResetPurpose, first.ID, time.Minute)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, recent, false)
+	reset := saveSingleUseToken(t, store, first.ID, models.PasswordResetPurpose, "hash-reset")
+	saveSingleUseToken(t, store, first.ID, models.EmailVerificationPurpose, "hash-verify")
+	saveSingleUseToken(t, store, second.ID, models.PasswordResetPurpose, "hash-other")
+	err = tokens.Save(&models.SingleUseToken{UserID: second.ID, Purpose: models.PasswordResetPurpose, TokenHash: "hash-other", ExpiresAt: time.Now()})
+	assert.Equal(t, err, ErrDuplicate)
+	err = tokens.Save(&models.SingleUseToken{UserID: 999, Purpose: models.PasswordResetPurpose, TokenHash: "hash-missing", ExpiresAt: time.Now()})
+	assert.Equal(t, err, ErrMissingReference)
+
+	recent, err = tokens.Recent(models.PasswordResetPurpose, first.ID, time.Minute)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, recent, true)
+	recent, err = tokens.Recent(models.PasswordResetPurpose, first.ID, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, recent, false)
+
+	found, err := tokens.FindByHash(models.PasswordResetPurpose, "hash-reset")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, reset.ID)
+	assert.Equal(t, found.Email, "owner@gmail.com")
+	assert.Equal(t, found.Usable(time.Now()), true)
+	assert.Equal(t, found.Usable(found.ExpiresAt), false)
+	// a token only serves its own purpose
+	_, err = tokens.FindByHash(models.EmailVerificationPurpose, "hash-reset")
+	assert.Equal(t, err.Error(), "token not found")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+
+	err = tokens.Use(models.PasswordResetPurpose, first.ID)
+	assert.Equal(t, err, nil)
+	found, _ = tokens.FindByHash(models.PasswordResetPurpose, "hash-reset")
+	assert.Equal(t, found.Usable(time.Now()), false)
+	found, _ = tokens.FindByHash(models.EmailVerificationPurpose, "hash-verify")
+	assert.Equal(t, found.Usable(time.Now()), true)
+	found, _ = tokens.FindByHash(models.PasswordResetPurpose, "hash-other")
+	assert.Equal(t, found.Usable(time.Now()), true)
+
+	_, err = store.Users().Delete(second.ID)
+	assert.Equal(t, err, nil)
+	_, err = tokens.FindByHash(models.PasswordResetPurpose, "hash-other")
+	assert.Equal(t, errors.Is(err, ErrNotFound), true)
+}
+
+func testLoginAttempts(t *testing.T, store Store) {
+	attempts := store.LoginAttempts()
+	now := time.Now().Truncate(time.Second)
+	window := 15 * time.Minute
+
+	attempt, err := attempts.Find("account")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 0)
+	locked, err := attempts.Lock("account", now, now.Add(time.Minute))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, locked, false)
+
+	for i := 1; i <= 3; i++ {
+		attempt, err = attempts.RecordFailure("account", now, window)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, attempt.Failures, i)
+	}
+	attempt, err = attempts.RecordFailure("address", now, window)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 1)
+
+	// a lockout is only taken once
+	until := now.Add(time.Minute)
+	locked, err = attempts.Lock("account", now, until)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, locked, true)
+	locked, err = attempts.Lock("account", now, now.Add(time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, locked, false)
+	attempt, err = attempts.Find("account")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.LockedFor(now), time.Minute)
+
+	// failures after the lockout start over and unlock
+	later := until.Add(time.Second)
+	attempt, err = attempts.RecordFailure("account", later, window)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 1)
+	assert.Equal(t, attempt.LockedUntil == nil, true)
+
+	// and so do failures older than the window
+	attempt, err = attempts.RecordFailure("address", now.Add(window+time.Second), window)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 1)
+
+	err = attempts.Delete("account")
+	assert.Equal(t, err, nil)
+	attempt, err = attempts.Find("account")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 0)
+	attempt, err = attempts.Find("address")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempt.Failures, 1)
+}
+
+func testOutbox(t *testing.T, store Store) {
+	outbox := store.Outbox()
+	now := time.Now().Truncate(time.Second)
+	ctx := logging.WithRequestID(context.Background(), "request")
+	err := outbox.Save(ctx, []map[string]interface{}{{"email": "first@gmail.com"}, {"email": "second@gmail.com"}}, "notification")
+	assert.Equal(t, err, nil)
+
+	pending, err := outbox.Pending(1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*pending), 1)
+	first := (*pending)[0]
+	assert.Equal(t, first.Controller, "notification")
+	assert.Equal(t, first.Payload["email"], "first@gmail.com")
+	assert.Equal(t, first.RequestID, "request")
+
+	err = outbox.MarkSent([]uint64{first.ID}, now)
+	assert.Equal(t, err, nil)
+	pending, err = outbox.Pending(10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*pending), 1)
+	assert.Equal(t, (*pending)[0].Payload["email"], "second@gmail.com")
+
+	// only sent messages older than the cutoff are pruned
+	pruned, err := outbox.Prune(now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pruned, int64(0))
+	pruned, err = outbox.Prune(now.Add(time.Hour))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pruned, int64(1))
+	pending, err = outbox.Pending(10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*pending), 1)
+}
+
+func testPagination(t *testing.T, store Store) {
+	first := saveUser(t, store, "first", "")
+	second := saveUser(t, store, "second", "")
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		ids = append(ids, saveTask(t, store, first.ID, "Hello world").ID)
+	}
+	saveTask(t, store, second.ID, "Hello world")
+
+	filter := &models.TaskFilter{Limit: 2, AuthorID: first.ID, Order: "desc"}
+	var seen []uint64
+	for {
+		filter.Prepare()
+		err := filter.Validate()
+		assert.Equal(t, err, nil)
+		page, err := store.Tasks().Find(filter)
+		assert.Equal(t, err, nil)
+		for _, task := range page.Tasks {
+			assert.Equal(t, task.Summary, "Hello world")
+			seen = append(seen, task.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	assert.Equal(t, len(seen), 5)
+	for i, id := range seen {
+		assert.Equal(t, id, ids[len(ids)-1-i])
+	}
+}
+
+func testTransaction(t *testing.T, store Store) {
+	failure := errors.New("failure")
+	err := store.Transaction(func(tx Store) error {
+		saveUser(t, tx, "rolledback", "")
+		err := tx.Outbox().Save(context.Background(), []map[string]interface{}{{"email": "rolledback@gmail.com"}}, "notification")
+		assert.Equal(t, err, nil)
+		return failure
+	})
+	assert.Equal(t, err, failure)
+	_, err = store.Users().FindByEmail("rolledback@gmail.com")
+	assert.Equal(t, err.Error(), "user not found")
+
+	err = store.Transaction(func(tx Store) error {
+		saveUser(t, tx, "committed", "")
+		return nil
+	})
+	assert.Equal(t, err, nil)
+	_, err = store.Users().FindByEmail("committed@gmail.com")
+	assert.Equal(t, err, nil)
+
+	// a rollback keeps what was written outside of the transaction meanwhile
+	saved := make(chan error, 1)
+	err = store.Transaction(func(tx Store) error {
+		go func() {
+			_, err := store.Users().Save(&models.User{Nickname: "outside", Email: "outside@gmail.com", Password: "password"})
+			saved <- err
+		}()
+		saveUser(t, tx, "inside", "")
+		return failure
+	})
+	assert.Equal(t, err, failure)
+	assert.Equal(t, <-saved, nil)
+	_, err = store.Users().FindByEmail("outside@gmail.com")
+	assert.Equal(t, err, nil)
+	_, err = store.Users().FindByEmail("inside@gmail.com")
+	assert.Equal(t, err.Error(), "user not found")
+}
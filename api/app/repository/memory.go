@@ -0,0 +1,1085 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/enums"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+	"github.com/vitorbiten/maintenance/api/app/utils"
+)
+
+type memoryTables struct {
+	users     map[uint64]models.User
+	tasks     map[uint64]models.Task
+	comments  map[uint64]models.Comment
+	revisions []models.CommentRevision
+	// attachments are kept sealed, like the MySQL rows.
+	attachments map[uint64]models.Attachment
+	// refreshTokens are cascaded with their users, revokedTokens map a jti to
+	// its expiry.
+	refreshTokens map[uint64]models.RefreshToken
+	revokedTokens map[string]time.Time
+	// singleUseTokens are cascaded with their users.
+	singleUseTokens map[uint64]models.SingleUseToken
+	loginAttempts   map[string]models.LoginAttempt
+	outbox          []models.OutboxMessage
+}
+
+func (t *memoryTables) clone() memoryTables {
+	c := memoryTables{
+		users:           make(map[uint64]models.User, len(t.users)),
+		tasks:           make(map[uint64]models.Task, len(t.tasks)),
+		comments:        make(map[uint64]models.Comment, len(t.comments)),
+		revisions:       append([]models.CommentRevision{}, t.revisions...),
+		attachments:     make(map[uint64]models.Attachment, len(t.attachments)),
+		refreshTokens:   make(map[uint64]models.RefreshToken, len(t.refreshTokens)),
+		revokedTokens:   make(map[string]time.Time, len(t.revokedTokens)),
+		singleUseTokens: make(map[uint64]models.SingleUseToken, len(t.singleUseTokens)),
+		loginAttempts:   make(map[string]models.LoginAttempt, len(t.loginAttempts)),
+		outbox:          append([]models.OutboxMessage{}, t.outbox...),
+	}
+	for id, token := range t.refreshTokens {
+		c.refreshTokens[id] = token
+	}
+	for jti, expiresAt := range t.revokedTokens {
+		c.revokedTokens[jti] = expiresAt
+	}
+	for id, token := range t.singleUseTokens {
+		c.singleUseTokens[id] = token
+	}
+	for subject, attempt := range t.loginAttempts {
+		c.loginAttempts[subject] = attempt
+	}
+	for id, attachment := range t.attachments {
+		c.attachments[id] = attachment
+	}
+	for id, comment := range t.comments {
+		c.comments[id] = comment
+	}
+	for id, user := range t.users {
+		c.users[id] = user
+	}
+	for id, task := range t.tasks {
+		task.AssigneeIDs = append([]uint64{}, task.AssigneeIDs...)
+		c.tasks[id] = task
+	}
+	return c
+}
+
+// dropOrphans cascades deletes of tasks and users to their comments, the
+// revisions of those comments and their attachments, and deletes of users to
+// their refresh and single use tokens.
+func (t *memoryTables) dropOrphans() {
+	for id, token := range t.singleUseTokens {
+		if _, ok := t.users[token.UserID]; !ok {
+			delete(t.singleUseTokens, id)
+		}
+	}
+	for id, token := range t.refreshTokens {
+		if _, ok := t.users[token.UserID]; !ok {
+			delete(t.refreshTokens, id)
+		}
+	}
+	for id, attachment := range t.attachments {
+		_, task := t.tasks[attachment.TaskID]
+		_, author := t.users[attachment.AuthorID]
+		if !task || !author {
+			delete(t.attachments, id)
+		}
+	}
+	for id, comment := range t.comments {
+		_, task := t.tasks[comment.TaskID]
+		_, author := t.users[comment.AuthorID]
+		if !task || !author {
+			delete(t.comments, id)
+		}
+	}
+	kept := []models.CommentRevision{}
+	for _, revision := range t.revisions {
+		if _, ok := t.comments[revision.CommentID]; ok {
+			kept = append(kept, revision)
+		}
+	}
+	t.revisions = kept
+}
+
+// MemoryStore keeps users and tasks in maps with the same constraints as the
+// MySQL schema. A transaction holds the lock of the tables until it ends, so
+// nothing else reads or writes them meanwhile, and restores a snapshot of the
+// tables when it fails. Ids are not reused, like AUTO_INCREMENT.
+type MemoryStore struct {
+	inTx bool
+
+	mu               *sync.Mutex
+	tables           *memoryTables
+	lastUserID       *uint64
+	lastTaskID       *uint64
+	lastCommentID    *uint64
+	lastRevisionID   *uint64
+	lastAttachmentID *uint64
+	lastTokenID      *uint64
+	lastSingleUseID  *uint64
+	lastOutboxID     *uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	var lastUserID, lastTaskID, lastCommentID, lastRevisionID, lastAttachmentID, lastTokenID, lastSingleUseID, lastOutboxID uint64
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		tables: &memoryTables{
+			users:           map[uint64]models.User{},
+			tasks:           map[uint64]models.Task{},
+			comments:        map[uint64]models.Comment{},
+			attachments:     map[uint64]models.Attachment{},
+			refreshTokens:   map[uint64]models.RefreshToken{},
+			revokedTokens:   map[string]time.Time{},
+			singleUseTokens: map[uint64]models.SingleUseToken{},
+			loginAttempts:   map[string]models.LoginAttempt{},
+		},
+		lastUserID:       &lastUserID,
+		lastTaskID:       &lastTaskID,
+		lastCommentID:    &lastCommentID,
+		lastRevisionID:   &lastRevisionID,
+		lastAttachmentID: &lastAttachmentID,
+		lastTokenID:      &lastTokenID,
+		lastSingleUseID:  &lastSingleUseID,
+		lastOutboxID:     &lastOutboxID,
+	}
+}
+
+func (s *MemoryStore) Users() UserRepository {
+	return &memoryUsers{s}
+}
+
+func (s *MemoryStore) Tasks() TaskRepository {
+	return &memoryTasks{s}
+}
+
+func (s *MemoryStore) Comments() CommentRepository {
+	return &memoryComments{s}
+}
+
+func (s *MemoryStore) Attachments() AttachmentRepository {
+	return &memoryAttachments{s}
+}
+
+func (s *MemoryStore) Tokens() TokenRepository {
+	return &memoryTokens{s}
+}
+
+func (s *MemoryStore) SingleUseTokens() SingleUseTokenRepository {
+	return &memorySingleUseTokens{s}
+}
+
+func (s *MemoryStore) LoginAttempts() LoginAttemptRepository {
+	return &memoryLoginAttempts{s}
+}
+
+func (s *MemoryStore) Outbox() OutboxRepository {
+	return &memoryOutbox{s}
+}
+
+// WithContext returns s, nothing in memory waits on I/O.
+func (s *MemoryStore) WithContext(ctx context.Context) Store {
+	return s
+}
+
+func (s *MemoryStore) Transaction(fn func(store Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	// the lock of the tables is held until the end, the store of the
+	// transaction locks its own mutex, which nobody else waits on, so a
+	// rollback cannot undo writes made outside of it
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.tables.clone()
+
+	tx := *s
+	tx.inTx = true
+	tx.mu = &sync.Mutex{}
+	err := fn(&tx)
+	if err != nil {
+		*s.tables = snapshot
+	}
+	return err
+}
+
+// Messages returns the payloads saved to the outbox for controller.
+func (s *MemoryStore) Messages(controller string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []map[string]interface{}
+	for _, message := range s.tables.outbox {
+		if message.Controller == controller {
+			messages = append(messages, message.Payload)
+		}
+	}
+	return messages
+}
+
+// now matches the precision of MySQL datetime columns.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+type memoryUsers struct {
+	store *MemoryStore
+}
+
+// unique compares nicknames and emails ignoring case, like the collation of
+// the MySQL columns.
+func (r *memoryUsers) unique(user *models.User, except uint64) error {
+	for id, existing := range r.store.tables.users {
+		if id == except {
+			continue
+		}
+		if strings.EqualFold(existing.Nickname, user.Nickname) || strings.EqualFold(existing.Email, user.Email) {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) Save(user *models.User) (*models.User, error) {
+	err := user.HashPassword()
+	if err != nil {
+		return &models.User{}, err
+	}
+	if user.UserType == "" {
+		user.UserType = enums.TECHNICIAN
+	}
+	// the email starts unverified, like the MySQL insert
+	user.VerifiedAt = nil
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	err = r.unique(user, 0)
+	if err != nil {
+		return &models.User{}, err
+	}
+	*r.store.lastUserID++
+	user.ID = *r.store.lastUserID
+	stored := *user
+	stored.CreatedAt = now()
+	stored.UpdatedAt = stored.CreatedAt
+	r.store.tables.users[user.ID] = stored
+	return user, nil
+}
+
+func (r *memoryUsers) FindByID(uid uint64) (*models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.users[uid]
+	if !ok {
+		return &models.User{}, fmt.Errorf("user %w", ErrNotFound)
+	}
+	// the password is left out like MySQL does
+	return &models.User{
+		ID:                stored.ID,
+		Nickname:          stored.Nickname,
+		Email:             stored.Email,
+		UserType:          stored.UserType,
+		DeactivatedAt:     stored.DeactivatedAt,
+		VerifiedAt:        stored.VerifiedAt,
+		PasswordChangedAt: stored.PasswordChangedAt,
+	}, nil
+}
+
+func (r *memoryUsers) FindByEmail(email string) (*models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, stored := range r.store.tables.users {
+		if strings.EqualFold(stored.Email, email) {
+			return &models.User{ID: stored.ID, Nickname: stored.Nickname, Email: stored.Email, Password: stored.Password, DeactivatedAt: stored.DeactivatedAt}, nil
+		}
+	}
+	return &models.User{}, fmt.Errorf("user %w", ErrNotFound)
+}
+
+func (r *memoryUsers) findByType(userType string, activeOnly bool) (*[]models.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	users := []models.User{}
+	for _, stored := range r.store.tables.users {
+		if stored.UserType != userType || (activeOnly && !stored.Active()) {
+			continue
+		}
+		user := models.User{ID: stored.ID, Nickname: stored.Nickname, Email: stored.Email}
+		if !activeOnly {
+			user.DeactivatedAt = stored.DeactivatedAt
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return &users, nil
+}
+
+func (r *memoryUsers) FindAllTechnicians() (*[]models.User, error) {
+	return r.findByType(enums.TECHNICIAN, false)
+}
+
+func (r *memoryUsers) FindAllManagers() (*[]models.User, error) {
+	return r.findByType(enums.MANAGER, true)
+}
+
+func (r *memoryUsers) Update(uid uint64, user *models.User) (*models.User, error) {
+	err := user.HashPassword()
+	if err != nil {
+		return &models.User{}, err
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.users[uid]
+	if !ok {
+		return &models.User{}, fmt.Errorf("user %w", ErrNotFound)
+	}
+	err = r.unique(user, uid)
+	if err != nil {
+		return &models.User{}, err
+	}
+	if !strings.EqualFold(stored.Email, user.Email) {
+		stored.VerifiedAt = nil
+	}
+	stored.Nickname = user.Nickname
+	stored.Email = user.Email
+	stored.Password = user.Password
+	stored.UpdatedAt = now()
+	r.store.tables.users[uid] = stored
+	return user, nil
+}
+
+// update applies edit to the stored user and returns it as FindByID would.
+func (r *memoryUsers) update(uid uint64, edit func(stored *models.User)) (*models.User, error) {
+	r.store.mu.Lock()
+	stored, ok := r.store.tables.users[uid]
+	if ok {
+		edit(&stored)
+		stored.UpdatedAt = now()
+		r.store.tables.users[uid] = stored
+	}
+	r.store.mu.Unlock()
+	return r.FindByID(uid)
+}
+
+func (r *memoryUsers) UpdateRole(uid uint64, role string) (*models.User, error) {
+	return r.update(uid, func(stored *models.User) { stored.UserType = role })
+}
+
+func (r *memoryUsers) Deactivate(uid uint64) (*models.User, error) {
+	return r.update(uid, func(stored *models.User) {
+		if stored.Active() {
+			at := now()
+			stored.DeactivatedAt = &at
+		}
+	})
+}
+
+func (r *memoryUsers) Reactivate(uid uint64) (*models.User, error) {
+	return r.update(uid, func(stored *models.User) { stored.DeactivatedAt = nil })
+}
+
+func (r *memoryUsers) Verify(uid uint64) (*models.User, error) {
+	return r.update(uid, func(stored *models.User) {
+		if !stored.Verified() {
+			at := now()
+			stored.VerifiedAt = &at
+		}
+	})
+}
+
+func (r *memoryUsers) ResetPassword(uid uint64, password string) (*models.User, error) {
+	user := models.User{Password: password}
+	err := user.HashPassword()
+	if err != nil {
+		return &models.User{}, err
+	}
+	return r.update(uid, func(stored *models.User) {
+		at := now()
+		stored.Password = user.Password
+		stored.PasswordChangedAt = &at
+	})
+}
+
+func (r *memoryUsers) Delete(uid uint64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.users[uid]; !ok {
+		return 0, nil
+	}
+	delete(r.store.tables.users, uid)
+	for id, task := range r.store.tables.tasks {
+		if task.AuthorID == uid {
+			delete(r.store.tables.tasks, id)
+			continue
+		}
+		task.AssigneeIDs = without(task.AssigneeIDs, uid)
+		r.store.tables.tasks[id] = task
+	}
+	r.store.tables.dropOrphans()
+	return 1, nil
+}
+
+type memoryTasks struct {
+	store *MemoryStore
+}
+
+func (r *memoryTasks) Save(task *models.Task) (*models.Task, error) {
+	if task.Status == "" {
+		task.Status = enums.OPEN
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.users[task.AuthorID]; !ok {
+		return &models.Task{}, ErrMissingReference
+	}
+	assignees := []uint64{}
+	for _, uid := range task.AssigneeIDs {
+		if _, ok := r.store.tables.users[uid]; !ok {
+			return &models.Task{}, ErrMissingReference
+		}
+		for _, assigned := range assignees {
+			if assigned == uid {
+				return &models.Task{}, ErrDuplicate
+			}
+		}
+		assignees = append(assignees, uid)
+	}
+	sort.Slice(assignees, func(i, j int) bool { return assignees[i] < assignees[j] })
+	task.AssigneeIDs = assignees
+	stored := *task
+	stored.AssigneeIDs = append([]uint64{}, assignees...)
+	stored.Date = task.Date.Truncate(time.Second)
+	stored.CreatedAt = now()
+	stored.UpdatedAt = stored.CreatedAt
+	err := task.DecryptSummary()
+	if err != nil {
+		return &models.Task{}, err
+	}
+	*r.store.lastTaskID++
+	stored.ID = *r.store.lastTaskID
+	task.ID = stored.ID
+	r.store.tables.tasks[stored.ID] = stored
+	return task, nil
+}
+
+func (r *memoryTasks) FindByID(tid uint64) (*models.Task, error) {
+	r.store.mu.Lock()
+	stored, ok := r.store.tables.tasks[tid]
+	r.store.mu.Unlock()
+	if !ok {
+		return &models.Task{}, fmt.Errorf("task %w", ErrNotFound)
+	}
+	stored.AssigneeIDs = append([]uint64{}, stored.AssigneeIDs...)
+	err := stored.DecryptSummary()
+	if err != nil {
+		return &models.Task{}, err
+	}
+	return &stored, nil
+}
+
+func (r *memoryTasks) Find(filter *models.TaskFilter) (*models.TaskPage, error) {
+	r.store.mu.Lock()
+	tasks := []models.Task{}
+	for _, stored := range r.store.tables.tasks {
+		if filter.Match(&stored) {
+			stored.AssigneeIDs = append([]uint64{}, stored.AssigneeIDs...)
+			tasks = append(tasks, stored)
+		}
+	}
+	r.store.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return filter.Less(&tasks[i], &tasks[j]) })
+	if len(tasks) > filter.Limit+1 {
+		tasks = tasks[:filter.Limit+1]
+	}
+	page := filter.Paginate(tasks)
+	task := models.Task{}
+	err := task.DecryptSummaries(&page.Tasks)
+	if err != nil {
+		return &models.TaskPage{}, err
+	}
+	return page, nil
+}
+
+// Search has no index to read, it tokenizes the summaries of the visible
+// tasks and ranks them like the MySQL store.
+func (r *memoryTasks) Search(search *models.TaskSearch) (*models.TaskSearchResults, error) {
+	index, err := utils.CurrentBlindIndex()
+	if err != nil {
+		return &models.TaskSearchResults{}, err
+	}
+	filter := models.TaskFilter{InvolvedID: search.InvolvedID}
+	r.store.mu.Lock()
+	tasks := []models.Task{}
+	for _, stored := range r.store.tables.tasks {
+		if filter.Match(&stored) {
+			stored.AssigneeIDs = append([]uint64{}, stored.AssigneeIDs...)
+			tasks = append(tasks, stored)
+		}
+	}
+	r.store.mu.Unlock()
+
+	task := models.Task{}
+	err = task.DecryptSummaries(&tasks)
+	if err != nil {
+		return &models.TaskSearchResults{}, err
+	}
+	scores := map[uint64]models.TaskScore{}
+	matched := []models.Task{}
+	for _, t := range tasks {
+		score := search.Score(index.Tokens(t.Summary))
+		if score.Matched == 0 {
+			continue
+		}
+		scores[t.ID] = score
+		matched = append(matched, t)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return models.RankedBefore(scores[matched[i].ID], matched[i].ID, scores[matched[j].ID], matched[j].ID)
+	})
+	if len(matched) > search.Limit {
+		matched = matched[:search.Limit]
+	}
+	return &models.TaskSearchResults{Tasks: matched}, nil
+}
+
+func (r *memoryTasks) Update(tid uint64, task *models.Task) (*models.Task, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.tasks[tid]
+	if !ok {
+		return &models.Task{}, fmt.Errorf("task %w", ErrNotFound)
+	}
+	stored.Summary = task.Summary
+	stored.Date = task.Date.Truncate(time.Second)
+	stored.UpdatedAt = now()
+	err := task.DecryptSummary()
+	if err != nil {
+		return &models.Task{}, err
+	}
+	r.store.tables.tasks[tid] = stored
+	return task, nil
+}
+
+func (r *memoryTasks) UpdateStatus(task *models.Task, to string) (*models.Task, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.tasks[task.ID]
+	if !ok || stored.Status != task.Status {
+		return &models.Task{}, models.ErrStaleStatus
+	}
+	stored.Status = to
+	stored.UpdatedAt = now()
+	r.store.tables.tasks[task.ID] = stored
+	task.Status = to
+	task.UpdatedAt = stored.UpdatedAt
+	return task, nil
+}
+
+func (r *memoryTasks) Delete(tid uint64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.tasks[tid]; !ok {
+		return 0, nil
+	}
+	delete(r.store.tables.tasks, tid)
+	r.store.tables.dropOrphans()
+	return 1, nil
+}
+
+func (r *memoryTasks) Assign(tid uint64, uids []uint64) ([]uint64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.tasks[tid]
+	if !ok {
+		return nil, ErrMissingReference
+	}
+	assignees := []uint64{}
+	added := []uint64{}
+	for _, uid := range uids {
+		if _, ok := r.store.tables.users[uid]; !ok {
+			return nil, ErrMissingReference
+		}
+		if len(without(assignees, uid)) != len(assignees) {
+			continue
+		}
+		assignees = append(assignees, uid)
+		if !stored.AssignedTo(uid) {
+			added = append(added, uid)
+		}
+	}
+	sort.Slice(assignees, func(i, j int) bool { return assignees[i] < assignees[j] })
+	stored.AssigneeIDs = assignees
+	r.store.tables.tasks[tid] = stored
+	return added, nil
+}
+
+func (r *memoryTasks) Unassign(tid uint64, uid uint64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.tasks[tid]
+	if !ok || !stored.AssignedTo(uid) {
+		return 0, nil
+	}
+	stored.AssigneeIDs = without(stored.AssigneeIDs, uid)
+	r.store.tables.tasks[tid] = stored
+	return 1, nil
+}
+
+// without returns ids minus id in a new slice.
+func without(ids []uint64, id uint64) []uint64 {
+	kept := []uint64{}
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+type memoryComments struct {
+	store *MemoryStore
+}
+
+func (r *memoryComments) Save(comment *models.Comment) (*models.Comment, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.tasks[comment.TaskID]; !ok {
+		return &models.Comment{}, ErrMissingReference
+	}
+	if _, ok := r.store.tables.users[comment.AuthorID]; !ok {
+		return &models.Comment{}, ErrMissingReference
+	}
+	stored := *comment
+	stored.CreatedAt = now()
+	stored.UpdatedAt = stored.CreatedAt
+	err := comment.DecryptBody()
+	if err != nil {
+		return &models.Comment{}, err
+	}
+	*r.store.lastCommentID++
+	stored.ID = *r.store.lastCommentID
+	comment.ID = stored.ID
+	r.store.tables.comments[stored.ID] = stored
+	return comment, nil
+}
+
+func (r *memoryComments) FindByID(cid uint64) (*models.Comment, error) {
+	r.store.mu.Lock()
+	stored, ok := r.store.tables.comments[cid]
+	r.store.mu.Unlock()
+	if !ok {
+		return &models.Comment{}, fmt.Errorf("comment %w", ErrNotFound)
+	}
+	err := stored.DecryptBody()
+	if err != nil {
+		return &models.Comment{}, err
+	}
+	return &stored, nil
+}
+
+func (r *memoryComments) FindByTask(tid uint64) (*[]models.Comment, error) {
+	r.store.mu.Lock()
+	comments := []models.Comment{}
+	for _, stored := range r.store.tables.comments {
+		if stored.TaskID == tid {
+			comments = append(comments, stored)
+		}
+	}
+	r.store.mu.Unlock()
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+	for i := range comments {
+		err := comments[i].DecryptBody()
+		if err != nil {
+			return &[]models.Comment{}, err
+		}
+	}
+	return &comments, nil
+}
+
+func (r *memoryComments) Update(cid uint64, comment *models.Comment) (*models.Comment, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	stored, ok := r.store.tables.comments[cid]
+	if !ok {
+		return &models.Comment{}, fmt.Errorf("comment %w", ErrNotFound)
+	}
+	*r.store.lastRevisionID++
+	r.store.tables.revisions = append(r.store.tables.revisions, models.CommentRevision{
+		ID:        *r.store.lastRevisionID,
+		CommentID: cid,
+		Body:      stored.Body,
+		CreatedAt: now(),
+	})
+	stored.Body = comment.Body
+	stored.UpdatedAt = now()
+	r.store.tables.comments[cid] = stored
+	err := stored.DecryptBody()
+	if err != nil {
+		return &models.Comment{}, err
+	}
+	return &stored, nil
+}
+
+func (r *memoryComments) Delete(cid uint64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.comments[cid]; !ok {
+		return 0, nil
+	}
+	delete(r.store.tables.comments, cid)
+	r.store.tables.dropOrphans()
+	return 1, nil
+}
+
+func (r *memoryComments) Revisions(cid uint64) (*[]models.CommentRevision, error) {
+	r.store.mu.Lock()
+	revisions := []models.CommentRevision{}
+	for _, revision := range r.store.tables.revisions {
+		if revision.CommentID == cid {
+			revisions = append(revisions, revision)
+		}
+	}
+	r.store.mu.Unlock()
+	for i := range revisions {
+		err := utils.Decrypt(&revisions[i].Body)
+		if err != nil {
+			return &[]models.CommentRevision{}, err
+		}
+	}
+	return &revisions, nil
+}
+
+type memoryAttachments struct {
+	store *MemoryStore
+}
+
+func (r *memoryAttachments) Save(attachment *models.Attachment) (*models.Attachment, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.tasks[attachment.TaskID]; !ok {
+		return &models.Attachment{}, ErrMissingReference
+	}
+	if _, ok := r.store.tables.users[attachment.AuthorID]; !ok {
+		return &models.Attachment{}, ErrMissingReference
+	}
+	for _, stored := range r.store.tables.attachments {
+		if stored.StorageKey == attachment.StorageKey {
+			return &models.Attachment{}, ErrDuplicate
+		}
+	}
+	stored := *attachment
+	stored.CreatedAt = now()
+	err := attachment.DecryptFilename()
+	if err != nil {
+		return &models.Attachment{}, err
+	}
+	*r.store.lastAttachmentID++
+	stored.ID = *r.store.lastAttachmentID
+	attachment.ID = stored.ID
+	r.store.tables.attachments[stored.ID] = stored
+	return attachment, nil
+}
+
+func (r *memoryAttachments) FindByID(aid uint64) (*models.Attachment, error) {
+	r.store.mu.Lock()
+	stored, ok := r.store.tables.attachments[aid]
+	r.store.mu.Unlock()
+	if !ok {
+		return &models.Attachment{}, fmt.Errorf("attachment %w", ErrNotFound)
+	}
+	err := stored.DecryptFilename()
+	if err != nil {
+		return &models.Attachment{}, err
+	}
+	return &stored, nil
+}
+
+func (r *memoryAttachments) FindByTask(tid uint64) (*[]models.Attachment, error) {
+	r.store.mu.Lock()
+	attachments := []models.Attachment{}
+	for _, stored := range r.store.tables.attachments {
+		if stored.TaskID == tid {
+			attachments = append(attachments, stored)
+		}
+	}
+	r.store.mu.Unlock()
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].ID < attachments[j].ID })
+	for i := range attachments {
+		err := attachments[i].DecryptFilename()
+		if err != nil {
+			return &[]models.Attachment{}, err
+		}
+	}
+	return &attachments, nil
+}
+
+func (r *memoryAttachments) Delete(aid uint64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.attachments[aid]; !ok {
+		return 0, nil
+	}
+	delete(r.store.tables.attachments, aid)
+	return 1, nil
+}
+
+type memoryTokens struct {
+	store *MemoryStore
+}
+
+func (r *memoryTokens) SaveRefreshToken(token *models.RefreshToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.users[token.UserID]; !ok {
+		return ErrMissingReference
+	}
+	for _, stored := range r.store.tables.refreshTokens {
+		if stored.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	*r.store.lastTokenID++
+	token.ID = *r.store.lastTokenID
+	stored := *token
+	stored.CreatedAt = now()
+	r.store.tables.refreshTokens[token.ID] = stored
+	return nil
+}
+
+func (r *memoryTokens) FindRefreshToken(hash string) (*models.RefreshToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, stored := range r.store.tables.refreshTokens {
+		if stored.TokenHash == hash {
+			return &stored, nil
+		}
+	}
+	return &models.RefreshToken{}, fmt.Errorf("refresh token %w", ErrNotFound)
+}
+
+// revoke revokes the refresh tokens that match and are not revoked yet.
+func (r *memoryTokens) revoke(match func(token models.RefreshToken) bool) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for id, token := range r.store.tables.refreshTokens {
+		if match(token) && !token.RevokedAt.Valid {
+			token.RevokedAt = sql.NullTime{Time: now(), Valid: true}
+			r.store.tables.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokens) RevokeRefreshToken(id uint64) error {
+	return r.revoke(func(token models.RefreshToken) bool { return token.ID == id })
+}
+
+func (r *memoryTokens) RevokeFamily(familyID string) error {
+	return r.revoke(func(token models.RefreshToken) bool { return token.FamilyID == familyID })
+}
+
+func (r *memoryTokens) RevokeUser(uid uint64) error {
+	return r.revoke(func(token models.RefreshToken) bool { return token.UserID == uid })
+}
+
+func (r *memoryTokens) Revoke(jti string, expiresAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.revokedTokens[jti]; !ok {
+		r.store.tables.revokedTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (r *memoryTokens) IsRevoked(jti string) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	_, ok := r.store.tables.revokedTokens[jti]
+	return ok, nil
+}
+
+type memorySingleUseTokens struct {
+	store *MemoryStore
+}
+
+func (r *memorySingleUseTokens) Save(token *models.SingleUseToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if _, ok := r.store.tables.users[token.UserID]; !ok {
+		return ErrMissingReference
+	}
+	for _, stored := range r.store.tables.singleUseTokens {
+		if stored.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	*r.store.lastSingleUseID++
+	token.ID = *r.store.lastSingleUseID
+	stored := *token
+	stored.CreatedAt = now()
+	r.store.tables.singleUseTokens[token.ID] = stored
+	return nil
+}
+
+func (r *memorySingleUseTokens) FindByHash(purpose, hash string) (*models.SingleUseToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, stored := range r.store.tables.singleUseTokens {
+		if stored.Purpose == purpose && stored.TokenHash == hash {
+			return &stored, nil
+		}
+	}
+	return &models.SingleUseToken{}, fmt.Errorf("token %w", ErrNotFound)
+}
+
+func (r *memorySingleUseTokens) Recent(purpose string, uid uint64, interval time.Duration) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	since := now().Add(-interval)
+	for _, stored := range r.store.tables.singleUseTokens {
+		if stored.UserID == uid && stored.Purpose == purpose && stored.CreatedAt.After(since) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memorySingleUseTokens) Use(purpose string, uid uint64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for id, stored := range r.store.tables.singleUseTokens {
+		if stored.UserID == uid && stored.Purpose == purpose && !stored.UsedAt.Valid {
+			stored.UsedAt = sql.NullTime{Time: now(), Valid: true}
+			r.store.tables.singleUseTokens[id] = stored
+		}
+	}
+	return nil
+}
+
+type memoryLoginAttempts struct {
+	store *MemoryStore
+}
+
+func (r *memoryLoginAttempts) Find(subject string) (*models.LoginAttempt, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	attempt, ok := r.store.tables.loginAttempts[subject]
+	if !ok {
+		return &models.LoginAttempt{Subject: subject}, nil
+	}
+	return &attempt, nil
+}
+
+func (r *memoryLoginAttempts) RecordFailure(subject string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	attempt, ok := r.store.tables.loginAttempts[subject]
+	lockOver := attempt.LockedUntil != nil && !attempt.LockedUntil.After(now)
+	switch {
+	case !ok:
+		attempt = models.LoginAttempt{Subject: subject, Failures: 1}
+	case attempt.LastFailureAt.Before(now.Add(-window)) || lockOver:
+		attempt.Failures = 1
+	default:
+		attempt.Failures++
+	}
+	if lockOver {
+		attempt.LockedUntil = nil
+	}
+	attempt.LastFailureAt = now
+	r.store.tables.loginAttempts[subject] = attempt
+	return &attempt, nil
+}
+
+func (r *memoryLoginAttempts) Lock(subject string, now, until time.Time) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	attempt, ok := r.store.tables.loginAttempts[subject]
+	if !ok || attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+		return false, nil
+	}
+	attempt.LockedUntil = &until
+	r.store.tables.loginAttempts[subject] = attempt
+	return true, nil
+}
+
+func (r *memoryLoginAttempts) Delete(subject string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	delete(r.store.tables.loginAttempts, subject)
+	return nil
+}
+
+type memoryOutbox struct {
+	store *MemoryStore
+}
+
+func (r *memoryOutbox) Save(ctx context.Context, messages []map[string]interface{}, controller string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, message := range messages {
+		*r.store.lastOutboxID++
+		r.store.tables.outbox = append(r.store.tables.outbox, models.OutboxMessage{
+			ID:          *r.store.lastOutboxID,
+			Controller:  controller,
+			Payload:     message,
+			RequestID:   logging.RequestID(ctx),
+			Traceparent: tracing.Traceparent(ctx),
+			CreatedAt:   now(),
+		})
+	}
+	return nil
+}
+
+// Pending needs no lock past the call, the tables are locked for the whole
+// of a transaction.
+func (r *memoryOutbox) Pending(limit int) (*[]models.OutboxMessage, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	messages := []models.OutboxMessage{}
+	for _, message := range r.store.tables.outbox {
+		if len(messages) == limit {
+			break
+		}
+		if !message.SentAt.Valid {
+			messages = append(messages, message)
+		}
+	}
+	return &messages, nil
+}
+
+func (r *memoryOutbox) MarkSent(ids []uint64, sentAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i, message := range r.store.tables.outbox {
+		for _, id := range ids {
+			if message.ID == id {
+				r.store.tables.outbox[i].SentAt = sql.NullTime{Time: sentAt.Truncate(time.Second), Valid: true}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *memoryOutbox) Prune(before time.Time) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	kept := []models.OutboxMessage{}
+	for _, message := range r.store.tables.outbox {
+		if !message.SentAt.Valid || !message.SentAt.Time.Before(before) {
+			kept = append(kept, message)
+		}
+	}
+	pruned := int64(len(r.store.tables.outbox) - len(kept))
+	r.store.tables.outbox = kept
+	return pruned, nil
+}
@@ -0,0 +1,441 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/vitorbiten/maintenance/api/app/logging"
+	"github.com/vitorbiten/maintenance/api/app/models"
+	"github.com/vitorbiten/maintenance/api/app/tracing"
+)
+
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlNoReferencedRow = 1452
+)
+
+// mysqlStore runs the model queries on db, or on tx inside a transaction,
+// with ctx when it was given one.
+type mysqlStore struct {
+	db  *sql.DB
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) conn() models.DBTX {
+	switch {
+	case s.ctx != nil && s.tx != nil:
+		return contextConn{ctx: s.ctx, conn: s.tx}
+	case s.ctx != nil:
+		return contextConn{ctx: s.ctx, conn: s.db}
+	case s.tx != nil:
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *mysqlStore) WithContext(ctx context.Context) Store {
+	return &mysqlStore{db: s.db, tx: s.tx, ctx: ctx}
+}
+
+// contextQueryer is implemented by both *sql.DB and *sql.Tx.
+type contextQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// contextConn lets the models, which take a DBTX, run their queries with ctx.
+type contextConn struct {
+	ctx  context.Context
+	conn contextQueryer
+}
+
+func (c contextConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c contextConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (s *mysqlStore) Users() UserRepository {
+	return &mysqlUsers{s}
+}
+
+func (s *mysqlStore) Tasks() TaskRepository {
+	return &mysqlTasks{s}
+}
+
+func (s *mysqlStore) Comments() CommentRepository {
+	return &mysqlComments{s}
+}
+
+func (s *mysqlStore) Attachments() AttachmentRepository {
+	return &mysqlAttachments{s}
+}
+
+func (s *mysqlStore) Tokens() TokenRepository {
+	return &mysqlTokens{s}
+}
+
+func (s *mysqlStore) SingleUseTokens() SingleUseTokenRepository {
+	return &mysqlSingleUseTokens{s}
+}
+
+func (s *mysqlStore) LoginAttempts() LoginAttemptRepository {
+	return &mysqlLoginAttempts{s}
+}
+
+func (s *mysqlStore) Outbox() OutboxRepository {
+	return &mysqlOutbox{s}
+}
+
+func (s *mysqlStore) Transaction(fn func(store Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = fn(&mysqlStore{db: s.db, tx: tx, ctx: s.ctx})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// translate maps constraint violations to the errors every store returns.
+func translate(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return ErrDuplicate
+		case mysqlNoReferencedRow:
+			return ErrMissingReference
+		}
+	}
+	return err
+}
+
+type mysqlUsers struct {
+	store *mysqlStore
+}
+
+func (r *mysqlUsers) Save(user *models.User) (*models.User, error) {
+	saved, err := user.SaveUser(r.store.conn())
+	return saved, translate(err)
+}
+
+func (r *mysqlUsers) FindByID(uid uint64) (*models.User, error) {
+	user := models.User{}
+	return user.FindUserByID(r.store.conn(), uid)
+}
+
+func (r *mysqlUsers) FindByEmail(email string) (*models.User, error) {
+	user := models.User{}
+	return user.FindUserByEmail(r.store.conn(), email)
+}
+
+func (r *mysqlUsers) FindAllTechnicians() (*[]models.User, error) {
+	user := models.User{}
+	return user.FindAllTechnicians(r.store.conn())
+}
+
+func (r *mysqlUsers) FindAllManagers() (*[]models.User, error) {
+	user := models.User{}
+	return user.FindAllManagers(r.store.conn())
+}
+
+func (r *mysqlUsers) Update(uid uint64, user *models.User) (*models.User, error) {
+	updated, err := user.UpdateAUser(r.store.conn(), uid)
+	return updated, translate(err)
+}
+
+func (r *mysqlUsers) UpdateRole(uid uint64, role string) (*models.User, error) {
+	user := models.User{}
+	return user.UpdateRole(r.store.conn(), uid, role)
+}
+
+func (r *mysqlUsers) Deactivate(uid uint64) (*models.User, error) {
+	user := models.User{}
+	_, err := user.FindUserByID(r.store.conn(), uid)
+	if err != nil || !user.Active() {
+		return &user, err
+	}
+	now := time.Now().Truncate(time.Second)
+	return user.SetDeactivatedAt(r.store.conn(), uid, &now)
+}
+
+func (r *mysqlUsers) Reactivate(uid uint64) (*models.User, error) {
+	user := models.User{}
+	return user.SetDeactivatedAt(r.store.conn(), uid, nil)
+}
+
+func (r *mysqlUsers) Verify(uid uint64) (*models.User, error) {
+	user := models.User{}
+	return user.SetVerifiedAt(r.store.conn(), uid, time.Now())
+}
+
+func (r *mysqlUsers) ResetPassword(uid uint64, password string) (*models.User, error) {
+	user := models.User{Password: password}
+	err := user.ResetPassword(r.store.conn(), uid, time.Now())
+	if err != nil {
+		return &models.User{}, err
+	}
+	return user.FindUserByID(r.store.conn(), uid)
+}
+
+func (r *mysqlUsers) Delete(uid uint64) (int64, error) {
+	user := models.User{}
+	return user.DeleteAUser(r.store.conn(), uid)
+}
+
+type mysqlTasks struct {
+	store *mysqlStore
+}
+
+func (r *mysqlTasks) Save(task *models.Task) (*models.Task, error) {
+	var id int64
+	err := r.store.Transaction(func(store Store) error {
+		var err error
+		id, err = task.SaveTask(store.(*mysqlStore).conn())
+		return err
+	})
+	if err != nil {
+		return &models.Task{}, translate(err)
+	}
+	task.ID = uint64(id)
+	if task.AssigneeIDs == nil {
+		task.AssigneeIDs = []uint64{}
+	}
+	return task, nil
+}
+
+func (r *mysqlTasks) FindByID(tid uint64) (*models.Task, error) {
+	task := models.Task{}
+	return task.FindTaskByID(r.store.conn(), tid)
+}
+
+func (r *mysqlTasks) Find(filter *models.TaskFilter) (*models.TaskPage, error) {
+	task := models.Task{}
+	return task.FindTasks(r.store.conn(), filter)
+}
+
+func (r *mysqlTasks) Search(search *models.TaskSearch) (*models.TaskSearchResults, error) {
+	task := models.Task{}
+	return task.SearchTasks(r.store.conn(), search)
+}
+
+func (r *mysqlTasks) Update(tid uint64, task *models.Task) (*models.Task, error) {
+	var updated *models.Task
+	err := r.store.Transaction(func(store Store) error {
+		var err error
+		updated, err = task.UpdateATask(store.(*mysqlStore).conn(), tid)
+		return err
+	})
+	return updated, err
+}
+
+func (r *mysqlTasks) UpdateStatus(task *models.Task, to string) (*models.Task, error) {
+	return task.UpdateStatus(r.store.conn(), task.ID, to)
+}
+
+func (r *mysqlTasks) Delete(tid uint64) (int64, error) {
+	task := models.Task{}
+	return task.DeleteATask(r.store.conn(), tid)
+}
+
+func (r *mysqlTasks) Assign(tid uint64, uids []uint64) ([]uint64, error) {
+	task := models.Task{}
+	added, err := task.SetAssignees(r.store.conn(), tid, uids)
+	return added, translate(err)
+}
+
+func (r *mysqlTasks) Unassign(tid uint64, uid uint64) (int64, error) {
+	task := models.Task{}
+	return task.DeleteAssignee(r.store.conn(), tid, uid)
+}
+
+type mysqlComments struct {
+	store *mysqlStore
+}
+
+func (r *mysqlComments) Save(comment *models.Comment) (*models.Comment, error) {
+	id, err := comment.SaveComment(r.store.conn())
+	if err != nil {
+		return &models.Comment{}, translate(err)
+	}
+	comment.ID = uint64(id)
+	return comment, nil
+}
+
+func (r *mysqlComments) FindByID(cid uint64) (*models.Comment, error) {
+	comment := models.Comment{}
+	return comment.FindCommentByID(r.store.conn(), cid)
+}
+
+func (r *mysqlComments) FindByTask(tid uint64) (*[]models.Comment, error) {
+	comment := models.Comment{}
+	return comment.FindCommentsByTask(r.store.conn(), tid)
+}
+
+func (r *mysqlComments) Update(cid uint64, comment *models.Comment) (*models.Comment, error) {
+	var updated *models.Comment
+	err := r.store.Transaction(func(store Store) error {
+		var err error
+		updated, err = comment.UpdateAComment(store.(*mysqlStore).conn(), cid)
+		return err
+	})
+	return updated, err
+}
+
+func (r *mysqlComments) Delete(cid uint64) (int64, error) {
+	comment := models.Comment{}
+	return comment.DeleteAComment(r.store.conn(), cid)
+}
+
+func (r *mysqlComments) Revisions(cid uint64) (*[]models.CommentRevision, error) {
+	comment := models.Comment{}
+	return comment.FindCommentRevisions(r.store.conn(), cid)
+}
+
+type mysqlAttachments struct {
+	store *mysqlStore
+}
+
+func (r *mysqlAttachments) Save(attachment *models.Attachment) (*models.Attachment, error) {
+	id, err := attachment.SaveAttachment(r.store.conn())
+	if err != nil {
+		return &models.Attachment{}, translate(err)
+	}
+	attachment.ID = uint64(id)
+	return attachment, nil
+}
+
+func (r *mysqlAttachments) FindByID(aid uint64) (*models.Attachment, error) {
+	attachment := models.Attachment{}
+	return attachment.FindAttachmentByID(r.store.conn(), aid)
+}
+
+func (r *mysqlAttachments) FindByTask(tid uint64) (*[]models.Attachment, error) {
+	attachment := models.Attachment{}
+	return attachment.FindAttachmentsByTask(r.store.conn(), tid)
+}
+
+func (r *mysqlAttachments) Delete(aid uint64) (int64, error) {
+	attachment := models.Attachment{}
+	return attachment.DeleteAnAttachment(r.store.conn(), aid)
+}
+
+type mysqlTokens struct {
+	store *mysqlStore
+}
+
+func (r *mysqlTokens) SaveRefreshToken(token *models.RefreshToken) error {
+	return translate(token.SaveRefreshToken(r.store.conn()))
+}
+
+func (r *mysqlTokens) FindRefreshToken(hash string) (*models.RefreshToken, error) {
+	token := models.RefreshToken{}
+	return token.FindRefreshTokenByHash(r.store.conn(), hash)
+}
+
+func (r *mysqlTokens) RevokeRefreshToken(id uint64) error {
+	return models.RevokeRefreshToken(r.store.conn(), id)
+}
+
+func (r *mysqlTokens) RevokeFamily(familyID string) error {
+	return models.RevokeRefreshTokenFamily(r.store.conn(), familyID)
+}
+
+func (r *mysqlTokens) RevokeUser(uid uint64) error {
+	return models.RevokeUserRefreshTokens(r.store.conn(), uid)
+}
+
+func (r *mysqlTokens) Revoke(jti string, expiresAt time.Time) error {
+	return models.RevokeToken(r.store.conn(), jti, expiresAt)
+}
+
+func (r *mysqlTokens) IsRevoked(jti string) (bool, error) {
+	return models.IsTokenRevoked(r.store.conn(), jti)
+}
+
+type mysqlSingleUseTokens struct {
+	store *mysqlStore
+}
+
+func (r *mysqlSingleUseTokens) Save(token *models.SingleUseToken) error {
+	return translate(token.SaveSingleUseToken(r.store.conn()))
+}
+
+func (r *mysqlSingleUseTokens) FindByHash(purpose, hash string) (*models.SingleUseToken, error) {
+	token := models.SingleUseToken{}
+	return token.FindSingleUseTokenByHash(r.store.conn(), purpose, hash)
+}
+
+func (r *mysqlSingleUseTokens) Recent(purpose string, uid uint64, interval time.Duration) (bool, error) {
+	return models.RecentSingleUseToken(r.store.conn(), purpose, uid, interval)
+}
+
+func (r *mysqlSingleUseTokens) Use(purpose string, uid uint64) error {
+	return models.UseSingleUseTokens(r.store.conn(), purpose, uid)
+}
+
+type mysqlLoginAttempts struct {
+	store *mysqlStore
+}
+
+func (r *mysqlLoginAttempts) Find(subject string) (*models.LoginAttempt, error) {
+	return models.FindLoginAttempt(r.store.conn(), subject)
+}
+
+func (r *mysqlLoginAttempts) RecordFailure(subject string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	return models.RecordLoginFailure(r.store.conn(), subject, now, window)
+}
+
+func (r *mysqlLoginAttempts) Lock(subject string, now, until time.Time) (bool, error) {
+	return models.LockLogin(r.store.conn(), subject, now, until)
+}
+
+func (r *mysqlLoginAttempts) Delete(subject string) error {
+	return models.DeleteLoginAttempt(r.store.conn(), subject)
+}
+
+type mysqlOutbox struct {
+	store *mysqlStore
+}
+
+func (r *mysqlOutbox) Save(ctx context.Context, messages []map[string]interface{}, controller string) error {
+	return models.SaveOutboxMessages(r.store.conn(), messages, controller, logging.RequestID(ctx), tracing.Traceparent(ctx))
+}
+
+func (r *mysqlOutbox) Pending(limit int) (*[]models.OutboxMessage, error) {
+	return models.FindPendingOutboxMessages(r.store.conn(), limit)
+}
+
+func (r *mysqlOutbox) MarkSent(ids []uint64, sentAt time.Time) error {
+	return models.MarkOutboxMessagesSent(r.store.conn(), ids, sentAt)
+}
+
+func (r *mysqlOutbox) Prune(before time.Time) (int64, error) {
+	return models.PruneOutboxMessages(r.store.conn(), before)
+}
@@ -0,0 +1,176 @@
+// Package repository hides where users, tasks, comments and attachments are
+// stored. Controllers go through a Store, backed by MySQL in the API and by
+// memory where a database is not available.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vitorbiten/maintenance/api/app/models"
+)
+
+var (
+	ErrDuplicate        = errors.New("duplicate entry")
+	ErrMissingReference = errors.New("referenced entry not found")
+	// ErrNotFound is wrapped by every lookup of a missing entry, match it
+	// with errors.Is.
+	ErrNotFound = models.ErrNotFound
+)
+
+type UserRepository interface {
+	// Save hashes the password and stores the user, a technician unless
+	// UserType says otherwise. Nicknames and emails are unique.
+	Save(user *models.User) (*models.User, error)
+	FindByID(uid uint64) (*models.User, error)
+	// FindByEmail returns the id, nickname, email, password hash and
+	// deactivation of the user, enough to sign in and to write to them.
+	FindByEmail(email string) (*models.User, error)
+	FindAllTechnicians() (*[]models.User, error)
+	// FindAllManagers returns the active managers.
+	FindAllManagers() (*[]models.User, error)
+	Update(uid uint64, user *models.User) (*models.User, error)
+	UpdateRole(uid uint64, role string) (*models.User, error)
+	// Deactivate marks the user as deactivated now unless they already are,
+	// Reactivate clears it.
+	Deactivate(uid uint64) (*models.User, error)
+	Reactivate(uid uint64) (*models.User, error)
+	// Verify marks the email of the user as verified now unless it already
+	// is. Users start unverified and Update unverifies them when their email
+	// changes.
+	Verify(uid uint64) (*models.User, error)
+	// ResetPassword hashes password and stores it as changed now, access
+	// tokens issued before are rejected.
+	ResetPassword(uid uint64, password string) (*models.User, error)
+	// Delete removes the user with their tasks and returns how many users were
+	// removed.
+	Delete(uid uint64) (int64, error)
+}
+
+type TaskRepository interface {
+	// Save stores a task with an encrypted summary and its assignees, and
+	// decrypts the summary in place.
+	Save(task *models.Task) (*models.Task, error)
+	FindByID(tid uint64) (*models.Task, error)
+	Find(filter *models.TaskFilter) (*models.TaskPage, error)
+	// Search ranks the tasks whose summaries contain the words of the query,
+	// matching blind index tokens instead of plaintext.
+	Search(search *models.TaskSearch) (*models.TaskSearchResults, error)
+	// Update stores the new summary and date and reindexes the summary.
+	Update(tid uint64, task *models.Task) (*models.Task, error)
+	// UpdateStatus moves the task to another status unless it changed since
+	// the task was read.
+	UpdateStatus(task *models.Task, to string) (*models.Task, error)
+	Delete(tid uint64) (int64, error)
+	// Assign replaces the assignees of the task and returns the ones that
+	// were not assigned before.
+	Assign(tid uint64, uids []uint64) ([]uint64, error)
+	Unassign(tid uint64, uid uint64) (int64, error)
+}
+
+type CommentRepository interface {
+	// Save stores a comment with an encrypted body, and decrypts the body in
+	// place.
+	Save(comment *models.Comment) (*models.Comment, error)
+	FindByID(cid uint64) (*models.Comment, error)
+	// FindByTask returns the thread of a task, oldest first.
+	FindByTask(tid uint64) (*[]models.Comment, error)
+	// Update replaces the body and keeps the previous one as a revision.
+	Update(cid uint64, comment *models.Comment) (*models.Comment, error)
+	Delete(cid uint64) (int64, error)
+	// Revisions returns the previous bodies of a comment, oldest first.
+	Revisions(cid uint64) (*[]models.CommentRevision, error)
+}
+
+type AttachmentRepository interface {
+	// Save stores an attachment with a sealed filename and file key, and
+	// decrypts the filename in place.
+	Save(attachment *models.Attachment) (*models.Attachment, error)
+	FindByID(aid uint64) (*models.Attachment, error)
+	// FindByTask returns the attachments of a task, oldest first.
+	FindByTask(tid uint64) (*[]models.Attachment, error)
+	Delete(aid uint64) (int64, error)
+}
+
+type TokenRepository interface {
+	// SaveRefreshToken stores the hash of a refresh token.
+	SaveRefreshToken(token *models.RefreshToken) error
+	// FindRefreshToken returns the refresh token with the given hash, locked
+	// until the transaction ends so it is rotated only once.
+	FindRefreshToken(hash string) (*models.RefreshToken, error)
+	// RevokeRefreshToken, RevokeFamily and RevokeUser revoke the refresh
+	// tokens that are not revoked yet, by id, by login or by owner.
+	RevokeRefreshToken(id uint64) error
+	RevokeFamily(familyID string) error
+	RevokeUser(uid uint64) error
+	// Revoke rejects the access token jti until it expires.
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+type SingleUseTokenRepository interface {
+	// Save stores the hash of a single use token.
+	Save(token *models.SingleUseToken) error
+	// FindByHash returns the token of purpose with the given hash, locked
+	// until the transaction ends so it is used only once.
+	FindByHash(purpose, hash string) (*models.SingleUseToken, error)
+	// Recent reports whether a token of purpose was saved for the user
+	// within the interval.
+	Recent(purpose string, uid uint64, interval time.Duration) (bool, error)
+	// Use marks the unused tokens of purpose of the user as used, once one is
+	// used or when a new one replaces them.
+	Use(purpose string, uid uint64) error
+}
+
+type LoginAttemptRepository interface {
+	// Find returns the failures of subject, none when it never failed.
+	Find(subject string) (*models.LoginAttempt, error)
+	// RecordFailure counts a failure at now, the count starts over when the
+	// previous failure is older than window or the last lockout is over.
+	RecordFailure(subject string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// Lock locks subject until the given time and reports whether this call
+	// locked it, a subject still locked at now is left alone.
+	Lock(subject string, now, until time.Time) (bool, error)
+	// Delete forgets the failures of subject.
+	Delete(subject string) error
+}
+
+type OutboxRepository interface {
+	// Save queues the messages for controller, tagged with the request id
+	// and the trace context of ctx.
+	Save(ctx context.Context, messages []map[string]interface{}, controller string) error
+	// Pending returns the oldest unsent messages, up to limit. In a
+	// transaction they stay locked until it ends, so concurrent relays do
+	// not publish the same messages.
+	Pending(limit int) (*[]models.OutboxMessage, error)
+	MarkSent(ids []uint64, sentAt time.Time) error
+	// Prune deletes the messages sent before the given time and returns how
+	// many it deleted, pending messages are kept whatever their age.
+	Prune(before time.Time) (int64, error)
+}
+
+type Store interface {
+	Users() UserRepository
+	Tasks() TaskRepository
+	Comments() CommentRepository
+	Attachments() AttachmentRepository
+	Tokens() TokenRepository
+	SingleUseTokens() SingleUseTokenRepository
+	LoginAttempts() LoginAttemptRepository
+	Outbox() OutboxRepository
+	// Transaction runs fn with a store whose changes are kept only if fn
+	// returns nil.
+	Transaction(fn func(store Store) error) error
+	// WithContext returns the store with its queries bound to ctx, so they
+	// are cancelled with the request and traced under it.
+	WithContext(ctx context.Context) Store
+}
+
+// Default is the store the controllers use.
+var Default Store
+
+func LoadMySQL(db *sql.DB) {
+	Default = NewMySQLStore(db)
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+	"github.com/vitorbiten/maintenance/api/app/adapters"
+	"github.com/vitorbiten/maintenance/api/app/migrations"
+)
+
+func TestMain(m *testing.M) {
+	err := godotenv.Load(os.ExpandEnv("../../.env"))
+	if err != nil {
+		log.Printf("Error getting env %v\n", err)
+	}
+	if os.Getenv("ENCRYPTION_KEYS") == "" {
+		os.Setenv("ENCRYPTION_KEYS", "test:XL8KDbDXhcW3SozhAdbf5aYZf+So0zTugi970gpGBVE=")
+	}
+	if os.Getenv("SEARCH_INDEX_KEY") == "" {
+		os.Setenv("SEARCH_INDEX_KEY", "9x9y/ahBJQ4qAliUpatodaZ1DBwEjkU12SzobCFTEpU=")
+	}
+	os.Exit(m.Run())
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStoreContract(t, func() Store { return NewMemoryStore() })
+}
+
+func TestMySQLStore(t *testing.T) {
+	adapters.LoadTestDatabase()
+	if err := adapters.DB.Ping(); err != nil {
+		t.Skipf("test database unavailable: %v", err)
+	}
+	_, err := migrations.Up(adapters.DB)
+	if err != nil {
+		t.Fatalf("cannot apply migrations: %v", err)
+	}
+	testStoreContract(t, func() Store {
+		for _, table := range []string{"outbox", "login_attempts", "revoked_tokens", "refresh_tokens", "tasks", "users"} {
+			_, err := adapters.DB.Exec("DELETE FROM `" + table + "`;")
+			if err != nil {
+				t.Fatalf("cannot erase %s table: %v", table, err)
+			}
+		}
+		return NewMySQLStore(adapters.DB)
+	})
+}
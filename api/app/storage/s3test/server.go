@@ -0,0 +1,108 @@
+// Package s3test provides an in-process stand-in for an S3-compatible
+// service that keeps objects in memory, in the spirit of net/http/httptest.
+// It checks that requests carry Signature Version 4 headers for its
+// credentials, not the signatures themselves.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+const (
+	AccessKeyID     = "s3test-access-key"
+	SecretAccessKey = "s3test-secret-key"
+	Region          = "us-east-1"
+	Bucket          = "attachments"
+)
+
+type Server struct {
+	URL string
+
+	server  *httptest.Server
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func NewServer() *Server {
+	s := &Server{objects: map[string][]byte{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// Object returns what is stored under key, as the service would keep it.
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	credential := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/", AccessKeyID)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), credential) || r.Header.Get("X-Amz-Date") == "" {
+		reply(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+Bucket+"/")
+	if key == r.URL.Path || key == "" {
+		reply(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			reply(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			reply(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != "UNSIGNED-PAYLOAD" {
+			sum := sha256.Sum256(body)
+			if hash != hex.EncodeToString(sum[:]) {
+				reply(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+				return
+			}
+		}
+		s.mu.Lock()
+		s.objects[key] = body
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		object, ok := s.Object(key)
+		if !ok {
+			reply(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(object)))
+		_, _ = w.Write(object)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		reply(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func reply(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
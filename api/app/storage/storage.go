@@ -0,0 +1,82 @@
+// Package storage keeps the attachment files out of the database, on the
+// local filesystem or in an S3-compatible bucket. It only sees ciphertext,
+// files are sealed before they are stored.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("file not found")
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing what was there.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns ErrNotFound when nothing is stored under key, the caller
+	// closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete does nothing when nothing is stored under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Default is the storage the controllers use.
+var Default Storage
+
+// validKey accepts slash separated keys of letters, digits, dashes and
+// underscores, so a key can neither leave the local root nor need escaping.
+func validKey(key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" {
+			return ErrInvalidKey
+		}
+		for _, r := range segment {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return ErrInvalidKey
+			}
+		}
+	}
+	return nil
+}
+
+// FromEnv builds the storage ATTACHMENT_STORAGE names: "local" (the default)
+// keeps files under ATTACHMENT_DIR, "s3" keeps them in S3_BUCKET.
+func FromEnv() (Storage, error) {
+	switch driver := os.Getenv("ATTACHMENT_STORAGE"); driver {
+	case "", "local":
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		return NewLocal(dir)
+	case "s3":
+		s3 := &S3{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		if s3.Endpoint == "" {
+			s3.Endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+		}
+		if s3.Region == "" || s3.Bucket == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			return nil, errors.New("S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("unknown ATTACHMENT_STORAGE %q", driver)
+	}
+}
@@ -0,0 +1,108 @@
+// Package tracing sets up OpenTelemetry: spans are exported over OTLP/HTTP
+// when OTEL_EXPORTER_OTLP_ENDPOINT is set, and the W3C trace context travels
+// in the AMQP headers so the worker continues the trace of the request.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/vitorbiten/maintenance/api"
+
+// Tracer starts the spans of the API's own code.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup installs the tracer provider and returns its shutdown, which flushes
+// the pending spans. Without an OTLP endpoint spans are still created, so
+// trace ids reach the logs and the worker, they are just not exported.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(newResource(service))}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// UseInMemory installs a tracer provider that keeps the ended spans in the
+// returned exporter, for tests.
+func UseInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(newResource("maintenance-api")),
+	))
+	otel.SetTextMapPropagator(propagator)
+	return exporter
+}
+
+func newResource(service string) *resource.Resource {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	return resource.NewSchemaless(semconv.ServiceName(service))
+}
+
+// Headers adapts AMQP headers to a propagation carrier.
+type Headers amqp.Table
+
+func (h Headers) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h Headers) Set(key, value string) {
+	h[key] = value
+}
+
+func (h Headers) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Inject writes the trace context of ctx to headers.
+func Inject(ctx context.Context, headers amqp.Table) {
+	propagator.Inject(ctx, Headers(headers))
+}
+
+// Traceparent returns the W3C traceparent of the span in ctx, or "" when
+// there is none. The outbox stores it so the relay publishes in the trace of
+// the request that wrote the message.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceparent returns ctx with the remote span described by traceparent
+// as its parent, ctx is returned as is when traceparent is empty or invalid.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
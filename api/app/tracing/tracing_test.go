@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestTraceparent(t *testing.T) {
+	exporter := UseInMemory()
+	ctx, span := Tracer().Start(context.Background(), "create task")
+	traceparent := Traceparent(ctx)
+	span.End()
+	assert.Equal(t, len(traceparent), 55)
+	assert.Equal(t, Traceparent(context.Background()), "")
+
+	// the relay continues the trace from the stored traceparent
+	ctx = WithTraceparent(context.Background(), traceparent)
+	ctx, child := Tracer().Start(ctx, "publish")
+	child.End()
+	assert.Equal(t, child.SpanContext().TraceID(), span.SpanContext().TraceID())
+
+	headers := amqp.Table{"controller": "notification"}
+	Inject(ctx, headers)
+	assert.Equal(t, headers["traceparent"].(string)[3:35], span.SpanContext().TraceID().String())
+	assert.Equal(t, headers["controller"], "notification")
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 2)
+	assert.Equal(t, spans[1].Parent.SpanID(), span.SpanContext().SpanID())
+
+	assert.Equal(t, trace.SpanContextFromContext(WithTraceparent(context.Background(), "garbage")).IsValid(), false)
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MinWordLength is the shortest word, in letters, that is indexed.
+const MinWordLength = 2
+
+// BlindIndex turns words into keyed HMAC tokens, so a summary can be matched
+// against a query without the database ever seeing either in plaintext.
+// Equal words give equal tokens, which is what makes them searchable and also
+// what the tokens leak: how often a word repeats across tasks.
+type BlindIndex struct {
+	key []byte
+}
+
+// ParseBlindIndex reads a SEARCH_INDEX_KEY, a base64 32 byte key. Changing
+// it invalidates every stored token until the tasks are reindexed.
+func ParseBlindIndex(config string) (*BlindIndex, error) {
+	if config == "" {
+		return nil, errors.New("no search index key configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(config)
+	if err != nil {
+		return nil, fmt.Errorf("search index key is not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("search index key must be 32 bytes, got %d", len(key))
+	}
+	return &BlindIndex{key: key}, nil
+}
+
+// Token returns the hex encoded first 16 bytes of the HMAC-SHA256 of a
+// normalized word.
+func (b *BlindIndex) Token(word string) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(word))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Tokens returns the token of every word of text with how many times the word
+// appears.
+func (b *BlindIndex) Tokens(text string) map[string]int {
+	tokens := map[string]int{}
+	for word, count := range Words(text) {
+		tokens[b.Token(word)] = count
+	}
+	return tokens
+}
+
+var foldMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// Words splits text on anything that is not a letter or a digit and counts
+// the lowercased, accent free words with at least MinWordLength letters, so
+// "Manutenção" and "manutencao" are the same word.
+func Words(text string) map[string]int {
+	folded, _, err := transform.String(foldMarks, text)
+	if err != nil {
+		folded = text
+	}
+	words := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < MinWordLength {
+			continue
+		}
+		words[word]++
+	}
+	return words
+}
+
+var (
+	blindIndexMu sync.Mutex
+	blindIndex   *BlindIndex
+)
+
+// CurrentBlindIndex returns the index summaries are tokenized with, loading
+// it from SEARCH_INDEX_KEY the first time.
+func CurrentBlindIndex() (*BlindIndex, error) {
+	blindIndexMu.Lock()
+	defer blindIndexMu.Unlock()
+	if blindIndex == nil {
+		loaded, err := ParseBlindIndex(os.Getenv("SEARCH_INDEX_KEY"))
+		if err != nil {
+			return nil, err
+		}
+		blindIndex = loaded
+	}
+	return blindIndex, nil
+}
+
+// SetBlindIndex replaces the index summaries are tokenized with, nil reloads
+// it from the environment on next use.
+func SetBlindIndex(b *BlindIndex) {
+	blindIndexMu.Lock()
+	defer blindIndexMu.Unlock()
+	blindIndex = b
+}
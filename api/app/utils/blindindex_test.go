@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const searchKey = "9x9y/ahBJQ4qAliUpatodaZ1DBwEjkU12SzobCFTEpU="
+
+func TestWords(t *testing.T) {
+	samples := []struct {
+		text  string
+		words map[string]int
+	}{
+		{text: "Fix the air conditioner", words: map[string]int{"fix": 1, "the": 1, "air": 1, "conditioner": 1}},
+		{text: "Manutenção do AR-condicionado, ar 2", words: map[string]int{"manutencao": 1, "do": 1, "ar": 2, "condicionado": 1}},
+		{text: "a b c", words: map[string]int{}},
+		{text: "", words: map[string]int{}},
+	}
+	for _, v := range samples {
+		assert.Equal(t, Words(v.text), v.words)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	index, err := ParseBlindIndex(searchKey)
+	assert.Equal(t, err, nil)
+	tokens := index.Tokens("Elevator stuck, elevator alarm")
+	assert.Equal(t, len(tokens), 3)
+	assert.Equal(t, tokens[index.Token("elevator")], 2)
+	assert.Equal(t, len(index.Token("elevator")), 32)
+	assert.Equal(t, index.Tokens("ELEVATOR")[index.Token("elevator")], 1)
+	assert.NotEqual(t, index.Token("elevator"), index.Token("alarm"))
+
+	other, err := ParseBlindIndex(newKey[len("new:"):])
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, other.Token("elevator"), index.Token("elevator"))
+
+	samples := []struct {
+		config       string
+		errorMessage string
+	}{
+		{config: "", errorMessage: "no search index key configured"},
+		{config: "???", errorMessage: "search index key is not base64"},
+		{config: "c2hvcnQ=", errorMessage: "search index key must be 32 bytes, got 5"},
+	}
+	for _, v := range samples {
+		_, err := ParseBlindIndex(v.config)
+		if err == nil || !strings.Contains(err.Error(), v.errorMessage) {
+			t.Errorf("ParseBlindIndex(%q) = %v, want %q", v.config, err, v.errorMessage)
+		}
+	}
+}
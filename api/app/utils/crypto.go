@@ -3,11 +3,26 @@ package utils
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 )
 
-var bytes = []byte{35, 46, 57, 24, 85, 35, 24, 74, 87, 35, 88, 98, 66, 32, 14, 05}
+// legacyIV is the fixed IV the CFB ciphertexts written before key IDs were
+// introduced use. They are still read so `reencrypt` can rewrite them.
+var legacyIV = []byte{35, 46, 57, 24, 85, 35, 24, 74, 87, 35, 88, 98, 66, 32, 14, 05}
+
+const keyIDSeparator = ":"
+
+var (
+	ErrUnknownKey = errors.New("unknown encryption key")
+	ErrCiphertext = errors.New("malformed ciphertext")
+)
 
 func Encode(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
@@ -21,28 +36,165 @@ func Decode(s string) []byte {
 	return data
 }
 
-func Encrypt(text *string) error {
+// Keyring seals with its active key and opens with any key it holds, so old
+// keys can stay around decrypt-only until every record is re-encrypted.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// ParseKeyring reads keys in the ENCRYPTION_KEYS format, a comma separated
+// list of id:base64 pairs of 32 byte keys. The first key is the active one.
+func ParseKeyring(config string) (*Keyring, error) {
+	keyring := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, keyIDSeparator)
+		if !found || id == "" {
+			return nil, fmt.Errorf("encryption key %q has no id", entry)
+		}
+		if _, ok := keyring.keys[id]; ok {
+			return nil, fmt.Errorf("encryption key %s is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s is not base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = aead
+		if keyring.active == "" {
+			keyring.active = id
+		}
+	}
+	if keyring.active == "" {
+		return nil, errors.New("no encryption keys configured")
+	}
+	return keyring, nil
+}
+
+// ActiveKeyID returns the id of the key new ciphertexts are sealed with.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Seal encrypts plainText with the active key and a random nonce, returning
+// id:base64(nonce|ciphertext|tag).
+func (k *Keyring) Seal(plainText string) (string, error) {
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plainText), []byte(k.active))
+	return k.active + keyIDSeparator + Encode(sealed), nil
+}
+
+// Open decrypts a value produced by Seal with any key of the keyring. Values
+// without a key id are legacy CFB ciphertexts keyed by API_SECRET.
+func (k *Keyring) Open(text string) (string, error) {
+	id, encoded, found := strings.Cut(text, keyIDSeparator)
+	if !found {
+		return decryptLegacy(text)
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrCiphertext
+	}
+	nonce, cipherText := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plainText, err := aead.Open(nil, nonce, cipherText, []byte(id))
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	return string(plainText), nil
+}
+
+// Stale reports whether text was not sealed with the active key.
+func (k *Keyring) Stale(text string) bool {
+	return !strings.HasPrefix(text, k.active+keyIDSeparator)
+}
+
+func decryptLegacy(text string) (string, error) {
 	block, err := aes.NewCipher([]byte(os.Getenv("API_SECRET")))
+	if err != nil {
+		return "", err
+	}
+	cipherText, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", ErrCiphertext
+	}
+	cfb := cipher.NewCFBDecrypter(block, legacyIV)
+	plainText := make([]byte, len(cipherText))
+	cfb.XORKeyStream(plainText, cipherText)
+	return string(plainText), nil
+}
+
+var (
+	keyringMu sync.Mutex
+	keyring   *Keyring
+)
+
+// CurrentKeyring returns the keyring Encrypt and Decrypt use, loading it from
+// ENCRYPTION_KEYS the first time.
+func CurrentKeyring() (*Keyring, error) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	if keyring == nil {
+		loaded, err := ParseKeyring(os.Getenv("ENCRYPTION_KEYS"))
+		if err != nil {
+			return nil, err
+		}
+		keyring = loaded
+	}
+	return keyring, nil
+}
+
+// SetKeyring replaces the keyring Encrypt and Decrypt use, nil reloads it
+// from the environment on next use.
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+func Encrypt(text *string) error {
+	k, err := CurrentKeyring()
+	if err != nil {
+		return err
+	}
+	sealed, err := k.Seal(*text)
 	if err != nil {
 		return err
 	}
-	plainText := []byte(*text)
-	cfb := cipher.NewCFBEncrypter(block, bytes)
-	cipherText := make([]byte, len(plainText))
-	cfb.XORKeyStream(cipherText, plainText)
-	*text = Encode(cipherText)
+	*text = sealed
 	return nil
 }
 
 func Decrypt(text *string) error {
-	block, err := aes.NewCipher([]byte(os.Getenv("API_SECRET")))
+	k, err := CurrentKeyring()
 	if err != nil {
 		return err
 	}
-	cipherText := Decode(*text)
-	cfb := cipher.NewCFBDecrypter(block, bytes)
-	plainText := make([]byte, len(cipherText))
-	cfb.XORKeyStream(plainText, cipherText)
-	*text = string(plainText)
+	plainText, err := k.Open(*text)
+	if err != nil {
+		return err
+	}
+	*text = plainText
 	return nil
 }
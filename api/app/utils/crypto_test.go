@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const (
+	oldKey = "old:9x9y/ahBJQ4qAliUpatodaZ1DBwEjkU12SzobCFTEpU="
+	newKey = "new:XL8KDbDXhcW3SozhAdbf5aYZf+So0zTugi970gpGBVE="
+)
+
+func TestParseKeyring(t *testing.T) {
+	samples := []struct {
+		config       string
+		active       string
+		errorMessage string
+	}{
+		{config: newKey, active: "new"},
+		{config: newKey + ", " + oldKey, active: "new"},
+		{config: "", errorMessage: "no encryption keys configured"},
+		{config: "XL8KDbDXhcW3SozhAdbf5aYZf+So0zTugi970gpGBVE=", errorMessage: "has no id"},
+		{config: "new:c2hvcnQ=", errorMessage: "encryption key new must be 32 bytes, got 5"},
+		{config: "new:???", errorMessage: "encryption key new is not base64"},
+		{config: newKey + "," + newKey, errorMessage: "encryption key new is listed twice"},
+	}
+	for _, v := range samples {
+		keyring, err := ParseKeyring(v.config)
+		if v.errorMessage != "" {
+			if err == nil || !strings.Contains(err.Error(), v.errorMessage) {
+				t.Errorf("ParseKeyring(%q) = %v, want %q", v.config, err, v.errorMessage)
+			}
+			continue
+		}
+		assert.Equal(t, err, nil)
+		assert.Equal(t, keyring.ActiveKeyID(), v.active)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	before, err := ParseKeyring(oldKey)
+	assert.Equal(t, err, nil)
+	sealed, err := before.Seal("Fix the air conditioner")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(sealed, "old:"), true)
+
+	again, err := before.Seal("Fix the air conditioner")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, sealed, again)
+
+	after, err := ParseKeyring(newKey + "," + oldKey)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, after.Stale(sealed), true)
+	opened, err := after.Open(sealed)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, opened, "Fix the air conditioner")
+
+	resealed, err := after.Seal(opened)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, after.Stale(resealed), false)
+
+	_, err = before.Open(resealed)
+	assert.Equal(t, errors.Is(err, ErrUnknownKey), true)
+}
+
+func TestKeyringTampering(t *testing.T) {
+	keyring, err := ParseKeyring(newKey + "," + oldKey)
+	assert.Equal(t, err, nil)
+	sealed, err := keyring.Seal("Fix the air conditioner")
+	assert.Equal(t, err, nil)
+
+	encoded := strings.TrimPrefix(sealed, "new:")
+	flipped := []byte(encoded)
+	if flipped[20] == 'A' {
+		flipped[20] = 'B'
+	} else {
+		flipped[20] = 'A'
+	}
+	samples := []string{
+		"new:" + string(flipped),
+		"old:" + encoded,
+		"new:not base64",
+		"new:AAAA",
+	}
+	for _, v := range samples {
+		_, err = keyring.Open(v)
+		assert.Equal(t, err, ErrCiphertext)
+	}
+}
+
+func TestLegacyDecrypt(t *testing.T) {
+	secret := "hWmZq4t7w!z%C*F-JaNdRfUjXn2r5u8x"
+	previous, set := os.LookupEnv("API_SECRET")
+	os.Setenv("API_SECRET", secret)
+	defer func() {
+		if set {
+			os.Setenv("API_SECRET", previous)
+		} else {
+			os.Unsetenv("API_SECRET")
+		}
+	}()
+
+	block, err := aes.NewCipher([]byte(secret))
+	assert.Equal(t, err, nil)
+	plainText := []byte("Fix the air conditioner")
+	cipherText := make([]byte, len(plainText))
+	cipher.NewCFBEncrypter(block, legacyIV).XORKeyStream(cipherText, plainText)
+	legacy := Encode(cipherText)
+
+	keyring, err := ParseKeyring(newKey)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keyring.Stale(legacy), true)
+	opened, err := keyring.Open(legacy)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, opened, "Fix the air conditioner")
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// StreamChunkSize is how much plaintext each sealed chunk of a stream holds.
+const StreamChunkSize = 64 * 1024
+
+// ErrTruncated is returned when a sealed stream ends before its last chunk.
+var ErrTruncated = errors.New("sealed stream is truncated")
+
+// NewStreamKey returns a random key for a single stream, files get their own
+// key so one can be destroyed without touching the others.
+func NewStreamKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SealedSize returns the size of a plaintext of n bytes once sealed, every
+// chunk carries a tag and the last chunk is always shorter than the others,
+// even if empty.
+func SealedSize(n int64) int64 {
+	return n + (n/StreamChunkSize+1)*streamTagSize
+}
+
+const streamTagSize = 16
+
+// streamNonce numbers the chunks and flags the last one, so chunks cannot be
+// reordered, dropped or appended without Open failing.
+func streamNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func streamAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type sealingReader struct {
+	aead    cipher.AEAD
+	source  io.Reader
+	plain   []byte
+	out     []byte
+	counter uint64
+	done    bool
+}
+
+// NewSealingReader encrypts source with AES-GCM in StreamChunkSize chunks as
+// it is read.
+func NewSealingReader(source io.Reader, key []byte) (io.Reader, error) {
+	aead, err := streamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sealingReader{aead: aead, source: source, plain: make([]byte, StreamChunkSize)}, nil
+}
+
+func (r *sealingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.source, r.plain)
+		last := false
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			last = true
+		default:
+			return 0, err
+		}
+		r.out = r.aead.Seal(r.out[:0], streamNonce(r.counter, last), r.plain[:n], nil)
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+type openingReader struct {
+	aead    cipher.AEAD
+	source  io.Reader
+	sealed  []byte
+	out     []byte
+	counter uint64
+	done    bool
+}
+
+// NewOpeningReader decrypts a stream sealed by NewSealingReader as it is
+// read, returning ErrCiphertext when a chunk was tampered with and
+// ErrTruncated when the stream stops early.
+func NewOpeningReader(source io.Reader, key []byte) (io.Reader, error) {
+	aead, err := streamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &openingReader{aead: aead, source: source, sealed: make([]byte, StreamChunkSize+streamTagSize)}, nil
+}
+
+func (r *openingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.source, r.sealed)
+		last := false
+		switch err {
+		case nil:
+		case io.EOF:
+			return 0, ErrTruncated
+		case io.ErrUnexpectedEOF:
+			last = true
+		default:
+			return 0, err
+		}
+		opened, err := r.aead.Open(r.out[:0], streamNonce(r.counter, last), r.sealed[:n], nil)
+		if err != nil {
+			return 0, ErrCiphertext
+		}
+		r.out = opened
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
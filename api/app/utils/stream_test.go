@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := NewStreamKey()
+	assert.Equal(t, err, nil)
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 7} {
+		plain := make([]byte, size)
+		_, err = rand.Read(plain)
+		assert.Equal(t, err, nil)
+
+		sealer, err := NewSealingReader(bytes.NewReader(plain), key)
+		assert.Equal(t, err, nil)
+		sealed, err := io.ReadAll(sealer)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, int64(len(sealed)), SealedSize(int64(size)))
+
+		opener, err := NewOpeningReader(bytes.NewReader(sealed), key)
+		assert.Equal(t, err, nil)
+		opened, err := io.ReadAll(opener)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, bytes.Equal(opened, plain), true)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, err := NewStreamKey()
+	assert.Equal(t, err, nil)
+	plain := bytes.Repeat([]byte("photo"), StreamChunkSize)
+	sealer, err := NewSealingReader(bytes.NewReader(plain), key)
+	assert.Equal(t, err, nil)
+	sealed, err := io.ReadAll(sealer)
+	assert.Equal(t, err, nil)
+	chunk := StreamChunkSize + streamTagSize
+
+	otherKey, err := NewStreamKey()
+	assert.Equal(t, err, nil)
+	flipped := append([]byte{}, sealed...)
+	flipped[chunk+10] ^= 1
+	swapped := append(append(append([]byte{}, sealed[chunk:2*chunk]...), sealed[:chunk]...), sealed[2*chunk:]...)
+
+	samples := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+		err    error
+	}{
+		{name: "flipped bit", sealed: flipped, key: key, err: ErrCiphertext},
+		{name: "swapped chunks", sealed: swapped, key: key, err: ErrCiphertext},
+		{name: "dropped last chunk", sealed: sealed[:len(sealed)/chunk*chunk], key: key, err: ErrTruncated},
+		{name: "cut mid chunk", sealed: sealed[:chunk+100], key: key, err: ErrCiphertext},
+		{name: "appended chunk", sealed: append(append([]byte{}, sealed...), sealed[:chunk]...), key: key, err: ErrCiphertext},
+		{name: "other key", sealed: sealed, key: otherKey, err: ErrCiphertext},
+	}
+	for _, v := range samples {
+		opener, err := NewOpeningReader(bytes.NewReader(v.sealed), v.key)
+		assert.Equal(t, err, nil)
+		_, err = io.ReadAll(opener)
+		if err != v.err {
+			t.Errorf("%s: got %v, want %v", v.name, err, v.err)
+		}
+	}
+}
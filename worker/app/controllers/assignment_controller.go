@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type AssignmentMessage struct {
+	Nickname string `json:"nickname"`
+	TaskID   string `json:"task_id"`
+	TaskDate string `json:"task_date"`
+	Email    string `json:"email"`
+}
+
+func (m AssignmentMessage) recipient() string {
+	return m.Email
+}
+
+func Assignment(delivery amqp.Delivery) error {
+	return send(delivery, "assignment", func(message AssignmentMessage) string {
+		return "Task " + message.TaskID + " assigned to you"
+	})
+}
@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CommentMessage leaves the comment body out, it is only readable in the API.
+type CommentMessage struct {
+	Nickname string `json:"nickname"`
+	TaskID   string `json:"task_id"`
+	Email    string `json:"email"`
+}
+
+func (m CommentMessage) recipient() string {
+	return m.Email
+}
+
+func Comment(delivery amqp.Delivery) error {
+	return send(delivery, "comment", func(message CommentMessage) string {
+		return "New comment on task " + message.TaskID
+	})
+}
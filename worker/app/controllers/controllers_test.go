@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vitorbiten/maintenance/worker/app/notifier"
+	"github.com/vitorbiten/maintenance/worker/app/notifier/smtptest"
+	"github.com/vitorbiten/maintenance/worker/app/queue"
+)
+
+func TestControllers(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatalf("cannot start smtp server: %v", err)
+	}
+	defer server.Close()
+	Mailer = &notifier.SMTPNotifier{Host: server.Host, Port: server.Port, From: "noreply@maintenance.com"}
+	defer func() { Mailer = notifier.LogNotifier{} }()
+
+	samples := []struct {
+		controller string
+		handler    func(amqp.Delivery) error
+		body       string
+		recipient  string
+		// contains are expected in the email, the subject first
+		contains []string
+	}{
+		{
+			controller: "notification",
+			handler:    Notification,
+			body:       `{"nickname": "Kenny Morris", "task_id": "12", "task_date": "2023-01-27T20:03:44Z", "email": "vitor@gmail.com"}`,
+			recipient:  "vitor@gmail.com",
+			contains:   []string{"Subject: Task 12 performed", "Kenny Morris", "#12"},
+		},
+		{
+			controller: "transition",
+			handler:    Transition,
+			body:       `{"nickname": "Kenny Morris", "task_id": "12", "from_status": "open", "to_status": "in_progress", "email": "vitor@gmail.com"}`,
+			recipient:  "vitor@gmail.com",
+			contains:   []string{"Subject: Task 12 is now in_progress", "Kenny Morris", "#12"},
+		},
+		{
+			controller: "assignment",
+			handler:    Assignment,
+			body:       `{"nickname": "Martin Luther", "task_id": "12", "task_date": "2023-01-27T20:03:44Z", "email": "vitor@gmail.com"}`,
+			recipient:  "vitor@gmail.com",
+			contains:   []string{"Subject: Task 12 assigned to you", "Martin Luther", "#12"},
+		},
+		{
+			controller: "comment",
+			handler:    Comment,
+			body:       `{"nickname": "Martin Luther", "task_id": "12", "email": "vitor@gmail.com"}`,
+			recipient:  "vitor@gmail.com",
+			contains:   []string{"Subject: New comment on task 12", "Martin Luther", "#12"},
+		},
+		{
+			controller: "lockout",
+			handler:    Lockout,
+			body:       `{"nickname": "Kenny Morris", "email": "kenny@gmail.com", "locked_until": "2026-10-17T10:15:00Z"}`,
+			recipient:  "kenny@gmail.com",
+			contains:   []string{"Subject: Your account was locked after failed logins", "Kenny Morris", "2026-10-17T10:15:00Z"},
+		},
+		{
+			controller: "password_reset",
+			handler:    PasswordReset,
+			body:       `{"nickname": "Kenny Morris", "email": "kenny@gmail.com", "link": "http://127.0.0.1:8000/reset-password?token=abc", "expires_at": "2026-10-17T10:30:00Z"}`,
+			recipient:  "kenny@gmail.com",
+			contains:   []string{"Subject: Reset your password", "Kenny Morris", "reset-password?token"},
+		},
+		{
+			controller: "verification",
+			handler:    Verification,
+			body:       `{"nickname": "Kenny Morris", "email": "kenny@gmail.com", "link": "http://127.0.0.1:8080/verify?token=abc", "expires_at": "2026-10-17T10:30:00Z"}`,
+			recipient:  "kenny@gmail.com",
+			contains:   []string{"Subject: Verify your email", "Kenny Morris", "verify?token"},
+		},
+	}
+
+	for _, v := range samples {
+		before := len(server.Messages())
+		err := v.handler(amqp.Delivery{Body: []byte(v.body)})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", v.controller, err)
+		}
+		messages := server.Messages()[before:]
+		if len(messages) != 1 {
+			t.Fatalf("%s: expected 1 email, got %d", v.controller, len(messages))
+		}
+		if messages[0].To[0] != v.recipient {
+			t.Errorf("%s: unexpected recipient %v", v.controller, messages[0].To)
+		}
+		for _, expected := range v.contains {
+			if !strings.Contains(string(messages[0].Data), expected) {
+				t.Errorf("%s: email does not contain %q: %s", v.controller, expected, messages[0].Data)
+			}
+		}
+
+		// a body that is not json can never be sent
+		before = len(server.Messages())
+		err = v.handler(amqp.Delivery{Body: []byte(`not json`)})
+		if !queue.IsPermanent(err) {
+			t.Errorf("%s: expected a permanent error, got %v", v.controller, err)
+		}
+		if len(server.Messages()) != before {
+			t.Errorf("%s: sent an email for a body that is not json", v.controller)
+		}
+	}
+}
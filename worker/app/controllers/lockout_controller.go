@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// LockoutMessage tells the owner of an account that failed logins locked it,
+// LockedUntil is an RFC 3339 time in UTC.
+type LockoutMessage struct {
+	Nickname    string `json:"nickname"`
+	Email       string `json:"email"`
+	LockedUntil string `json:"locked_until"`
+}
+
+func (m LockoutMessage) recipient() string {
+	return m.Email
+}
+
+func Lockout(delivery amqp.Delivery) error {
+	return send(delivery, "lockout", func(LockoutMessage) string {
+		return "Your account was locked after failed logins"
+	})
+}
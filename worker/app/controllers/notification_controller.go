@@ -2,30 +2,50 @@ package controllers
 
 import (
 	"encoding/json"
-	"log"
-	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vitorbiten/maintenance/worker/app/notifier"
+	"github.com/vitorbiten/maintenance/worker/app/queue"
 )
 
-func Notification(delivery amqp.Delivery) {
-	responseMap := make(map[string]interface{})
-	err := json.Unmarshal(delivery.Body, &responseMap)
+// Mailer delivers the emails rendered by the controllers, main replaces it
+// with the notifier configured from the environment.
+var Mailer notifier.Notifier = notifier.LogNotifier{}
+
+// mailable is the body of a delivery, decoded into the data of its template.
+type mailable interface {
+	recipient() string
+}
+
+// send decodes the delivery into M and mails it rendered with template, the
+// subject is built from the decoded message. A body that cannot be decoded
+// or rendered will never succeed and fails permanently.
+func send[M mailable](delivery amqp.Delivery, template string, subject func(message M) string) error {
+	var decoded M
+	err := json.Unmarshal(delivery.Body, &decoded)
 	if err != nil {
-		err := delivery.Nack(false, false)
-		if err != nil {
-			log.Panicf("%s", err)
-		}
+		return queue.Permanent(err)
 	}
-	log.Printf("The tech %s performed the task %s on date %s\n",
-		responseMap["nickname"],
-		responseMap["task_id"],
-		responseMap["task_date"],
-	)
-	time.Sleep(1 * time.Second)
-	log.Printf("Acknowledging message %d\n", delivery.DeliveryTag)
-	err = delivery.Ack(false)
+	email, err := notifier.Render(template, decoded.recipient(), subject(decoded), decoded)
 	if err != nil {
-		log.Panicf("%s", err)
+		return queue.Permanent(err)
 	}
+	return Mailer.Send(email)
+}
+
+type NotificationMessage struct {
+	Nickname string `json:"nickname"`
+	TaskID   string `json:"task_id"`
+	TaskDate string `json:"task_date"`
+	Email    string `json:"email"`
+}
+
+func (m NotificationMessage) recipient() string {
+	return m.Email
+}
+
+func Notification(delivery amqp.Delivery) error {
+	return send(delivery, "notification", func(message NotificationMessage) string {
+		return "Task " + message.TaskID + " performed"
+	})
 }
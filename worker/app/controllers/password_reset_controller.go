@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PasswordResetMessage carries the single use link to reset a password,
+// ExpiresAt is an RFC 3339 time in UTC.
+type PasswordResetMessage struct {
+	Nickname  string `json:"nickname"`
+	Email     string `json:"email"`
+	Link      string `json:"link"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (m PasswordResetMessage) recipient() string {
+	return m.Email
+}
+
+func PasswordReset(delivery amqp.Delivery) error {
+	return send(delivery, "password_reset", func(PasswordResetMessage) string {
+		return "Reset your password"
+	})
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type TransitionMessage struct {
+	Nickname   string `json:"nickname"`
+	TaskID     string `json:"task_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Email      string `json:"email"`
+}
+
+func (m TransitionMessage) recipient() string {
+	return m.Email
+}
+
+func Transition(delivery amqp.Delivery) error {
+	return send(delivery, "transition", func(message TransitionMessage) string {
+		return "Task " + message.TaskID + " is now " + message.ToStatus
+	})
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// VerificationMessage carries the single use link to verify the email of a
+// new account, ExpiresAt is an RFC 3339 time in UTC.
+type VerificationMessage struct {
+	Nickname  string `json:"nickname"`
+	Email     string `json:"email"`
+	Link      string `json:"link"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (m VerificationMessage) recipient() string {
+	return m.Email
+}
+
+func Verification(delivery amqp.Delivery) error {
+	return send(delivery, "verification", func(VerificationMessage) string {
+		return "Verify your email"
+	})
+}
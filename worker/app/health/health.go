@@ -0,0 +1,157 @@
+// Package health tracks the AMQP connection and the consumer of the worker,
+// served at /healthz and /readyz next to /metrics.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is the state of one dependency, the same shape the API reports.
+type Check struct {
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// State is updated by main as the connection and the consumer come and go.
+type State struct {
+	mu            sync.RWMutex
+	queue         string
+	connected     bool
+	connectionErr string
+	consuming     bool
+	stopped       bool
+	shuttingDown  bool
+	lastDelivery  time.Time
+}
+
+func New(queue string) *State {
+	return &State{queue: queue}
+}
+
+// Connected records the connection is open, or closed with err.
+func (s *State) Connected(connected bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = connected
+	s.connectionErr = ""
+	if err != nil {
+		s.connectionErr = err.Error()
+	}
+}
+
+// Consuming records the consumer was registered.
+func (s *State) Consuming() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consuming = true
+	s.stopped = false
+}
+
+// ConsumerStopped records the deliveries channel was closed.
+func (s *State) ConsumerStopped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consuming = false
+	s.stopped = true
+}
+
+// ShuttingDown stops the worker from being ready while it drains.
+func (s *State) ShuttingDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shuttingDown = true
+}
+
+// Delivered records a delivery was received.
+func (s *State) Delivered() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDelivery = time.Now()
+}
+
+// Alive is false once the consumer stopped without a shutdown, the worker
+// would otherwise idle forever with no deliveries.
+func (s *State) Alive() Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	report := Report{Status: "ok", Checks: map[string]Check{"consumer": s.consumer()}}
+	if s.stopped && !s.shuttingDown {
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+// Ready is true when the connection is open, the consumer registered and the
+// worker is not shutting down.
+func (s *State) Ready() Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	report := Report{Status: "ok", Checks: map[string]Check{
+		"amqp":     s.amqp(),
+		"consumer": s.consumer(),
+	}}
+	for _, check := range report.Checks {
+		if check.Status != "up" {
+			report.Status = "unavailable"
+		}
+	}
+	if s.shuttingDown {
+		report.Status = "shutting_down"
+	}
+	return report
+}
+
+func (s *State) amqp() Check {
+	if !s.connected {
+		return Check{Status: "down", Error: s.connectionErr}
+	}
+	return Check{Status: "up"}
+}
+
+func (s *State) consumer() Check {
+	check := Check{Status: "up", Details: map[string]interface{}{"queue": s.queue}}
+	if !s.lastDelivery.IsZero() {
+		check.Details["last_delivery"] = s.lastDelivery.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case s.stopped:
+		check.Status = "down"
+		check.Error = "consumer stopped"
+	case !s.consuming:
+		check.Status = "down"
+		check.Error = "consumer not registered"
+	}
+	return check
+}
+
+// LivenessHandler and ReadinessHandler answer 200 when the report is ok, 503
+// otherwise, with the report as the body.
+func (s *State) LivenessHandler() http.Handler {
+	return reportHandler(s.Alive)
+}
+
+func (s *State) ReadinessHandler() http.Handler {
+	return reportHandler(s.Ready)
+}
+
+func reportHandler(report func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := report()
+		code := http.StatusOK
+		if current.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(current)
+	})
+}
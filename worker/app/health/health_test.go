@@ -0,0 +1,75 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func get(t *testing.T, handler http.Handler) (int, Report) {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	report := Report{}
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("cannot decode %q: %v", rr.Body.String(), err)
+	}
+	return rr.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	state := New("task_queue")
+	code, report := get(t, state.ReadinessHandler())
+	if code != 503 || report.Checks["amqp"].Status != "down" || report.Checks["consumer"].Error != "consumer not registered" {
+		t.Errorf("before connecting: %d %+v", code, report)
+	}
+
+	state.Connected(true, nil)
+	state.Consuming()
+	state.Delivered()
+	code, report = get(t, state.ReadinessHandler())
+	if code != 200 || report.Status != "ok" {
+		t.Errorf("consuming: %d %+v", code, report)
+	}
+	consumer := report.Checks["consumer"]
+	if consumer.Details["queue"] != "task_queue" || consumer.Details["last_delivery"] == nil {
+		t.Errorf("consumer details = %+v", consumer.Details)
+	}
+
+	state.Connected(false, errors.New("Exception (320) Reason: \"CONNECTION_FORCED\""))
+	code, report = get(t, state.ReadinessHandler())
+	if code != 503 || report.Checks["amqp"].Error != "Exception (320) Reason: \"CONNECTION_FORCED\"" {
+		t.Errorf("connection closed: %d %+v", code, report)
+	}
+
+	state.Connected(true, nil)
+	state.ShuttingDown()
+	code, report = get(t, state.ReadinessHandler())
+	if code != 503 || report.Status != "shutting_down" {
+		t.Errorf("shutting down: %d %+v", code, report)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	state := New("task_queue")
+	code, _ := get(t, state.LivenessHandler())
+	if code != 200 {
+		t.Errorf("starting: %d, want 200", code)
+	}
+
+	state.Consuming()
+	state.ConsumerStopped()
+	code, report := get(t, state.LivenessHandler())
+	if code != 503 || report.Checks["consumer"].Error != "consumer stopped" {
+		t.Errorf("consumer stopped: %d %+v", code, report)
+	}
+
+	// a consumer stopped by a shutdown is expected
+	state.ShuttingDown()
+	code, _ = get(t, state.LivenessHandler())
+	if code != 200 {
+		t.Errorf("shutting down: %d, want 200", code)
+	}
+}
@@ -0,0 +1,96 @@
+// Package logging sets up log/slog to write JSON lines, tagged with the
+// request id the API published a message with, and keeps personal data out
+// of them. The redaction rules are a copy of the API ones, keep both in step.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// redactedKeys are attributes whose values never reach the logs.
+var redactedKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+	"nickname":      true,
+	"summary":       true,
+	"body":          true,
+	"filename":      true,
+	// password reset and verification links carry their token
+	"link": true,
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// MaskEmail keeps the first letter and the domain of an address, enough to
+// tell entries apart without naming anyone.
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "[REDACTED]"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// Redact is a slog ReplaceAttr: it drops the values of redactedKeys and masks
+// the email addresses of any other string, messages and errors included.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if redactedKeys[key] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	if err, ok := a.Value.Any().(error); ok && a.Value.Kind() == slog.KindAny {
+		a = slog.String(a.Key, err.Error())
+	}
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	if key == "email" {
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	}
+	value := a.Value.String()
+	if strings.Contains(value, "@") {
+		return slog.String(a.Key, emailPattern.ReplaceAllStringFunc(value, MaskEmail))
+	}
+	return a
+}
+
+// NewHandler writes JSON lines to w with the redaction rules.
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: Redact})
+}
+
+// Setup makes the redacting JSON handler the default, the standard log
+// package included, at the level LOG_LEVEL names (info by default).
+func Setup(service string) {
+	level := slog.LevelInfo
+	_ = level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	slog.SetDefault(slog.New(NewHandler(os.Stdout, level)).With("service", service))
+}
+
+// Fatal logs at error level and exits, like log.Fatal.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// FromDelivery returns the default logger with the request id and the
+// controller the API published the delivery with.
+func FromDelivery(delivery amqp.Delivery) *slog.Logger {
+	logger := slog.Default().With("delivery_tag", delivery.DeliveryTag)
+	if id, ok := delivery.Headers["request_id"].(string); ok && id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if controller, ok := delivery.Headers["controller"].(string); ok {
+		logger = logger.With("controller", controller)
+	}
+	return logger
+}
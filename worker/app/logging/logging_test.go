@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func decodeLine(t *testing.T, out *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line is not json: %v", err)
+	}
+	return line
+}
+
+func TestRedact(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(NewHandler(out, slog.LevelInfo))
+	logger.Info("email sent", "email", "kenny@gmail.com", "nickname", "Kenny", "error", errors.New("550 denny@gmail.com unknown"))
+	line := decodeLine(t, out)
+	if line["email"] != "k***@gmail.com" {
+		t.Errorf("email = %v, want it masked", line["email"])
+	}
+	if line["nickname"] != "[REDACTED]" {
+		t.Errorf("nickname = %v, want it redacted", line["nickname"])
+	}
+	if line["error"] != "550 d***@gmail.com unknown" {
+		t.Errorf("error = %v, want the address masked", line["error"])
+	}
+}
+
+func TestFromDelivery(t *testing.T) {
+	out := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewHandler(out, slog.LevelInfo)))
+	defer slog.SetDefault(previous)
+
+	FromDelivery(amqp.Delivery{
+		DeliveryTag: 7,
+		Headers:     amqp.Table{"request_id": "create-task-1", "controller": "notification"},
+	}).Info("message handled")
+	line := decodeLine(t, out)
+	if line["request_id"] != "create-task-1" {
+		t.Errorf("request_id = %v, want create-task-1", line["request_id"])
+	}
+	if line["controller"] != "notification" {
+		t.Errorf("controller = %v, want notification", line["controller"])
+	}
+	if line["delivery_tag"] != float64(7) {
+		t.Errorf("delivery_tag = %v, want 7", line["delivery_tag"])
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -13,52 +16,189 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/vitorbiten/maintenance/worker/app/controllers"
+	"github.com/vitorbiten/maintenance/worker/app/health"
+	"github.com/vitorbiten/maintenance/worker/app/logging"
+	"github.com/vitorbiten/maintenance/worker/app/metrics"
+	"github.com/vitorbiten/maintenance/worker/app/notifier"
+	"github.com/vitorbiten/maintenance/worker/app/queue"
+	"github.com/vitorbiten/maintenance/worker/app/tracing"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/sync/errgroup"
 )
 
 func failOnError(err error, msg string) {
 	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+		logging.Fatal(msg, "error", err)
 	}
 }
 
-var controllersMap map[string]func(delivery amqp.Delivery) = map[string]func(delivery amqp.Delivery){
-	"notification": controllers.Notification,
+var controllersMap map[string]func(delivery amqp.Delivery) error = map[string]func(delivery amqp.Delivery) error{
+	"assignment":     controllers.Assignment,
+	"comment":        controllers.Comment,
+	"lockout":        controllers.Lockout,
+	"notification":   controllers.Notification,
+	"password_reset": controllers.PasswordReset,
+	"transition":     controllers.Transition,
+	"verification":   controllers.Verification,
 }
 
-func main() {
-	var conn *amqp.Connection
-	var err error
+func dial() *amqp.Connection {
 	for {
-		conn, err = amqp.Dial(fmt.Sprintf(
+		conn, err := amqp.Dial(fmt.Sprintf(
 			"amqp://%s:%s@%s:5672/",
 			os.Getenv("RABBITMQ_USER"),
 			os.Getenv("RABBITMQ_PASSWORD"),
 			os.Getenv("RABBITMQ_HOST"),
 		))
 		if err == nil {
-			break
+			return conn
 		}
-		log.Printf("Error connecting to rabbitmq: %s", err)
-		log.Println("Retrying in 5 seconds...")
+		slog.Warn("cannot connect to rabbitmq, retrying in 5 seconds", "error", err)
 		time.Sleep(5 * time.Second)
 	}
-	failOnError(err, "Failed to connect to RabbitMQ")
+}
+
+// dispatch runs the controller named in the delivery headers, a delivery
+// without a known controller can never succeed so it is parked.
+func dispatch(delivery amqp.Delivery) error {
+	incomingController, ok := delivery.Headers["controller"].(string)
+	if !ok {
+		return queue.Permanent(errors.New("missing controller header"))
+	}
+	controller, ok := controllersMap[incomingController]
+	if !ok {
+		return queue.Permanent(fmt.Errorf("controller %q not found", incomingController))
+	}
+	return controller(delivery)
+}
+
+// process runs the controller of a delivery in the trace the API started and
+// settles it.
+func process(ch queue.Publisher, config queue.Config, d amqp.Delivery) {
+	label := controllerLabel(d)
+	_, span := tracing.StartDelivery(context.Background(), d, label, queue.Attempts(d)+1)
+	defer span.End()
+	logger := logging.FromDelivery(d).With("trace_id", span.SpanContext().TraceID().String())
+	logger.Debug("message received")
+	metrics.MessagesConsumed.WithLabelValues(label).Inc()
+
+	start := time.Now()
+	handled := dispatch(d)
+	result := "success"
+	if handled != nil {
+		result = "failure"
+		span.RecordError(handled)
+		span.SetStatus(codes.Error, handled.Error())
+	}
+	metrics.ProcessingDuration.WithLabelValues(label, result).Observe(time.Since(start).Seconds())
+	if handled == nil {
+		logger.Info("message handled", "duration_ms", time.Since(start).Milliseconds())
+	}
+	err := queue.Handle(ch, config, d, handled)
+	if err != nil {
+		logger.Error("failed to settle message", "error", err)
+	}
+}
+
+// controllerLabel keeps the metrics to the known controllers, whatever the
+// headers say.
+func controllerLabel(delivery amqp.Delivery) string {
+	name, _ := delivery.Headers["controller"].(string)
+	if _, ok := controllersMap[name]; !ok {
+		return "unknown"
+	}
+	return name
+}
+
+// serveHTTP exposes the metrics and the health probes until ctx is done, the
+// depth of the task and parking queues is read through its own channel since
+// a failed passive declare closes the channel it was sent on.
+func serveHTTP(ctx context.Context, conn *amqp.Connection, state *health.State) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	var mu sync.Mutex
+	metrics.Registry.MustRegister(metrics.NewQueueCollector(func(name string) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ch.IsClosed() {
+			return 0, amqp.ErrClosed
+		}
+		q, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+		return q.Messages, err
+	}, queue.TaskQueue, queue.ParkingQueue))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", state.LivenessHandler())
+	mux.Handle("/readyz", state.ReadinessHandler())
+	server := &http.Server{Addr: metrics.Addr(), Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("serving metrics and health probes", "addr", server.Addr)
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	limit := flags.Int("limit", 0, "maximum number of parked messages to replay (0 replays all)")
+	_ = flags.Parse(args)
+
+	conn := dial()
+	defer conn.Close()
+	ch, err := conn.Channel()
+	failOnError(err, "Failed to open a channel")
+	defer ch.Close()
+	err = queue.Declare(ch, queue.ConfigFromEnv())
+	failOnError(err, "Failed to declare the queues")
+
+	replayed, err := queue.Replay(ch, *limit)
+	failOnError(err, "Failed to replay parked messages")
+	slog.Info("replayed parked messages", "count", replayed)
+}
+
+func main() {
+	logging.Setup("worker")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "maintenance-worker")
+	failOnError(err, "Failed to set up tracing")
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			slog.Warn("failed to flush spans", "error", err)
+		}
+	}()
+
+	controllers.Mailer = notifier.FromEnv()
+	config := queue.ConfigFromEnv()
+
+	state := health.New(queue.TaskQueue)
+	conn := dial()
 	defer conn.Close()
+	state.Connected(true, nil)
 
 	ch, err := conn.Channel()
 	failOnError(err, "Failed to open a channel")
 	defer ch.Close()
 
-	q, err := ch.QueueDeclare(
-		"task_queue", // name
-		true,         // durable
-		false,        // delete when unused
-		false,        // exclusive
-		false,        // no-wait
-		nil,          // arguments
-	)
-	failOnError(err, "Failed to declare a queue")
+	err = queue.Declare(ch, config)
+	failOnError(err, "Failed to declare the queues")
 
 	err = ch.Qos(
 		10,    // prefetch count
@@ -67,16 +207,21 @@ func main() {
 	)
 	failOnError(err, "Failed to set QoS")
 
+	// retries are republished on the consuming channel, in confirm mode
+	publisher, err := queue.ConfirmMode(ch)
+	failOnError(err, "Failed to put the channel in confirm mode")
+
 	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+		queue.TaskQueue, // queue
+		"",              // consumer
+		false,           // auto-ack
+		false,           // exclusive
+		false,           // no-local
+		false,           // no-wait
+		nil,             // args
 	)
 	failOnError(err, "Failed to register a consumer")
+	state.Consuming()
 
 	mainCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
@@ -87,36 +232,56 @@ func main() {
 
 	g.Go(func() error {
 		select {
-		case <-closeNotifier:
-			log.Println("RabbitMQ connection closed")
+		case amqpErr := <-closeNotifier:
+			slog.Warn("rabbitmq connection closed", "error", amqpErr)
+			if amqpErr != nil {
+				state.Connected(false, amqpErr)
+			} else {
+				state.Connected(false, amqp.ErrClosed)
+			}
 			stop()
 			return nil
 		case <-gCtx.Done():
-			log.Println("Shutting down server...")
+			slog.Info("shutting down")
+			state.ShuttingDown()
 			return gCtx.Err()
 		}
 	})
+	g.Go(func() error {
+		err := serveHTTP(gCtx, conn, state)
+		if err != nil {
+			slog.Error("metrics and health server failed", "error", err)
+		}
+		return err
+	})
 	g.Go(func() error {
 		for d := range msgs {
 			select {
 			case <-gCtx.Done():
 				return gCtx.Err()
 			default:
-				incomingController := d.Headers["controller"].(string)
-				failOnError(err, "Controller not found")
+				state.Delivered()
 				processingWg.Add(1)
+				metrics.InFlight.Inc()
 				go func(d amqp.Delivery) {
 					defer processingWg.Done()
-					controllersMap[incomingController](d)
+					defer metrics.InFlight.Dec()
+					process(publisher, config, d)
 				}(d)
 			}
 		}
+		if gCtx.Err() == nil {
+			// the channel was closed under the consumer, /healthz reports it
+			// so the worker is restarted
+			slog.Error("consumer stopped")
+			state.ConsumerStopped()
+		}
 		return nil
 	})
 
-	log.Printf(" [*] Waiting for messages...")
+	slog.Info("waiting for messages")
 	<-gCtx.Done()
-	log.Println("Awaiting final messages...")
+	slog.Info("awaiting final messages")
 	processingWg.Wait()
 	conn.Close()
 }
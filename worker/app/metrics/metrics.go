@@ -0,0 +1,100 @@
+// Package metrics holds the Prometheus collectors of the worker, served at
+// /metrics on WORKER_METRICS_ADDR.
+package metrics
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry has the worker collectors plus the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// MessagesConsumed and ProcessingDuration are labelled with the
+	// controller header, or "unknown" when no controller has that name.
+	MessagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_messages_consumed_total",
+		Help: "Messages received from task_queue by controller.",
+	}, []string{"controller"})
+
+	ProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "worker_processing_duration_seconds",
+		Help:    "Time taken by the controllers by controller and result (success or failure).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller", "result"})
+
+	// Settled counts how deliveries were settled: ack, retry (acked once
+	// republished to a retry queue), park (rejected into the parking queue)
+	// or requeue (nacked when the retry could not be published).
+	Settled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_messages_settled_total",
+		Help: "Deliveries settled by outcome (ack, retry, park or requeue).",
+	}, []string{"outcome"})
+
+	InFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_messages_in_flight",
+		Help: "Deliveries being processed.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesConsumed,
+		ProcessingDuration,
+		Settled,
+		InFlight,
+	)
+}
+
+// Handler serves the metrics of Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Addr is where the metrics server listens, WORKER_METRICS_ADDR or :9090.
+func Addr() string {
+	if addr := os.Getenv("WORKER_METRICS_ADDR"); addr != "" {
+		return addr
+	}
+	return ":9090"
+}
+
+// QueueInspector returns how many messages are ready in a queue.
+type QueueInspector func(queue string) (int, error)
+
+type queueCollector struct {
+	inspect QueueInspector
+	queues  []string
+	desc    *prometheus.Desc
+}
+
+// NewQueueCollector reports the depth of queues on every scrape, the number
+// to autoscale the workers on. Queues that cannot be inspected are left out.
+func NewQueueCollector(inspect QueueInspector, queues ...string) prometheus.Collector {
+	return &queueCollector{
+		inspect: inspect,
+		queues:  queues,
+		desc:    prometheus.NewDesc("worker_queue_messages", "Messages ready in the queue.", []string{"queue"}, nil),
+	}
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, queue := range c.queues {
+		messages, err := c.inspect(queue)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(messages), queue)
+	}
+}
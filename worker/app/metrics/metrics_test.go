@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	MessagesConsumed.WithLabelValues("notification").Inc()
+	Settled.WithLabelValues("park").Inc()
+	err := Registry.Register(NewQueueCollector(func(queue string) (int, error) {
+		if queue == "task_queue" {
+			return 42, nil
+		}
+		return 0, errors.New("not found")
+	}, "task_queue", "task_queue.parking"))
+	if err != nil {
+		t.Fatalf("cannot register the queue collector: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != 200 {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	text := rr.Body.String()
+	for _, want := range []string{
+		`worker_messages_consumed_total{controller="notification"} 1`,
+		`worker_messages_settled_total{outcome="park"} 1`,
+		`worker_queue_messages{queue="task_queue"} 42`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics miss %s", want)
+		}
+	}
+	if strings.Contains(text, `queue="task_queue.parking"`) {
+		t.Errorf("a queue that cannot be inspected is reported")
+	}
+}
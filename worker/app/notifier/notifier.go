@@ -0,0 +1,34 @@
+package notifier
+
+import (
+	"log/slog"
+	"os"
+)
+
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Notifier delivers an email to a single recipient.
+type Notifier interface {
+	Send(email Email) error
+}
+
+// LogNotifier only logs the emails, it is used when no SMTP server is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(email Email) error {
+	slog.Info("email not sent, no SMTP server configured", "email", email.To, "subject", email.Subject)
+	return nil
+}
+
+// FromEnv returns an SMTP notifier when SMTP_HOST is set and a LogNotifier otherwise.
+func FromEnv() Notifier {
+	if os.Getenv("SMTP_HOST") == "" {
+		return LogNotifier{}
+	}
+	return NewSMTPNotifierFromEnv()
+}
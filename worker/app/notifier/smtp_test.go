@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/vitorbiten/maintenance/worker/app/notifier/smtptest"
+)
+
+func TestSMTPNotifierSend(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatalf("cannot start smtp server: %v", err)
+	}
+	defer server.Close()
+
+	smtpNotifier := &SMTPNotifier{Host: server.Host, Port: server.Port, From: "noreply@maintenance.com"}
+	email, err := Render("notification", "luther@gmail.com", "Task 7 performed", map[string]string{
+		"Nickname": "Kenny <Morris>",
+		"TaskID":   "7",
+		"TaskDate": "2023-01-27T20:03:44Z",
+		"Email":    "luther@gmail.com",
+	})
+	if err != nil {
+		t.Fatalf("cannot render email: %v", err)
+	}
+	err = smtpNotifier.Send(email)
+	if err != nil {
+		t.Fatalf("cannot send email: %v", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if messages[0].From != "noreply@maintenance.com" {
+		t.Errorf("unexpected sender %q", messages[0].From)
+	}
+	if len(messages[0].To) != 1 || messages[0].To[0] != "luther@gmail.com" {
+		t.Errorf("unexpected recipients %v", messages[0].To)
+	}
+
+	parts := readParts(t, messages[0].Data)
+	if !strings.Contains(parts["text/plain"], "The technician Kenny <Morris> performed the task #7") {
+		t.Errorf("unexpected text body %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], "<b>Kenny &lt;Morris&gt;</b>") {
+		t.Errorf("html body is not escaped: %q", parts["text/html"])
+	}
+}
+
+// readParts parses a multipart/alternative email and returns its decoded
+// bodies by content type.
+func readParts(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	message, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("cannot parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q", message.Header.Get("Content-Type"))
+	}
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("cannot read part: %v", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("cannot read part body: %v", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return parts
+}
@@ -0,0 +1,36 @@
+package notifier
+
+import (
+	"bytes"
+	"embed"
+	htmlTemplate "html/template"
+	textTemplate "text/template"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var (
+	htmlTemplates = htmlTemplate.Must(htmlTemplate.ParseFS(templatesFS, "templates/*.html"))
+	textTemplates = textTemplate.Must(textTemplate.ParseFS(templatesFS, "templates/*.txt"))
+)
+
+// Render builds an email from the <name>.html and <name>.txt templates, data
+// is rendered for a single recipient so each manager gets their own copy.
+func Render(name string, to string, subject string, data interface{}) (Email, error) {
+	var html, text bytes.Buffer
+	err := htmlTemplates.ExecuteTemplate(&html, name+".html", data)
+	if err != nil {
+		return Email{}, err
+	}
+	err = textTemplates.ExecuteTemplate(&text, name+".txt", data)
+	if err != nil {
+		return Email{}, err
+	}
+	return Email{
+		To:      to,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,39 @@
+package queue
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vitorbiten/maintenance/worker/app/logging"
+)
+
+// Replay moves up to limit parked messages back to the task queue with their
+// attempt count reset, limit <= 0 replays the whole parking queue. ch is put
+// in confirm mode, a parked message is only acked once the broker confirmed
+// its copy.
+func Replay(ch *amqp.Channel, limit int) (int, error) {
+	publisher, err := ConfirmMode(ch)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for limit <= 0 || replayed < limit {
+		delivery, ok, err := ch.Get(ParkingQueue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+		err = republish(publisher, TaskQueue, delivery, 0)
+		if err != nil {
+			_ = delivery.Nack(false, true)
+			return replayed, err
+		}
+		err = delivery.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+		replayed++
+		logging.FromDelivery(delivery).Info("replayed parked message")
+	}
+	return replayed, nil
+}
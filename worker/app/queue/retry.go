@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vitorbiten/maintenance/worker/app/logging"
+	"github.com/vitorbiten/maintenance/worker/app/metrics"
+)
+
+// PermanentError marks a failure that retrying cannot fix, like a body that
+// does not decode, so the message is parked right away.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// Attempts returns how many times the delivery has already been tried.
+func Attempts(delivery amqp.Delivery) int {
+	switch value := delivery.Headers[AttemptHeader].(type) {
+	case int:
+		return value
+	case int8:
+		return int(value)
+	case int16:
+		return int(value)
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	}
+	return 0
+}
+
+// Publisher republishes deliveries and reports when the broker confirmed
+// them.
+type Publisher interface {
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (Confirmation, error)
+}
+
+// Confirmation is the answer of the broker to a publishing, true once it took
+// the message.
+type Confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// ConfirmChannel is a channel in confirm mode, so a retry is only acked once
+// the broker has it.
+type ConfirmChannel struct {
+	*amqp.Channel
+}
+
+// ConfirmMode puts ch in confirm mode.
+func ConfirmMode(ch *amqp.Channel) (ConfirmChannel, error) {
+	err := ch.Confirm(false)
+	if err != nil {
+		return ConfirmChannel{}, err
+	}
+	return ConfirmChannel{ch}, nil
+}
+
+func (c ConfirmChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (Confirmation, error) {
+	deferred, err := c.Channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		return nil, err
+	}
+	return deferred, nil
+}
+
+// Handle settles a delivery after its controller ran: successes are acked,
+// permanent failures are rejected into the parking queue and other failures
+// are republished to the retry queue of the next attempt until MaxAttempts.
+// A retry the broker did not confirm requeues the delivery instead, so the
+// message is never lost between the two queues.
+func Handle(ch Publisher, config Config, delivery amqp.Delivery, err error) error {
+	if err == nil {
+		metrics.Settled.WithLabelValues("ack").Inc()
+		return delivery.Ack(false)
+	}
+	logger := logging.FromDelivery(delivery)
+	attempt := Attempts(delivery) + 1
+	if IsPermanent(err) || attempt >= config.MaxAttempts {
+		logger.Error("parking message", "attempt", attempt, "error", err)
+		metrics.Settled.WithLabelValues("park").Inc()
+		return delivery.Reject(false)
+	}
+	delay := config.Delay(attempt)
+	logger.Warn("retrying message", "delay", delay.String(), "attempt", attempt, "error", err)
+	err = republish(ch, RetryQueue(delay), delivery, attempt)
+	if err != nil {
+		logger.Error("failed to schedule retry", "error", err)
+		metrics.Settled.WithLabelValues("requeue").Inc()
+		return delivery.Nack(false, true)
+	}
+	metrics.Settled.WithLabelValues("retry").Inc()
+	return delivery.Ack(false)
+}
+
+func republish(ch Publisher, routingKey string, delivery amqp.Delivery, attempt int) error {
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		if key == "x-death" {
+			continue
+		}
+		headers[key] = value
+	}
+	headers[AttemptHeader] = int32(attempt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", routingKey, false, false, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  delivery.ContentType,
+		Body:         delivery.Body,
+		Headers:      headers,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("retry was not confirmed by the broker")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type fakeAcknowledger struct {
+	acks     int
+	nacks    int
+	requeues int
+	rejects  int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacks++
+	if requeue {
+		a.requeues++
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejects++
+	return nil
+}
+
+type fakePublisher struct {
+	keys       []string
+	publishing []amqp.Publishing
+	err        error
+	// nacked makes the broker refuse the publishing
+	nacked bool
+}
+
+func (p *fakePublisher) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (Confirmation, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.keys = append(p.keys, key)
+	p.publishing = append(p.publishing, msg)
+	return fakeConfirmation{acked: !p.nacked}, nil
+}
+
+type fakeConfirmation struct {
+	acked bool
+}
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	return c.acked, nil
+}
+
+func TestHandle(t *testing.T) {
+	config := Config{MaxAttempts: 3, BaseDelay: time.Second}
+
+	samples := []struct {
+		name         string
+		attempts     interface{}
+		err          error
+		publishErr   error
+		nacked       bool
+		acks         int
+		nacks        int
+		rejects      int
+		routingKey   string
+		nextAttempts int
+	}{
+		{name: "success", acks: 1},
+		{name: "first failure", err: errors.New("smtp down"), acks: 1, routingKey: "task_queue.retry.1000", nextAttempts: 1},
+		{name: "second failure", attempts: int32(1), err: errors.New("smtp down"), acks: 1, routingKey: "task_queue.retry.2000", nextAttempts: 2},
+		{name: "last failure", attempts: int64(2), err: errors.New("smtp down"), rejects: 1},
+		{name: "poison message", err: Permanent(errors.New("bad json")), rejects: 1},
+		{name: "retry not scheduled", err: errors.New("smtp down"), publishErr: errors.New("channel closed"), nacks: 1},
+		{name: "retry not confirmed", err: errors.New("smtp down"), nacked: true, nacks: 1, routingKey: "task_queue.retry.1000", nextAttempts: 1},
+	}
+
+	for _, v := range samples {
+		acknowledger := &fakeAcknowledger{}
+		publisher := &fakePublisher{err: v.publishErr, nacked: v.nacked}
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			Body:         []byte(`{}`),
+			Headers:      amqp.Table{"controller": "notification"},
+		}
+		if v.attempts != nil {
+			delivery.Headers[AttemptHeader] = v.attempts
+		}
+
+		err := Handle(publisher, config, delivery, v.err)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", v.name, err)
+		}
+		if acknowledger.acks != v.acks || acknowledger.nacks != v.nacks || acknowledger.rejects != v.rejects {
+			t.Errorf("%s: got %d acks, %d nacks, %d rejects", v.name, acknowledger.acks, acknowledger.nacks, acknowledger.rejects)
+		}
+		if acknowledger.requeues != acknowledger.nacks {
+			t.Errorf("%s: a nacked delivery was not requeued", v.name)
+		}
+		if v.routingKey == "" {
+			if len(publisher.keys) != 0 {
+				t.Errorf("%s: unexpected republish to %v", v.name, publisher.keys)
+			}
+			continue
+		}
+		if len(publisher.keys) != 1 || publisher.keys[0] != v.routingKey {
+			t.Fatalf("%s: expected republish to %s, got %v", v.name, v.routingKey, publisher.keys)
+		}
+		headers := publisher.publishing[0].Headers
+		if Attempts(amqp.Delivery{Headers: headers}) != v.nextAttempts {
+			t.Errorf("%s: expected attempt %d, got %v", v.name, v.nextAttempts, headers[AttemptHeader])
+		}
+		if headers["controller"] != "notification" {
+			t.Errorf("%s: controller header was not kept", v.name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	TaskQueue          = "task_queue"
+	DeadLetterExchange = "task_queue.dlx"
+	ParkingQueue       = "task_queue.parking"
+	AttemptHeader      = "x-attempt"
+)
+
+// Config controls how many times a message is tried and how long the worker
+// waits between tries, the n-th retry waits BaseDelay * 2^(n-1).
+type Config struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+func ConfigFromEnv() Config {
+	config := Config{MaxAttempts: 5, BaseDelay: time.Second}
+	if maxAttempts, err := strconv.Atoi(os.Getenv("WORKER_MAX_ATTEMPTS")); err == nil && maxAttempts > 0 {
+		config.MaxAttempts = maxAttempts
+	}
+	if baseDelay, err := time.ParseDuration(os.Getenv("WORKER_RETRY_BASE_DELAY")); err == nil && baseDelay > 0 {
+		config.BaseDelay = baseDelay
+	}
+	return config
+}
+
+func (c Config) Delay(attempt int) time.Duration {
+	return c.BaseDelay * time.Duration(1<<uint(attempt-1))
+}
+
+// RetryQueue is named after its delay so changing the configuration declares
+// new queues instead of conflicting with the TTL of existing ones.
+func RetryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", TaskQueue, delay.Milliseconds())
+}
+
+// TaskQueueArgs must match the arguments the api declares the queue with.
+var TaskQueueArgs = amqp.Table{
+	"x-dead-letter-exchange": DeadLetterExchange,
+}
+
+// Declare creates the task queue, the dead-letter exchange with the parking
+// queue bound to it, and one retry queue per backoff step. Messages expire
+// from a retry queue back into the task queue.
+func Declare(ch *amqp.Channel, config Config) error {
+	_, err := ch.QueueDeclare(TaskQueue, true, false, false, false, TaskQueueArgs)
+	if err != nil {
+		return err
+	}
+	err = ch.ExchangeDeclare(DeadLetterExchange, "fanout", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclare(ParkingQueue, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	err = ch.QueueBind(ParkingQueue, "", DeadLetterExchange, false, nil)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; attempt < config.MaxAttempts; attempt++ {
+		delay := config.Delay(attempt)
+		_, err = ch.QueueDeclare(RetryQueue(delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": TaskQueue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package tracing sets up OpenTelemetry for the worker: spans are exported
+// over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT is set, and every delivery
+// is processed in the trace whose context the API put in its headers.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/vitorbiten/maintenance/worker"
+
+// Tracer starts the spans of the worker's own code.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup installs the tracer provider and returns its shutdown, which flushes
+// the pending spans. Without an OTLP endpoint spans are still created, so
+// trace ids reach the logs, they are just not exported.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(newResource(service))}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// UseInMemory installs a tracer provider that keeps the ended spans in the
+// returned exporter, for tests.
+func UseInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(newResource("maintenance-worker")),
+	))
+	otel.SetTextMapPropagator(propagator)
+	return exporter
+}
+
+func newResource(service string) *resource.Resource {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	return resource.NewSchemaless(semconv.ServiceName(service))
+}
+
+// Headers adapts AMQP headers to a propagation carrier.
+type Headers amqp.Table
+
+func (h Headers) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h Headers) Set(key, value string) {
+	h[key] = value
+}
+
+func (h Headers) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// StartDelivery starts the span processing delivery as a child of the
+// publish span of the API, retries and replays keep the headers so they
+// join the same trace.
+func StartDelivery(ctx context.Context, delivery amqp.Delivery, controller string, attempt int) (context.Context, trace.Span) {
+	ctx = propagator.Extract(ctx, Headers(delivery.Headers))
+	return Tracer().Start(ctx, delivery.RoutingKey+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(delivery.RoutingKey),
+			semconv.MessagingRabbitmqDestinationRoutingKey(delivery.RoutingKey),
+			attribute.String("messaging.controller", controller),
+			attribute.Int("messaging.attempt", attempt),
+		),
+	)
+}
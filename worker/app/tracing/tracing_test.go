@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStartDelivery(t *testing.T) {
+	spans := UseInMemory()
+	delivery := amqp.Delivery{
+		RoutingKey: "task_queue",
+		Headers: amqp.Table{
+			"controller":  "notification",
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+	ctx, span := StartDelivery(context.Background(), delivery, "notification", 1)
+	span.End()
+
+	if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the one of the traceparent header", got)
+	}
+	ended := spans.GetSpans()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want 1", len(ended))
+	}
+	if ended[0].Name != "task_queue process" || ended[0].SpanKind != trace.SpanKindConsumer {
+		t.Errorf("span = %s %s, want a task_queue process consumer span", ended[0].Name, ended[0].SpanKind)
+	}
+	if got := ended[0].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent = %s, want the publish span", got)
+	}
+
+	// a delivery without trace context starts a new trace
+	ctx, span = StartDelivery(context.Background(), amqp.Delivery{RoutingKey: "task_queue"}, "unknown", 1)
+	span.End()
+	if !trace.SpanContextFromContext(ctx).IsValid() || spans.GetSpans()[1].Parent.IsValid() {
+		t.Errorf("want a new root span")
+	}
+}